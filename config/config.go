@@ -1,43 +1,815 @@
-package config
-
-import (
-	"log"
-	"os"
-
-	"github.com/joho/godotenv"
-)
-
-type Config struct {
-	Port          string
-	RedisHost     string
-	RedisPort     string
-	RedisPassword string
-}
-
-func Load() *Config {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
-	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5051"
-	}
-
-	redisHost := os.Getenv("REDIS_HOST")
-	if redisHost == "" {
-		redisHost = "127.0.0.1"
-	}
-
-	redisPort := os.Getenv("REDIS_PORT")
-	if redisPort == "" {
-		redisPort = "6379"
-	}
-
-	return &Config{
-		Port:          port,
-		RedisHost:     redisHost,
-		RedisPort:     redisPort,
-		RedisPassword: os.Getenv("REDIS_PASSWORD"),
-	}
-}
+package config
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sms_service/phone"
+
+	"github.com/joho/godotenv"
+)
+
+// MaxOTPCodeHistory bounds OTPCodeHistory: every code kept valid is one
+// more guess that can succeed.
+const MaxOTPCodeHistory = 5
+
+type Config struct {
+	Port string
+	// TLSCertFile and TLSKeyFile, when both set, make the server speak HTTPS
+	// (and wss for Socket.IO) on Port. TLSRedirectPort, if also set, serves
+	// a plain HTTP listener that redirects every request to HTTPS.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSRedirectPort string
+	// CountryCode ("+993") and PhonePattern, the regular expression a
+	// national number must match in full, define the numbering plan phone
+	// numbers are validated against. Read at startup only.
+	CountryCode  string
+	PhonePattern string
+	// HTTPHandlerTimeout bounds each REST request, including writing the
+	// response to a slow client. Socket.IO and streamed /bulk-sms are not
+	// timed. 0 disables it.
+	HTTPHandlerTimeout time.Duration
+
+	RedisHost     string
+	RedisPort     string
+	RedisPassword string
+	// RedisConnectRetries is how many times startup pings Redis before
+	// giving up, waiting RedisRetryDelay after the first failure and
+	// doubling the wait after each one after that.
+	RedisConnectRetries int
+	RedisRetryDelay     time.Duration
+	// RedisDB selects the logical database. RedisPoolSize caps open
+	// connections; 0 keeps the go-redis default of 10 per CPU. The timeouts
+	// are whole seconds in the environment.
+	RedisDB           int
+	RedisPoolSize     int
+	RedisDialTimeout  time.Duration
+	RedisReadTimeout  time.Duration
+	RedisWriteTimeout time.Duration
+	// After RedisBreakerThreshold consecutive connection failures, store
+	// calls fail fast for RedisBreakerCooldown instead of waiting on dial
+	// timeouts; then one call is let through to probe. 0 disables it.
+	RedisBreakerThreshold int
+	RedisBreakerCooldown  time.Duration
+
+	// LogFormat is "text" (default, the classic "[TAG] Message | k=v" lines)
+	// or "json" (one object per line, for log aggregators).
+	LogFormat string
+	// LogSensitive logs full phone numbers and message bodies instead of
+	// masking them. For local debugging only.
+	LogSensitive bool
+
+	// MessagesFile optionally points at a JSON file of response translations
+	// that override or extend the built-in ones.
+	MessagesFile string
+	// DefaultLang is used when the caller's language is missing or unsupported.
+	DefaultLang string
+
+	// OTPStorageFormat selects how new OTP records are written: "json"
+	// (default) or "raw" for compatibility with older releases.
+	OTPStorageFormat string
+
+	// SendWaitTimeout bounds how long /send-sms?wait=true waits for a gateway
+	// to acknowledge delivery before answering "pending".
+	SendWaitTimeout time.Duration
+	// OTPConfirmDelivery makes /otp wait up to SendWaitTimeout for a
+	// gateway to ack the SMS and keep the code only if one confirms it.
+	OTPConfirmDelivery bool
+	// SMSBacklogSize caps the per-tenant Redis backlog that holds messages
+	// sent while none of the tenant's gateways is connected; they go out
+	// when one connects. 0 disables the backlog.
+	SMSBacklogSize int
+	// BulkSMSMaxBatch caps the entries in one /bulk-sms request; 0 lifts
+	// the cap.
+	BulkSMSMaxBatch int
+	// MaxSMSLength rejects messages longer than this many GSM-7 characters;
+	// UCS-2 messages get as many characters as fit in the same number of
+	// segments (612, four segments, allows 268). 0 lifts the limit.
+	MaxSMSLength int
+
+	// APIKeys maps each accepted API key to the tenant it belongs to. Keys
+	// configured without a tenant map to "". Empty means auth is disabled.
+	APIKeys map[string]string
+	// AdminAPIKey is the separate credential for operator routes (client
+	// listing, drain controls, admin emit), sent as X-Admin-Key. Empty
+	// closes those routes.
+	AdminAPIKey string
+
+	// SigningKeys maps a tenant to the secret used to sign its API responses
+	// and webhooks (see package signing). Tenants without one are unsigned.
+	SigningKeys map[string]string
+
+	// RedisKeyPrefix namespaces every key this service writes to Redis.
+	RedisKeyPrefix string
+	// MigrateFromPrefix, when MigratePrefix is set, is the previous
+	// RedisKeyPrefix whose unexpired OTPs, lockouts and cooldowns are
+	// copied over at startup.
+	// It may legitimately be empty (migrating from unprefixed keys).
+	MigrateFromPrefix string
+	MigratePrefix     bool
+
+	// MaxActiveOTPs caps how many OTPs may be outstanding system-wide at
+	// once; 0 disables the cap.
+	MaxActiveOTPs int
+
+	// OTPTTL is how long an issued code stays valid, unless an
+	// OTPPrefixRules entry sets its own.
+	OTPTTL time.Duration
+
+	// OTPMaxAttempts wrong codes delete a phone's code and lock it out of
+	// /compare for OTPLockout; 0 disables the lockout. The count starts
+	// over with every new code.
+	OTPMaxAttempts int
+	OTPLockout     time.Duration
+	// OTPCodeHistory is how many of a phone's most recent codes verify,
+	// each until its own expiry. With 1 (default) an active code blocks a
+	// new /otp (but see OTPResendCooldown); above 1 a new /otp replaces the
+	// code and keeps the previous ones valid. Wrong attempts count against
+	// all of them together. At most MaxOTPCodeHistory.
+	OTPCodeHistory int
+	// OTPResendCooldown is the minimum time between codes issued to one
+	// phone. When set, a new /otp once it has passed replaces the active
+	// code even though that is still valid; before then the caller is told
+	// how long to wait. 0 leaves the active code blocking until it expires.
+	OTPResendCooldown time.Duration
+	// OTPLength and OTPAlphabet shape generated codes for phones no
+	// OTPPrefixRules entry matches. 0 or an empty alphabet falls back to
+	// the classic 5-digit code.
+	OTPLength   int
+	OTPAlphabet string
+
+	// AllowedOrigins lists the browser origins permitted by CORS, read from
+	// the comma-separated ALLOWED_ORIGINS (entries are trimmed, blanks
+	// skipped); empty allows every origin. An entry like
+	// "https://*.example.com" allows every subdomain but not example.com.
+	AllowedOrigins []string
+	// CORSRejectMode is "json" (403 with a JSON body) or "headerless"
+	// (no CORS headers, letting the browser raise its own CORS error).
+	CORSRejectMode string
+	// CORSLogRejected logs every rejected origin, for tuning the allowlist.
+	CORSLogRejected bool
+	// CORSAllowHeaders and CORSAllowMethods are sent as
+	// Access-Control-Allow-Headers / -Methods (defaults "Content-Type,
+	// Authorization, X-API-Key, Idempotency-Key" and "GET, POST, OPTIONS").
+	// With CORSEchoRequestHeaders a preflight's
+	// Access-Control-Request-Headers is allowed as asked, so new custom
+	// headers need no config change.
+	CORSAllowHeaders       []string
+	CORSAllowMethods       []string
+	CORSEchoRequestHeaders bool
+	// CORSMaxAge lets browsers cache a preflight result this long
+	// (Access-Control-Max-Age); 0 omits the header.
+	CORSMaxAge time.Duration
+
+	// IPv6LimitPrefix is the prefix length IPv6 callers are grouped by for
+	// rate limiting (IPv4 callers are always limited per address).
+	IPv6LimitPrefix int
+	// MaxConcurrentPerIP caps the API requests one caller (keyed as for
+	// rate limiting) may have in flight at once; 0 disables the cap.
+	MaxConcurrentPerIP int
+	// RateLimits caps the requests one caller may make to a route within
+	// RateLimitWindow, keyed by route path ("/otp"); the "*" entry covers
+	// routes without their own. Routes with no limit are not counted.
+	RateLimits      map[string]int
+	RateLimitWindow time.Duration
+	// IdempotencyTTL is how long a /otp response is kept for replay to
+	// retries carrying the same Idempotency-Key; 0 ignores the header.
+	IdempotencyTTL time.Duration
+
+	// ReconcileInterval is how often the socket client map is checked
+	// against go-socket.io's live connections; 0 disables the check.
+	ReconcileInterval time.Duration
+	// SocketIdleTimeout flags clients that sent no event for that long,
+	// checked every SocketIdleCheckInterval; with SocketIdleDisconnect they
+	// are also closed. 0 disables the check.
+	SocketIdleTimeout       time.Duration
+	SocketIdleCheckInterval time.Duration
+	SocketIdleDisconnect    bool
+
+	// SocketAllowedEvents lists the event names clients may send; empty
+	// means "whatever has a registered handler".
+	SocketAllowedEvents []string
+	// SocketMaxDisallowedEvents disconnects a client after this many
+	// disallowed events; 0 only counts them.
+	SocketMaxDisallowedEvents int
+	// SocketErrorEvents lists inbound events whose malformed payloads are
+	// answered with an "error" event to the sender; for the others a bad
+	// payload is only logged and dropped.
+	SocketErrorEvents []string
+	// SocketQueueSize bounds each gateway's in-order send queue. When > 0,
+	// single-recipient messages go to one gateway at a time and wait for its
+	// "sended" instead of being broadcast; 0 keeps broadcasting.
+	SocketQueueSize int
+	// SendFailedMaxRedispatch is how many times a message a gateway reported
+	// "send_failed" for is handed to another gateway before it is given up
+	// on; 0 never re-sends.
+	SendFailedMaxRedispatch int
+	// MaxBroadcastFanout refuses any broadcast that would reach more than
+	// this many clients; 0 disables the check.
+	MaxBroadcastFanout int
+	// SocketPingInterval and SocketPingTimeout are passed to engine.io; a
+	// session that misses pings for SocketPingTimeout is closed. 0 keeps
+	// engine.io's defaults (20s / 60s).
+	SocketPingInterval time.Duration
+	SocketPingTimeout  time.Duration
+	// SocketTransports lists the engine.io transports offered, "polling"
+	// and/or "websocket" (default both). Websocket-only avoids the
+	// polling→websocket upgrade and its duplicate OnConnect, but clients
+	// must then connect with the websocket transport straight away. Read at
+	// startup only.
+	SocketTransports []string
+	// SocketTransientReasons lists disconnect reasons after which a gateway
+	// is expected to reconnect. For those, a gateway that identified itself
+	// with a device ID has its queued messages held for
+	// SocketReconnectGrace instead of being handed to other gateways at
+	// once. Any other reason is permanent. A 0 grace disables holding.
+	SocketTransientReasons []string
+	SocketReconnectGrace   time.Duration
+	// MaxClients caps connected Socket.IO clients; further connections are
+	// refused. 0 disables the cap.
+	MaxClients int
+	// SocketMaxPerDevice caps simultaneous connections sharing one device
+	// ID within a tenant; 0 disables the cap. When a new connection would
+	// exceed it, SocketDeviceLimitMode "evict" closes the oldest ones and
+	// "reject" refuses the new one.
+	SocketMaxPerDevice    int
+	SocketDeviceLimitMode string
+	// SocketMinFirmware maps a message type to the lowest gateway firmware
+	// version that may receive it, configured as "type:version" pairs, e.g.
+	// "transactional:2.1,ack:2.3". Types are message categories (otp,
+	// group, transactional) and "ack" for sends that wait for a delivery
+	// ack. Gateways below the minimum, or that report no version, are
+	// skipped for that message.
+	SocketMinFirmware map[string]string
+	// SocketRoomPrefixes maps a phone prefix to the room whose gateways
+	// send to it, configured as "prefix:room" pairs, e.g.
+	// "+99361:ashgabat,+99365:mary". The longest matching prefix wins.
+	SocketRoomPrefixes map[string]string
+
+	// OTPAllowCodeReturn enables POST /otp/create, which stores a code and
+	// returns it to an API-key caller instead of sending it by SMS.
+	OTPAllowCodeReturn bool
+
+	// OTPPrefixRules is a JSON array of per-phone-prefix OTP overrides, e.g.
+	// [{"prefix":"61","length":4},{"prefix":"65","length":6,"ttl_seconds":600}].
+	// Validated when the handler is built.
+	OTPPrefixRules string
+	// OTPTemplates is a JSON object of OTP message templates selectable per
+	// request by template_key, e.g. {"login":"Login code: {{code}}"}.
+	// Validated when the handler is built.
+	OTPTemplates string
+	// OTPMessageTemplate replaces the built-in Turkmen text of the "default"
+	// OTP template; it must contain {{code}}. An OTP_TEMPLATES "default"
+	// entry still takes precedence.
+	OTPMessageTemplate string
+	// OTPLangTemplates is a JSON object of language to OTP wording, chosen
+	// by the /otp "lang" field, e.g. {"ru":"Код: {{code}}"}; it extends and
+	// overrides the built-in tk, ru and en wordings. OTPDefaultLang is used
+	// when lang is missing or unknown, and its wording is the "default"
+	// template unless OTPLangTemplates sets it.
+	OTPLangTemplates string
+	OTPDefaultLang   string
+
+	// WarmupPeriod keeps /ready at 503 for this long after start so gateways
+	// can reconnect before traffic arrives. WarmupEndOnClient ends the
+	// warmup early once the first gateway connects.
+	WarmupPeriod      time.Duration
+	WarmupEndOnClient bool
+
+	// EmitDedupWindow suppresses a second send of the same message to the
+	// same phone within this window; 0 disables the check.
+	EmitDedupWindow time.Duration
+
+	// GroupSMSCooldown is the minimum time between /group_sms broadcasts;
+	// 0 disables it. GroupSMSCooldownScope is "global" (one cooldown shared
+	// by every caller) or "tenant" (one per API key tenant).
+	GroupSMSCooldown      time.Duration
+	GroupSMSCooldownScope string
+
+	// CallbackAllowedHosts lists the hosts a send's callback_url may point
+	// at ("*.example.com" allows subdomains); empty disables callbacks.
+	// Each event is tried up to CallbackMaxAttempts times, each attempt
+	// bounded by CallbackTimeout.
+	CallbackAllowedHosts []string
+	CallbackTimeout      time.Duration
+	CallbackMaxAttempts  int
+	// SMSGatewayURL is an HTTP SMS gateway OTPs are sent through when no
+	// Socket.IO gateway is connected; empty disables the fallback.
+	// SMSGatewayMethod defaults to POST; SMSGatewayAuthHeader is an
+	// optional "Header: value" sent with every request.
+	SMSGatewayURL        string
+	SMSGatewayMethod     string
+	SMSGatewayAuthHeader string
+	SMSGatewayTimeout    time.Duration
+	// DeliveryWebhookURL receives a report each time a gateway says it sent
+	// a message ("sended"), with the same timeout and retries as callbacks;
+	// empty disables it.
+	DeliveryWebhookURL string
+
+	// EventBus names the message bus OTP/SMS lifecycle events are exported
+	// to: "nats", "log" or "" (disabled). Events are published to
+	// EventBusTopic at EventBusAddr; up to EventBusQueueSize events wait in
+	// memory, beyond that they are dropped rather than slowing requests.
+	EventBus          string
+	EventBusAddr      string
+	EventBusTopic     string
+	EventBusQueueSize int
+
+	// HealthFormat shapes the GET /health body: "json" (default,
+	// {"status":"ok"} plus HealthFields) or "text" (HealthText as plain
+	// text), for load balancers that expect a fixed response.
+	HealthFormat string
+	HealthText   string
+	// HealthFields are extra static fields for the JSON /health body,
+	// configured as "key:value" pairs. They cannot replace "status".
+	HealthFields map[string]string
+
+	// StatsEnabled serves the GET /stats counter snapshot.
+	StatsEnabled bool
+	// AdminEmitEnabled serves POST /admin/emit, which broadcasts any event
+	// to every gateway. For testing only; off by default.
+	AdminEmitEnabled bool
+	// CrashSnapshotPath, when set, is where the connected clients and their
+	// undelivered messages are written if the process dies of a panic, or
+	// when one is recovered in an HTTP handler, a Socket.IO callback or the
+	// serve loop. Each snapshot replaces the last.
+	CrashSnapshotPath string
+
+	// GinMode is gin's run mode: "release" (default), "debug" or "test".
+	GinMode string
+
+	// LogDebug enables verbose [DEBUG] log lines.
+	LogDebug bool
+}
+
+// Load reads the optional .env file and builds the config from the
+// environment. A missing .env file is fine; one that exists but cannot be
+// read or parsed is an error, so a typo never starts a half-configured
+// server.
+func Load() (*Config, error) {
+	err := godotenv.Load()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Println("No .env file found, using environment variables")
+	case err != nil:
+		return nil, fmt.Errorf("load .env: %w", err)
+	}
+	return fromEnv(), nil
+}
+
+// Reload re-reads the .env file, letting its values override the process
+// environment (which cannot change after start), and rebuilds the config.
+// A missing .env file is not an error.
+func Reload() (*Config, error) {
+	if err := godotenv.Overload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("load .env: %w", err)
+	}
+	return fromEnv(), nil
+}
+
+func fromEnv() *Config {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "5051"
+	}
+
+	redisHost := os.Getenv("REDIS_HOST")
+	if redisHost == "" {
+		redisHost = "127.0.0.1"
+	}
+
+	redisPort := os.Getenv("REDIS_PORT")
+	if redisPort == "" {
+		redisPort = "6379"
+	}
+
+	otpDefaultLang := strings.ToLower(os.Getenv("OTP_DEFAULT_LANG"))
+	if otpDefaultLang == "" {
+		otpDefaultLang = "tk"
+	}
+
+	defaultLang := os.Getenv("DEFAULT_LANG")
+	if defaultLang == "" {
+		defaultLang = "en"
+	}
+
+	otpStorageFormat := os.Getenv("OTP_STORAGE_FORMAT")
+	if otpStorageFormat == "" {
+		otpStorageFormat = "json"
+	}
+
+	migrateFrom, migrate := os.LookupEnv("MIGRATE_FROM_PREFIX")
+
+	// go-socket.io reports "client namespace disconnect" when the server
+	// side closes a connection (transport error, ping timeout), and the
+	// client's own reason, usually empty, when it disconnects on purpose.
+	socketTransientReasons := getEnvList("SOCKET_TRANSIENT_REASONS")
+	if len(socketTransientReasons) == 0 {
+		socketTransientReasons = []string{"client namespace disconnect", "transport close", "ping timeout"}
+	}
+
+	groupSMSCooldownScope := os.Getenv("GROUP_SMS_COOLDOWN_SCOPE")
+	if groupSMSCooldownScope == "" {
+		groupSMSCooldownScope = "global"
+	}
+
+	socketDeviceLimitMode := os.Getenv("SOCKET_DEVICE_LIMIT_MODE")
+	if socketDeviceLimitMode == "" {
+		socketDeviceLimitMode = "evict"
+	}
+
+	eventBusTopic := os.Getenv("EVENT_BUS_TOPIC")
+	if eventBusTopic == "" {
+		eventBusTopic = "sms.events"
+	}
+
+	healthFormat := os.Getenv("HEALTH_FORMAT")
+	if healthFormat == "" {
+		healthFormat = "json"
+	}
+	healthText := os.Getenv("HEALTH_TEXT")
+	if healthText == "" {
+		healthText = "OK"
+	}
+
+	ginMode := os.Getenv("GIN_MODE")
+	switch ginMode {
+	case "debug", "release", "test":
+	default:
+		if ginMode != "" {
+			log.Printf("Invalid GIN_MODE=%q, using release", ginMode)
+		}
+		ginMode = "release"
+	}
+
+	otpCodeHistory := getEnvInt("OTP_CODE_HISTORY", 1)
+	if otpCodeHistory < 1 || otpCodeHistory > MaxOTPCodeHistory {
+		log.Printf("Invalid OTP_CODE_HISTORY=%d, must be 1-%d, using 1", otpCodeHistory, MaxOTPCodeHistory)
+		otpCodeHistory = 1
+	}
+
+	redisRetries := getEnvInt("REDIS_CONNECT_RETRIES", 5)
+	if redisRetries < 1 {
+		log.Printf("Invalid REDIS_CONNECT_RETRIES=%d, using 1", redisRetries)
+		redisRetries = 1
+	}
+
+	otpTTL := getEnvInt("OTP_TTL_SECONDS", 1800)
+	if otpTTL <= 0 {
+		log.Printf("Invalid OTP_TTL_SECONDS=%d, using 1800", otpTTL)
+		otpTTL = 1800
+	}
+
+	otpLength := getEnvInt("OTP_LENGTH", 5)
+	if otpLength < 0 {
+		log.Printf("Invalid OTP_LENGTH=%d, using 5", otpLength)
+		otpLength = 5
+	}
+	otpAlphabet := os.Getenv("OTP_ALPHABET")
+	if otpAlphabet == "" {
+		otpAlphabet = "0123456789"
+	}
+
+	logFormat := os.Getenv("LOG_FORMAT")
+	switch logFormat {
+	case "text", "json":
+	default:
+		if logFormat != "" {
+			log.Printf("Invalid LOG_FORMAT=%q, using text", logFormat)
+		}
+		logFormat = "text"
+	}
+
+	// One of the pair alone is almost always a typo; say so instead of
+	// quietly serving plain HTTP.
+	if (os.Getenv("TLS_CERT_FILE") == "") != (os.Getenv("TLS_KEY_FILE") == "") {
+		log.Printf("Only one of TLS_CERT_FILE and TLS_KEY_FILE is set, serving plain HTTP")
+	}
+
+	countryCode := os.Getenv("COUNTRY_CODE")
+	if countryCode == "" {
+		countryCode = phone.DefaultCountryCode
+	}
+	phonePattern := os.Getenv("PHONE_PATTERN")
+	if phonePattern == "" {
+		phonePattern = phone.DefaultPattern
+	}
+
+	var socketTransports []string
+	for _, t := range getEnvList("SOCKET_TRANSPORTS") {
+		switch t = strings.ToLower(t); t {
+		case "polling", "websocket":
+			socketTransports = append(socketTransports, t)
+		default:
+			log.Printf("Invalid SOCKET_TRANSPORTS entry %q, ignoring", t)
+		}
+	}
+	if len(socketTransports) == 0 {
+		socketTransports = []string{"polling", "websocket"}
+	}
+
+	corsAllowHeaders := getEnvList("CORS_ALLOW_HEADERS")
+	if len(corsAllowHeaders) == 0 {
+		corsAllowHeaders = []string{"Content-Type", "Authorization", "X-API-Key", "Idempotency-Key"}
+	}
+	corsAllowMethods := getEnvList("CORS_ALLOW_METHODS")
+	if len(corsAllowMethods) == 0 {
+		corsAllowMethods = []string{"GET", "POST", "OPTIONS"}
+	}
+
+	corsRejectMode := os.Getenv("CORS_REJECT_MODE")
+	if corsRejectMode == "" {
+		corsRejectMode = "json"
+	}
+
+	return &Config{
+		Port:            port,
+		TLSCertFile:     os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:      os.Getenv("TLS_KEY_FILE"),
+		TLSRedirectPort: os.Getenv("TLS_REDIRECT_PORT"),
+
+		CountryCode:  countryCode,
+		PhonePattern: phonePattern,
+
+		HTTPHandlerTimeout: time.Duration(getEnvInt("HTTP_HANDLER_TIMEOUT_SECONDS", 30)) * time.Second,
+
+		RedisHost:     redisHost,
+		RedisPort:     redisPort,
+		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+
+		LogFormat:    logFormat,
+		LogSensitive: os.Getenv("LOG_SENSITIVE") == "true",
+
+		RedisConnectRetries: redisRetries,
+		RedisRetryDelay:     time.Duration(getEnvInt("REDIS_CONNECT_RETRY_DELAY_MS", 500)) * time.Millisecond,
+		RedisDB:             getEnvInt("REDIS_DB", 0),
+		RedisPoolSize:       getEnvInt("REDIS_POOL_SIZE", 0),
+		RedisDialTimeout:    time.Duration(getEnvInt("REDIS_DIAL_TIMEOUT", 5)) * time.Second,
+		RedisReadTimeout:    time.Duration(getEnvInt("REDIS_READ_TIMEOUT", 3)) * time.Second,
+		RedisWriteTimeout:   time.Duration(getEnvInt("REDIS_WRITE_TIMEOUT", 3)) * time.Second,
+
+		RedisBreakerThreshold: getEnvInt("REDIS_BREAKER_THRESHOLD", 5),
+		RedisBreakerCooldown:  time.Duration(getEnvInt("REDIS_BREAKER_COOLDOWN_SECONDS", 10)) * time.Second,
+
+		MessagesFile: os.Getenv("MESSAGES_FILE"),
+		DefaultLang:  defaultLang,
+
+		OTPStorageFormat:   otpStorageFormat,
+		SendWaitTimeout:    time.Duration(getEnvInt("SEND_WAIT_TIMEOUT_SECONDS", 10)) * time.Second,
+		OTPConfirmDelivery: os.Getenv("OTP_CONFIRM_DELIVERY") == "true",
+		SMSBacklogSize:     getEnvInt("SMS_BACKLOG_SIZE", 0),
+		BulkSMSMaxBatch:    getEnvInt("BULK_SMS_MAX_BATCH", 100),
+		MaxSMSLength:       getEnvInt("MAX_SMS_LENGTH", 612),
+		APIKeys:            parseAPIKeys(os.Getenv("API_KEYS")),
+		AdminAPIKey:        os.Getenv("ADMIN_API_KEY"),
+		SigningKeys:        parseSigningKeys(os.Getenv("SIGNING_KEYS")),
+
+		RedisKeyPrefix:    os.Getenv("REDIS_KEY_PREFIX"),
+		MigrateFromPrefix: migrateFrom,
+		MigratePrefix:     migrate,
+
+		MaxActiveOTPs: getEnvInt("MAX_ACTIVE_OTPS", 0),
+
+		OTPTTL: time.Duration(otpTTL) * time.Second,
+
+		OTPMaxAttempts: getEnvInt("OTP_MAX_ATTEMPTS", 5),
+		OTPLockout:     time.Duration(getEnvInt("OTP_LOCKOUT_SECONDS", 900)) * time.Second,
+		OTPCodeHistory: otpCodeHistory,
+
+		OTPResendCooldown: time.Duration(getEnvInt("OTP_RESEND_COOLDOWN_SECONDS", 0)) * time.Second,
+
+		OTPLength:   otpLength,
+		OTPAlphabet: otpAlphabet,
+
+		AllowedOrigins:  getEnvList("ALLOWED_ORIGINS"),
+		CORSRejectMode:  corsRejectMode,
+		CORSLogRejected: os.Getenv("CORS_LOG_REJECTED") == "true",
+
+		CORSAllowHeaders:       corsAllowHeaders,
+		CORSAllowMethods:       corsAllowMethods,
+		CORSEchoRequestHeaders: os.Getenv("CORS_ECHO_REQUEST_HEADERS") == "true",
+		CORSMaxAge:             time.Duration(getEnvInt("CORS_MAX_AGE_SECONDS", 600)) * time.Second,
+
+		IPv6LimitPrefix:    getEnvInt("IPV6_LIMIT_PREFIX", 64),
+		MaxConcurrentPerIP: getEnvInt("MAX_CONCURRENT_PER_IP", 0),
+		RateLimits:         parseRateLimits(os.Getenv("RATE_LIMITS")),
+		RateLimitWindow:    time.Duration(getEnvInt("RATE_LIMIT_WINDOW_SECONDS", 60)) * time.Second,
+		IdempotencyTTL:     time.Duration(getEnvInt("IDEMPOTENCY_TTL_SECONDS", 120)) * time.Second,
+
+		ReconcileInterval: time.Duration(getEnvInt("RECONCILE_INTERVAL_SECONDS", 60)) * time.Second,
+
+		SocketIdleTimeout:       time.Duration(getEnvInt("SOCKET_IDLE_TIMEOUT_SECONDS", 0)) * time.Second,
+		SocketIdleCheckInterval: time.Duration(getEnvInt("SOCKET_IDLE_CHECK_SECONDS", 30)) * time.Second,
+		SocketIdleDisconnect:    os.Getenv("SOCKET_IDLE_DISCONNECT") == "true",
+
+		SocketAllowedEvents:       getEnvList("SOCKET_ALLOWED_EVENTS"),
+		SocketMaxDisallowedEvents: getEnvInt("SOCKET_MAX_DISALLOWED_EVENTS", 0),
+		SocketErrorEvents:         getEnvList("SOCKET_ERROR_EVENTS"),
+		SocketQueueSize:           getEnvInt("SOCKET_QUEUE_SIZE", 0),
+		SendFailedMaxRedispatch:   getEnvInt("SEND_FAILED_MAX_REDISPATCH", 2),
+		MaxBroadcastFanout:        getEnvInt("MAX_BROADCAST_FANOUT", 0),
+		SocketPingInterval:        time.Duration(getEnvInt("SOCKET_PING_INTERVAL_SECONDS", 0)) * time.Second,
+		SocketPingTimeout:         time.Duration(getEnvInt("SOCKET_PING_TIMEOUT_SECONDS", 0)) * time.Second,
+		SocketTransports:          socketTransports,
+		SocketTransientReasons:    socketTransientReasons,
+		SocketReconnectGrace:      time.Duration(getEnvInt("SOCKET_RECONNECT_GRACE_SECONDS", 0)) * time.Second,
+		MaxClients:                getEnvInt("MAX_CLIENTS", 0),
+		SocketMaxPerDevice:        getEnvInt("SOCKET_MAX_CONNECTIONS_PER_DEVICE", 1),
+		SocketDeviceLimitMode:     socketDeviceLimitMode,
+		SocketMinFirmware:         parsePairs(os.Getenv("SOCKET_MIN_FIRMWARE")),
+		SocketRoomPrefixes:        parsePairs(os.Getenv("SOCKET_ROOM_PREFIXES")),
+
+		OTPAllowCodeReturn: os.Getenv("OTP_ALLOW_CODE_RETURN") == "true",
+
+		OTPPrefixRules: os.Getenv("OTP_PREFIX_RULES"),
+		OTPTemplates:   os.Getenv("OTP_TEMPLATES"),
+
+		OTPMessageTemplate: os.Getenv("OTP_MESSAGE_TEMPLATE"),
+		OTPLangTemplates:   os.Getenv("OTP_LANG_TEMPLATES"),
+		OTPDefaultLang:     otpDefaultLang,
+
+		WarmupPeriod:      time.Duration(getEnvInt("WARMUP_SECONDS", 0)) * time.Second,
+		WarmupEndOnClient: os.Getenv("WARMUP_END_ON_CLIENT") != "false",
+
+		EmitDedupWindow: time.Duration(getEnvInt("EMIT_DEDUP_SECONDS", 0)) * time.Second,
+
+		GroupSMSCooldown:      time.Duration(getEnvInt("GROUP_SMS_COOLDOWN_SECONDS", 0)) * time.Second,
+		GroupSMSCooldownScope: groupSMSCooldownScope,
+
+		CallbackAllowedHosts: getEnvList("CALLBACK_ALLOWED_HOSTS"),
+		CallbackTimeout:      time.Duration(getEnvInt("CALLBACK_TIMEOUT_SECONDS", 5)) * time.Second,
+		CallbackMaxAttempts:  getEnvInt("CALLBACK_MAX_ATTEMPTS", 5),
+		DeliveryWebhookURL:   os.Getenv("DELIVERY_WEBHOOK_URL"),
+
+		SMSGatewayURL:        os.Getenv("SMS_GATEWAY_URL"),
+		SMSGatewayMethod:     os.Getenv("SMS_GATEWAY_METHOD"),
+		SMSGatewayAuthHeader: os.Getenv("SMS_GATEWAY_AUTH_HEADER"),
+		SMSGatewayTimeout:    time.Duration(getEnvInt("SMS_GATEWAY_TIMEOUT_SECONDS", 5)) * time.Second,
+
+		EventBus:          os.Getenv("EVENT_BUS"),
+		EventBusAddr:      os.Getenv("EVENT_BUS_ADDR"),
+		EventBusTopic:     eventBusTopic,
+		EventBusQueueSize: getEnvInt("EVENT_BUS_QUEUE_SIZE", 1000),
+
+		HealthFormat: healthFormat,
+		HealthText:   healthText,
+		HealthFields: parsePairs(os.Getenv("HEALTH_FIELDS")),
+
+		StatsEnabled:      os.Getenv("STATS_ENABLED") != "false",
+		AdminEmitEnabled:  os.Getenv("ENABLE_ADMIN_EMIT") == "true",
+		CrashSnapshotPath: os.Getenv("CRASH_SNAPSHOT_PATH"),
+
+		GinMode:  ginMode,
+		LogDebug: os.Getenv("LOG_DEBUG") == "true",
+	}
+}
+
+// getEnvList reads a comma-separated env var, trimming whitespace around
+// each entry and dropping empty ones.
+func getEnvList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// LookupAPIKey returns the tenant that owns key. Keys are compared in
+// constant time so response timing does not leak valid key prefixes.
+func (c *Config) LookupAPIKey(key string) (tenant string, ok bool) {
+	for k, t := range c.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			tenant, ok = t, true
+		}
+	}
+	return tenant, ok
+}
+
+// IsAdminKey reports whether key is the configured admin key, compared in
+// constant time. It is always false when no admin key is set.
+func (c *Config) IsAdminKey(key string) bool {
+	return c.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(c.AdminAPIKey), []byte(key)) == 1
+}
+
+// parseAPIKeys parses a comma-separated list of "tenant:key" or bare "key"
+// entries.
+func parseAPIKeys(raw string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tenant, key, found := strings.Cut(entry, ":")
+		if !found {
+			tenant, key = "", entry
+		}
+		keys[strings.TrimSpace(key)] = strings.TrimSpace(tenant)
+	}
+	return keys
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// SigningKey returns the signing secret configured for tenant.
+func (c *Config) SigningKey(tenant string) (string, bool) {
+	secret, ok := c.SigningKeys[tenant]
+	return secret, ok
+}
+
+// parseSigningKeys parses a comma-separated list of "tenant:secret" entries;
+// a bare "secret" belongs to the default tenant "".
+func parseSigningKeys(raw string) map[string]string {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tenant, secret, found := strings.Cut(entry, ":")
+		if !found {
+			tenant, secret = "", entry
+		}
+		keys[strings.TrimSpace(tenant)] = strings.TrimSpace(secret)
+	}
+	return keys
+}
+
+// RoomFor returns the gateway room for phone, or "" when no prefix in
+// SocketRoomPrefixes matches it.
+func (c *Config) RoomFor(phone string) string {
+	room, best := "", -1
+	for prefix, r := range c.SocketRoomPrefixes {
+		if len(prefix) > best && strings.HasPrefix(phone, prefix) {
+			room, best = r, len(prefix)
+		}
+	}
+	return room
+}
+
+// RateLimit returns the request limit for route, falling back to the "*"
+// entry; 0 means unlimited.
+func (c *Config) RateLimit(route string) int {
+	if n, ok := c.RateLimits[route]; ok {
+		return n
+	}
+	return c.RateLimits["*"]
+}
+
+// parseRateLimits parses a comma-separated list of "route:limit" entries,
+// e.g. "/otp:5,*:120". Entries with a non-numeric or negative limit are
+// logged and skipped.
+func parseRateLimits(raw string) map[string]int {
+	limits := make(map[string]int)
+	for route, v := range parsePairs(raw) {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("Invalid RATE_LIMITS entry %q, ignoring", route+":"+v)
+			continue
+		}
+		limits[route] = n
+	}
+	return limits
+}
+
+// parsePairs parses a comma-separated list of "key:value" entries; entries
+// without a colon are ignored.
+func parsePairs(raw string) map[string]string {
+	pairs := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		k, v, found := strings.Cut(entry, ":")
+		if k = strings.TrimSpace(k); !found || k == "" {
+			continue
+		}
+		pairs[k] = strings.TrimSpace(v)
+	}
+	return pairs
+}
+
+// getEnvInt reads an integer env var, falling back to def when the variable
+// is unset or not a valid integer.
+func getEnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %d", key, v, def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Features summarises the behaviour a deployment has switched on. It is
+// logged at startup and served at /health/detail so differences between
+// environments are visible without reading their env files.
+type Features struct {
+	APIKeyAuth       bool     `json:"api_key_auth"`
+	TenantMode       bool     `json:"tenant_mode"`
+	SignedResponses  bool     `json:"signed_responses"`
+	Callbacks        bool     `json:"callbacks"`
+	HTTPGateway      bool     `json:"http_gateway"`
+	AdminEmit        bool     `json:"admin_emit"`
+	AdminAuth        bool     `json:"admin_auth"`
+	EventBus         string   `json:"event_bus"`
+	StoreBackend     string   `json:"store_backend"`
+	OTPStorageFormat string   `json:"otp_storage_format"`
+	RedisKeyPrefix   string   `json:"redis_key_prefix"`
+	DefaultLang      string   `json:"default_lang"`
+	CustomMessages   bool     `json:"custom_messages"`
+	SendWaitTimeout  string   `json:"send_wait_timeout"`
+	MaxActiveOTPs    int      `json:"max_active_otps"`
+	OTPMaxAttempts   int      `json:"otp_max_attempts"`
+	OTPCodeReturn    bool     `json:"otp_code_return"`
+	OTPConfirm       bool     `json:"otp_confirm_delivery"`
+	EmitDedupWindow  string   `json:"emit_dedup_window"`
+	GroupSMSCooldown string   `json:"group_sms_cooldown"`
+	SocketQueueSize  int      `json:"socket_queue_size"`
+	Transports       []string `json:"transports"`
+}
+
+// Features derives the feature summary from the config. Transports are
+// owned by the socket layer and filled in by the caller.
+func (c *Config) Features() Features {
+	tenantMode := false
+	for _, tenant := range c.APIKeys {
+		if tenant != "" {
+			tenantMode = true
+			break
+		}
+	}
+	return Features{
+		APIKeyAuth:       len(c.APIKeys) > 0,
+		TenantMode:       tenantMode,
+		SignedResponses:  len(c.SigningKeys) > 0,
+		Callbacks:        len(c.CallbackAllowedHosts) > 0,
+		HTTPGateway:      c.SMSGatewayURL != "",
+		AdminEmit:        c.AdminEmitEnabled,
+		AdminAuth:        c.AdminAPIKey != "",
+		EventBus:         c.EventBus,
+		StoreBackend:     "redis",
+		OTPStorageFormat: c.OTPStorageFormat,
+		RedisKeyPrefix:   c.RedisKeyPrefix,
+		DefaultLang:      c.DefaultLang,
+		CustomMessages:   c.MessagesFile != "",
+		SendWaitTimeout:  c.SendWaitTimeout.String(),
+		MaxActiveOTPs:    c.MaxActiveOTPs,
+		OTPMaxAttempts:   c.OTPMaxAttempts,
+		OTPCodeReturn:    c.OTPAllowCodeReturn,
+		OTPConfirm:       c.OTPConfirmDelivery,
+		EmitDedupWindow:  c.EmitDedupWindow.String(),
+		GroupSMSCooldown: c.GroupSMSCooldown.String(),
+		SocketQueueSize:  c.SocketQueueSize,
+	}
+}
+
+// String renders the summary in the service's "key=value | ..." log style.
+func (f Features) String() string {
+	return strings.Join([]string{
+		fmt.Sprintf("api_key_auth=%t", f.APIKeyAuth),
+		fmt.Sprintf("tenant_mode=%t", f.TenantMode),
+		fmt.Sprintf("signed_responses=%t", f.SignedResponses),
+		fmt.Sprintf("callbacks=%t", f.Callbacks),
+		fmt.Sprintf("http_gateway=%t", f.HTTPGateway),
+		fmt.Sprintf("admin_emit=%t", f.AdminEmit),
+		fmt.Sprintf("event_bus=%q", f.EventBus),
+		fmt.Sprintf("store_backend=%s", f.StoreBackend),
+		fmt.Sprintf("otp_storage_format=%s", f.OTPStorageFormat),
+		fmt.Sprintf("redis_key_prefix=%q", f.RedisKeyPrefix),
+		fmt.Sprintf("default_lang=%s", f.DefaultLang),
+		fmt.Sprintf("custom_messages=%t", f.CustomMessages),
+		fmt.Sprintf("send_wait_timeout=%s", f.SendWaitTimeout),
+		fmt.Sprintf("max_active_otps=%d", f.MaxActiveOTPs),
+		fmt.Sprintf("otp_max_attempts=%d", f.OTPMaxAttempts),
+		fmt.Sprintf("otp_code_return=%t", f.OTPCodeReturn),
+		fmt.Sprintf("otp_confirm_delivery=%t", f.OTPConfirm),
+		fmt.Sprintf("emit_dedup_window=%s", f.EmitDedupWindow),
+		fmt.Sprintf("group_sms_cooldown=%s", f.GroupSMSCooldown),
+		fmt.Sprintf("socket_queue_size=%d", f.SocketQueueSize),
+		fmt.Sprintf("transports=%s", strings.Join(f.Transports, ",")),
+	}, " | ")
+}
@@ -0,0 +1,95 @@
+package config
+
+import "sync/atomic"
+
+// Live holds the running configuration and lets it be replaced while the
+// service runs (see WithHot). Readers call Get per request, so a swap takes
+// effect for the next request without locks.
+type Live struct {
+	p atomic.Pointer[Config]
+}
+
+// NewLive returns a Live holding cfg.
+func NewLive(cfg *Config) *Live {
+	l := &Live{}
+	l.p.Store(cfg)
+	return l
+}
+
+// Get returns the current configuration. Callers must not modify it.
+func (l *Live) Get() *Config {
+	return l.p.Load()
+}
+
+// Set replaces the current configuration.
+func (l *Live) Set(cfg *Config) {
+	l.p.Store(cfg)
+}
+
+// WithHot returns a copy of c with the settings that can change without a
+// restart taken from next: API and signing keys, CORS, OTP templates, rules
+// and limits, dedup, group SMS cooldown, rate-limit keying and concurrency,
+// callback hosts, socket event and device policy, broadcast cap and debug
+// logging. Everything bound at startup — listen port, Redis, key prefix,
+// engine.io timeouts, queues — keeps its current value.
+func (c *Config) WithHot(next *Config) *Config {
+	out := *c
+	out.APIKeys = next.APIKeys
+	out.AdminAPIKey = next.AdminAPIKey
+	out.SigningKeys = next.SigningKeys
+	out.AllowedOrigins = next.AllowedOrigins
+	out.CORSRejectMode = next.CORSRejectMode
+	out.CORSLogRejected = next.CORSLogRejected
+	out.CORSAllowHeaders = next.CORSAllowHeaders
+	out.CORSAllowMethods = next.CORSAllowMethods
+	out.CORSEchoRequestHeaders = next.CORSEchoRequestHeaders
+	out.CORSMaxAge = next.CORSMaxAge
+	out.OTPPrefixRules = next.OTPPrefixRules
+	out.OTPAllowCodeReturn = next.OTPAllowCodeReturn
+	out.OTPTemplates = next.OTPTemplates
+	out.OTPMessageTemplate = next.OTPMessageTemplate
+	out.OTPLangTemplates = next.OTPLangTemplates
+	out.OTPDefaultLang = next.OTPDefaultLang
+	out.MaxActiveOTPs = next.MaxActiveOTPs
+	out.OTPMaxAttempts = next.OTPMaxAttempts
+	out.OTPLockout = next.OTPLockout
+	out.OTPTTL = next.OTPTTL
+	out.OTPCodeHistory = next.OTPCodeHistory
+	out.OTPResendCooldown = next.OTPResendCooldown
+	out.OTPLength = next.OTPLength
+	out.OTPAlphabet = next.OTPAlphabet
+	out.EmitDedupWindow = next.EmitDedupWindow
+	out.GroupSMSCooldown = next.GroupSMSCooldown
+	out.GroupSMSCooldownScope = next.GroupSMSCooldownScope
+	out.SendWaitTimeout = next.SendWaitTimeout
+	out.OTPConfirmDelivery = next.OTPConfirmDelivery
+	out.SMSBacklogSize = next.SMSBacklogSize
+	out.BulkSMSMaxBatch = next.BulkSMSMaxBatch
+	out.MaxSMSLength = next.MaxSMSLength
+	out.LogSensitive = next.LogSensitive
+	out.IPv6LimitPrefix = next.IPv6LimitPrefix
+	out.MaxConcurrentPerIP = next.MaxConcurrentPerIP
+	out.RateLimits = next.RateLimits
+	out.RateLimitWindow = next.RateLimitWindow
+	out.IdempotencyTTL = next.IdempotencyTTL
+	out.SendFailedMaxRedispatch = next.SendFailedMaxRedispatch
+	out.CallbackAllowedHosts = next.CallbackAllowedHosts
+	out.DeliveryWebhookURL = next.DeliveryWebhookURL
+	out.SMSGatewayURL = next.SMSGatewayURL
+	out.SMSGatewayMethod = next.SMSGatewayMethod
+	out.SMSGatewayAuthHeader = next.SMSGatewayAuthHeader
+	out.SMSGatewayTimeout = next.SMSGatewayTimeout
+	out.MaxBroadcastFanout = next.MaxBroadcastFanout
+	out.SocketAllowedEvents = next.SocketAllowedEvents
+	out.SocketMaxDisallowedEvents = next.SocketMaxDisallowedEvents
+	out.SocketErrorEvents = next.SocketErrorEvents
+	out.SocketMaxPerDevice = next.SocketMaxPerDevice
+	out.MaxClients = next.MaxClients
+	out.SocketIdleTimeout = next.SocketIdleTimeout
+	out.SocketIdleDisconnect = next.SocketIdleDisconnect
+	out.SocketDeviceLimitMode = next.SocketDeviceLimitMode
+	out.SocketMinFirmware = next.SocketMinFirmware
+	out.SocketRoomPrefixes = next.SocketRoomPrefixes
+	out.LogDebug = next.LogDebug
+	return &out
+}
@@ -0,0 +1,207 @@
+// Package eventbus exports OTP and SMS lifecycle events to a message bus
+// (NATS, Kafka, ...) for analytics.
+//
+// Export is best-effort and never blocks the request that produced an
+// event: events go into a bounded in-memory queue drained by one background
+// worker, and when the bus is slow and the queue is full new events are
+// dropped and counted instead of waiting. The transport is a Publisher, so a
+// new bus only needs that interface implemented.
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Event types.
+const (
+	// TypeCreated: an OTP was generated and stored.
+	TypeCreated = "created"
+	// TypeSent: the message was handed to a gateway.
+	TypeSent = "sent"
+	// TypeVerified: an OTP was verified with /compare.
+	TypeVerified = "verified"
+	// TypeFailed: the message could not be sent or a gateway reported
+	// delivery failure.
+	TypeFailed = "failed"
+)
+
+// Event kinds.
+const (
+	KindOTP = "otp"
+	KindSMS = "sms"
+)
+
+// Event is the JSON body published for each lifecycle step.
+type Event struct {
+	Type      string    `json:"type"`
+	Kind      string    `json:"kind"`
+	MessageID string    `json:"message_id,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Phone     string    `json:"phone"`
+	Time      time.Time `json:"time"`
+}
+
+// Publisher writes one encoded event to a topic on a bus. Publish is only
+// ever called from the Bus worker, one event at a time.
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+	Close() error
+}
+
+// Open returns the Publisher for a transport name: "nats" publishes to the
+// NATS server at addr, "log" writes events to the service log.
+func Open(transport, addr string, timeout time.Duration) (Publisher, error) {
+	switch transport {
+	case "nats":
+		if addr == "" {
+			return nil, fmt.Errorf("event bus %q needs an address", transport)
+		}
+		return NewNATS(addr, timeout), nil
+	case "log":
+		return logPublisher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown event bus %q", transport)
+	}
+}
+
+// Stats counts events since startup.
+type Stats struct {
+	Published int64 `json:"published"`
+	Failed    int64 `json:"failed"`
+	Dropped   int64 `json:"dropped"`
+	Queued    int   `json:"queued"`
+}
+
+// Bus queues events for a Publisher. A nil *Bus accepts and discards
+// events, so callers need not check whether export is configured.
+type Bus struct {
+	pub   Publisher
+	topic string
+	queue chan Event
+	stop  chan struct{}
+	done  chan struct{}
+
+	published, failed, dropped atomic.Int64
+}
+
+// New starts a Bus publishing to topic through pub, buffering up to size
+// events.
+func New(pub Publisher, topic string, size int) *Bus {
+	if size < 1 {
+		size = 1
+	}
+	b := &Bus{
+		pub:   pub,
+		topic: topic,
+		queue: make(chan Event, size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Emit queues ev without blocking; when the queue is full ev is dropped.
+func (b *Bus) Emit(ev Event) {
+	if b == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	select {
+	case b.queue <- ev:
+	default:
+		// Log the first drop and then every 1000th, not each one: drops
+		// happen exactly when things are already slow.
+		if n := b.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("[EVENTBUS] Queue full, dropping events | topic=%s | dropped_total=%d", b.topic, n)
+		}
+	}
+}
+
+// Stats returns the event counters.
+func (b *Bus) Stats() Stats {
+	if b == nil {
+		return Stats{}
+	}
+	return Stats{
+		Published: b.published.Load(),
+		Failed:    b.failed.Load(),
+		Dropped:   b.dropped.Load(),
+		Queued:    len(b.queue),
+	}
+}
+
+// Close publishes what is still queued, giving up after timeout, and closes
+// the Publisher. Events emitted after Close are never published.
+func (b *Bus) Close(timeout time.Duration) {
+	if b == nil {
+		return
+	}
+	close(b.stop)
+	select {
+	case <-b.done:
+	case <-time.After(timeout):
+		log.Printf("[EVENTBUS] Shutdown timed out, abandoning queue | topic=%s | queued=%d", b.topic, len(b.queue))
+	}
+	if err := b.pub.Close(); err != nil {
+		log.Printf("[EVENTBUS] Failed to close publisher | error=%v", err)
+	}
+}
+
+func (b *Bus) run() {
+	defer close(b.done)
+	for {
+		select {
+		case ev := <-b.queue:
+			b.publish(ev)
+		case <-b.stop:
+			for {
+				select {
+				case ev := <-b.queue:
+					b.publish(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// publish sends one event, counting a failure rather than retrying: a
+// retry would hold up every event queued behind it.
+func (b *Bus) publish(ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.failed.Add(1)
+			log.Printf("[EVENTBUS][PANIC] Publish panicked | type=%s | panic=%v\nstack:\n%s", ev.Type, r, debug.Stack())
+		}
+	}()
+	payload, err := json.Marshal(ev)
+	if err == nil {
+		err = b.pub.Publish(b.topic, payload)
+	}
+	if err != nil {
+		b.failed.Add(1)
+		log.Printf("[EVENTBUS] Publish failed | topic=%s | type=%s | message_id=%s | error=%v", b.topic, ev.Type, ev.MessageID, err)
+		return
+	}
+	b.published.Add(1)
+}
+
+// logPublisher writes events to the service log, for trying the export
+// without a bus.
+type logPublisher struct{}
+
+func (logPublisher) Publish(topic string, payload []byte) error {
+	log.Printf("[EVENTBUS] Event | topic=%s | payload=%s", topic, payload)
+	return nil
+}
+
+func (logPublisher) Close() error { return nil }
@@ -0,0 +1,133 @@
+package eventbus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes over the NATS core text protocol (CONNECT/PUB/PING), which
+// is all fire-and-forget export needs, without a client library. The
+// connection is opened on first use and re-opened after a failure.
+type NATS struct {
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// NewNATS returns a publisher for the NATS server at addr ("host:port" or
+// "nats://host:port"); timeout bounds dialing and each write.
+func NewNATS(addr string, timeout time.Duration) *NATS {
+	return &NATS{addr: strings.TrimPrefix(addr, "nats://"), timeout: timeout}
+}
+
+// Publish sends payload to subject topic.
+func (n *NATS) Publish(topic string, payload []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(); err != nil {
+			return err
+		}
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(n.timeout))
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", topic, len(payload))
+	n.w.Write(payload)
+	n.w.WriteString("\r\n")
+	if err := n.w.Flush(); err != nil {
+		n.reset()
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection, if open.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn, n.w = nil, nil
+	return err
+}
+
+// connect dials the server, reads its INFO greeting and sends CONNECT.
+// Callers must hold n.mu.
+func (n *NATS) connect() error {
+	conn, err := net.DialTimeout("tcp", n.addr, n.timeout)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(n.timeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return errors.New("nats: unexpected greeting")
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"sms_service\"}\r\n")
+	conn.SetWriteDeadline(time.Now().Add(n.timeout))
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn, n.w = conn, w
+	log.Printf("[EVENTBUS] Connected to NATS | addr=%s", n.addr)
+	go n.read(conn, r)
+	return nil
+}
+
+// read answers server PINGs and logs -ERR until conn closes; the server
+// drops clients that leave PINGs unanswered.
+func (n *NATS) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			n.mu.Lock()
+			if n.conn == conn {
+				n.reset()
+			}
+			n.mu.Unlock()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			n.mu.Lock()
+			if n.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(n.timeout))
+				n.w.WriteString("PONG\r\n")
+				n.w.Flush()
+			}
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("[EVENTBUS] NATS error | addr=%s | error=%s", n.addr, strings.TrimSpace(line))
+		}
+	}
+}
+
+// reset drops a broken connection so the next Publish reconnects. Callers
+// must hold n.mu.
+func (n *NATS) reset() {
+	if n.conn != nil {
+		n.conn.Close()
+		log.Printf("[EVENTBUS] NATS connection lost | addr=%s", n.addr)
+	}
+	n.conn, n.w = nil, nil
+}
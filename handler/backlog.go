@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"sms_service/eventbus"
+	"sms_service/logging"
+	"sms_service/otpstore"
+	"sms_service/socketserver"
+	"sms_service/webhook"
+)
+
+// heldMessage is a message kept in the Redis backlog because no gateway of
+// its tenant was connected when it was sent.
+type heldMessage struct {
+	Event  socketserver.OTPEvent `json:"event"`
+	HeldAt time.Time             `json:"held_at"`
+	// ExpiresAt drops the message instead of sending it late, e.g. an OTP
+	// whose code has expired. Nil keeps it until a gateway connects.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Callback is the send's callback_url, so its lifecycle events still
+	// reach the caller after a restart.
+	Callback string `json:"callback,omitempty"`
+}
+
+// message rebuilds the lifecycle record of a held message, nil without a
+// callback.
+func (m *heldMessage) message(tenant string) *message {
+	if m.Callback == "" {
+		return nil
+	}
+	return &message{id: m.Event.MessageID, tenant: tenant, phone: m.Event.Phone, callback: m.Callback}
+}
+
+// hold keeps event in tenant's backlog when SMS_BACKLOG_SIZE is set and
+// reports whether it did, telling m's callback. A positive ttl bounds how
+// long the message is worth sending.
+func (h *Handler) hold(tenant string, event socketserver.OTPEvent, ttl time.Duration, m *message) bool {
+	max := h.conf().SMSBacklogSize
+	if max <= 0 {
+		return false
+	}
+	msg := heldMessage{Event: event, HeldAt: time.Now().UTC()}
+	if m != nil {
+		msg.Callback = m.callback
+	}
+	if ttl > 0 {
+		exp := msg.HeldAt.Add(ttl)
+		msg.ExpiresAt = &exp
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[BACKLOG] Failed to encode message | tenant=%s | phone=%s | error=%v", tenant, logging.Phone(event.Phone), err)
+		return false
+	}
+	n, err := h.otps.PushBacklog(context.Background(), tenant, b, max)
+	if err != nil {
+		log.Printf("[BACKLOG] Message not held | tenant=%s | phone=%s | max=%d | error=%v", tenant, logging.Phone(event.Phone), max, err)
+		return false
+	}
+	log.Printf("[BACKLOG] No gateway connected, message held | tenant=%s | phone=%s | message_id=%s | backlog=%d",
+		tenant, logging.Phone(event.Phone), event.MessageID, n)
+	h.notify(m, webhook.StatusQueued, "")
+	return true
+}
+
+// drainBacklog sends a newly connected gateway's tenant everything held for
+// it, oldest first. It runs as a socket connect hook. A message that cannot
+// be sent goes back to the front of the backlog and draining stops until
+// the next connect.
+func (h *Handler) drainBacklog(id, tenant string) {
+	ctx := context.Background()
+	sent := 0
+	for {
+		b, err := h.otps.PopBacklog(ctx, tenant)
+		if errors.Is(err, otpstore.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			log.Printf("[BACKLOG] Failed to read backlog | id=%s | tenant=%s | error=%v", id, tenant, err)
+			break
+		}
+		var msg heldMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			log.Printf("[BACKLOG] Dropping unreadable message | id=%s | tenant=%s | error=%v", id, tenant, err)
+			continue
+		}
+		if msg.ExpiresAt != nil && time.Now().After(*msg.ExpiresAt) {
+			log.Printf("[BACKLOG] Dropping expired message | id=%s | tenant=%s | phone=%s | message_id=%s | held_at=%s",
+				id, tenant, logging.Phone(msg.Event.Phone), msg.Event.MessageID, msg.HeldAt.Format(time.RFC3339))
+			h.notify(msg.message(tenant), webhook.StatusFailed, "")
+			h.publish(eventbus.TypeFailed, kindOf(msg.Event), tenant, msg.Event.MessageID, msg.Event.Phone)
+			continue
+		}
+		_, route, err := h.emit(tenant, msg.Event)
+		if err != nil {
+			if retErr := h.otps.ReturnBacklog(ctx, tenant, b); retErr != nil {
+				log.Printf("[BACKLOG] Message lost | tenant=%s | phone=%s | message_id=%s | error=%v",
+					tenant, logging.Phone(msg.Event.Phone), msg.Event.MessageID, retErr)
+			}
+			break
+		}
+		if kindOf(msg.Event) == eventbus.KindOTP {
+			h.stats.otpSent.Add(1)
+		} else {
+			h.stats.smsEmitted.Add(1)
+		}
+		h.notify(msg.message(tenant), webhook.StatusDispatched, route.ClientID)
+		h.publish(eventbus.TypeSent, kindOf(msg.Event), tenant, msg.Event.MessageID, msg.Event.Phone)
+		sent++
+	}
+	if sent > 0 {
+		log.Printf("[BACKLOG] Held messages sent | id=%s | tenant=%s | sent=%d", id, tenant, sent)
+	}
+}
+
+// kindOf maps a message's category to its lifecycle event kind.
+func kindOf(event socketserver.OTPEvent) string {
+	if event.Category == socketserver.CategoryOTP {
+		return eventbus.KindOTP
+	}
+	return eventbus.KindSMS
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHeldMessageTimesAreUTC(t *testing.T) {
+	t.Setenv("SMS_BACKLOG_SIZE", "10")
+	// A host whose local zone is not UTC, e.g. one that observes DST.
+	local := time.Local
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	h, _ := newTestHandler(t, &fakeBroadcaster{reached: 0})
+	if status, body := post(t, h.OTP, `{"phone":"`+testPhone+`"}`); status != http.StatusAccepted {
+		t.Fatalf("OTP = %d %v, want 202 held", status, body)
+	}
+
+	raw, err := h.otps.PopBacklog(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var held heldMessage
+	if err := json.Unmarshal(raw, &held); err != nil {
+		t.Fatal(err)
+	}
+	if held.HeldAt.Location() != time.UTC {
+		t.Fatalf("held_at in %v: %s", held.HeldAt.Location(), raw)
+	}
+	if held.ExpiresAt == nil || held.ExpiresAt.Location() != time.UTC {
+		t.Fatalf("expires_at not in UTC: %s", raw)
+	}
+	if ttl := held.ExpiresAt.Sub(held.HeldAt); ttl != 30*time.Minute {
+		t.Fatalf("held OTP expires after %s, want the 30m code TTL", ttl)
+	}
+}
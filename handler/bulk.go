@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"sms_service/eventbus"
+	"sms_service/i18n"
+	"sms_service/logging"
+	"sms_service/middleware"
+	"sms_service/phone"
+	"sms_service/socketserver"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ndjsonType is the media type that switches /bulk-sms to streaming.
+const ndjsonType = "application/x-ndjson"
+
+// bulkEntry is one recipient of a bulk send.
+type bulkEntry struct {
+	Phone   string `json:"phone"`
+	Message string `json:"message"`
+}
+
+// bulkResult reports what happened to one bulkEntry.
+type bulkResult struct {
+	Index   int    `json:"index"`
+	Phone   string `json:"phone"`
+	Success bool   `json:"success"`
+	Code    string `json:"code,omitempty"`
+	// Segments is how many SMS parts the message was split into.
+	Segments int `json:"segments,omitempty"`
+}
+
+// bulkSummary closes a bulk send.
+type bulkSummary struct {
+	Done     bool   `json:"done"`
+	Total    int    `json:"total"`
+	Accepted int    `json:"accepted"`
+	Failed   int    `json:"failed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkSMS handles POST /bulk-sms.
+// Accepts {"messages": [{"phone": "...", "message": "..."}, ...]} and sends
+// each entry like /send-sms. A batch of more than BULK_SMS_MAX_BATCH entries
+// is rejected with 400 before anything is sent; so is a malformed one,
+// since the capped batch is decoded up front. With no cap the body is
+// decoded one entry at a time, so a large batch is never held in memory
+// whole, and a decode error ends the batch where it occurs.
+//
+// By default the response is a single JSON object with every per-entry
+// result. With "Accept: application/x-ndjson" each result is written and
+// flushed as its own line as soon as the entry is processed, followed by a
+// summary line with "done": true. A caller that disconnects mid-stream
+// stops processing; entries after that point are not sent.
+func (h *Handler) BulkSMS(c *gin.Context) {
+	ip := c.ClientIP()
+	tenant := c.GetString(middleware.TenantKey)
+	stream := strings.Contains(c.GetHeader("Accept"), ndjsonType)
+	log.Printf("[BULK_SMS] Request received | ip=%s | tenant=%s | stream=%t", ip, tenant, stream)
+
+	dec := json.NewDecoder(c.Request.Body)
+	if err := openMessages(dec); err != nil {
+		log.Printf("[BULK_SMS] Failed to parse request body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+
+	next := func() (bulkEntry, error) {
+		var entry bulkEntry
+		err := dec.Decode(&entry)
+		return entry, err
+	}
+	more := dec.More
+	if max := h.conf().BulkSMSMaxBatch; max > 0 {
+		entries, err := readBatch(dec, max)
+		if err != nil {
+			log.Printf("[BULK_SMS] Batch rejected | ip=%s | max=%d | error=%v", ip, max, err)
+			code := i18n.BadRequest
+			if errors.Is(err, errBatchTooLarge) {
+				code = i18n.BatchTooLarge
+			}
+			h.reply(c, http.StatusBadRequest, code, gin.H{"success": false, "max": max, "error": err.Error()})
+			return
+		}
+		more = func() bool { return len(entries) > 0 }
+		next = func() (bulkEntry, error) {
+			entry := entries[0]
+			entries = entries[1:]
+			return entry, nil
+		}
+	}
+
+	var (
+		results []bulkResult
+		enc     *json.Encoder
+		sum     bulkSummary
+	)
+	if stream {
+		c.Header("Content-Type", ndjsonType)
+		c.Status(http.StatusOK)
+		enc = json.NewEncoder(c.Writer)
+	}
+
+	ctx := c.Request.Context()
+	for more() {
+		if ctx.Err() != nil {
+			log.Printf("[BULK_SMS] Client went away, stopping | ip=%s | processed=%d", ip, sum.Total)
+			return
+		}
+
+		entry, err := next()
+		if err != nil {
+			sum.Error = err.Error()
+			break
+		}
+		res := h.bulkSend(ctx, tenant, sum.Total, entry)
+		sum.Total++
+		if res.Success {
+			sum.Accepted++
+		} else {
+			sum.Failed++
+		}
+
+		if !stream {
+			results = append(results, res)
+			continue
+		}
+		if err := enc.Encode(res); err != nil {
+			log.Printf("[BULK_SMS] Write failed, stopping | ip=%s | processed=%d | error=%v", ip, sum.Total, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+	sum.Done = sum.Error == ""
+
+	log.Printf("[BULK_SMS] Bulk send finished | ip=%s | tenant=%s | total=%d | accepted=%d | failed=%d | error=%q",
+		ip, tenant, sum.Total, sum.Accepted, sum.Failed, sum.Error)
+
+	if stream {
+		_ = enc.Encode(sum)
+		c.Writer.Flush()
+		return
+	}
+
+	status := http.StatusOK
+	if sum.Error != "" {
+		status = http.StatusBadRequest
+	}
+	resp := gin.H{
+		"success":  sum.Error == "",
+		"total":    sum.Total,
+		"accepted": sum.Accepted,
+		"failed":   sum.Failed,
+		"results":  results,
+	}
+	if sum.Error != "" {
+		resp["error"] = sum.Error
+	}
+	c.JSON(status, resp)
+}
+
+// bulkSend validates and emits a single bulk entry, applying the same dedup
+// window as /send-sms.
+func (h *Handler) bulkSend(ctx context.Context, tenant string, index int, entry bulkEntry) bulkResult {
+	res := bulkResult{Index: index, Phone: entry.Phone}
+	fullPhone, err := phone.Normalize(entry.Phone)
+	if err != nil {
+		res.Code = i18n.InvalidPhone
+		return res
+	}
+	res.Phone = fullPhone
+	chars, segments, unicode := smsLength(entry.Message)
+	if max := smsLimit(h.conf().MaxSMSLength, unicode); max > 0 && chars > max {
+		res.Code = i18n.MessageTooLong
+		return res
+	}
+	res.Segments = segments
+	if window := h.conf().EmitDedupWindow; window > 0 {
+		first, err := h.otps.ClaimEmit(ctx, tenant, fullPhone, entry.Message, window)
+		if err != nil {
+			log.Printf("[BULK_SMS] Dedup check failed, sending anyway | phone=%s | error=%v", logging.Phone(fullPhone), err)
+		} else if !first {
+			res.Success = true
+			res.Code = i18n.DuplicateSuppressed
+			return res
+		}
+	}
+	event := socketserver.OTPEvent{
+		Phone:    fullPhone,
+		Pass:     entry.Message,
+		Category: socketserver.CategoryTransactional,
+	}
+	reached, _, err := h.emit(tenant, event)
+	if errors.Is(err, socketserver.ErrNoClients) && h.hold(tenant, event, 0, nil) {
+		res.Success = true
+		res.Code = i18n.MessageHeld
+		return res
+	}
+	if reached == 0 {
+		h.releaseDuplicate("BULK_SMS", tenant, fullPhone, entry.Message)
+		h.publish(eventbus.TypeFailed, eventbus.KindSMS, tenant, "", fullPhone)
+		res.Code = i18n.NoGateway
+		return res
+	}
+	h.stats.smsEmitted.Add(1)
+	h.publish(eventbus.TypeSent, eventbus.KindSMS, tenant, "", fullPhone)
+	res.Success = true
+	return res
+}
+
+// errBatchTooLarge is returned by readBatch for a batch above the cap.
+var errBatchTooLarge = errors.New("too many messages in batch")
+
+// readBatch decodes every entry of the "messages" array, failing with
+// errBatchTooLarge as soon as there are more than max of them.
+func readBatch(dec *json.Decoder, max int) ([]bulkEntry, error) {
+	var entries []bulkEntry
+	for dec.More() {
+		if len(entries) == max {
+			return nil, errBatchTooLarge
+		}
+		var entry bulkEntry
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// openMessages advances dec to the first element of the top-level
+// "messages" array, skipping any other fields that come before it.
+func openMessages(dec *json.Decoder) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key, _ := tok.(string); key == "messages" {
+			return expectDelim(dec, '[')
+		}
+		// Skip the value of an unrelated field.
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return errors.New(`missing "messages" array`)
+}
+
+// expectDelim reads the next token and checks that it is want.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if errors.Is(err, io.EOF) {
+		return errors.New("empty body")
+	}
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"sms_service/eventbus"
+	"sms_service/i18n"
+	"sms_service/middleware"
+	"sms_service/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// callbackBackoff is the wait before the first callback retry; it doubles
+// after each failed attempt.
+const callbackBackoff = 2 * time.Second
+
+// message is the lifecycle record of one send: which tenant sent it to
+// which phone, and where its lifecycle events go. A nil *message (no
+// callback requested) ignores notify.
+type message struct {
+	id       string
+	tenant   string
+	phone    string
+	callback string
+}
+
+// callbackMessage validates the optional callback_url of a send and returns
+// its lifecycle record. It answers 400 itself and returns ok=false when the
+// URL is not allowed; a request without one gets a nil record.
+func (h *Handler) callbackMessage(c *gin.Context, tag, callbackURL, id, phone string) (*message, bool) {
+	if callbackURL == "" {
+		return nil, true
+	}
+	if err := webhook.CheckURL(callbackURL, h.conf().CallbackAllowedHosts); err != nil {
+		log.Printf("[%s] Callback URL rejected | ip=%s | callback_url=%q | error=%v", tag, c.ClientIP(), callbackURL, err)
+		h.reply(c, http.StatusBadRequest, i18n.InvalidCallbackURL, nil)
+		return nil, false
+	}
+	return &message{
+		id:       id,
+		tenant:   c.GetString(middleware.TenantKey),
+		phone:    phone,
+		callback: callbackURL,
+	}, true
+}
+
+// notify reports a lifecycle status of m to its callback, signed with the
+// tenant's signing key when one is configured.
+func (h *Handler) notify(m *message, status, clientID string) {
+	if m == nil {
+		return
+	}
+	secret, _ := h.conf().SigningKey(m.tenant)
+	h.hooks.Send(m.callback, secret, webhook.Event{
+		MessageID: m.id,
+		Status:    status,
+		Phone:     m.phone,
+		ClientID:  clientID,
+	})
+}
+
+// reportSended POSTs a delivery report to DELIVERY_WEBHOOK_URL when a
+// gateway confirms a send. The sender posts in the background, so the
+// socket event loop never waits on the webhook.
+func (h *Handler) reportSended(id, tenant, phone string) {
+	target := h.conf().DeliveryWebhookURL
+	if target == "" {
+		return
+	}
+	secret, _ := h.conf().SigningKey(tenant)
+	h.hooks.Send(target, secret, webhook.Event{
+		Status:   webhook.StatusSent,
+		Phone:    phone,
+		ClientID: id,
+	})
+}
+
+// publish exports a lifecycle event to the message bus; a no-op when none
+// is configured.
+func (h *Handler) publish(typ, kind, tenant, id, phone string) {
+	h.bus.Emit(eventbus.Event{
+		Type:      typ,
+		Kind:      kind,
+		MessageID: id,
+		Tenant:    tenant,
+		Phone:     phone,
+	})
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"sms_service/metrics"
+	"sms_service/socketserver"
+)
+
+// sentEvent is one send recorded by fakeBroadcaster.
+type sentEvent struct {
+	tenant string
+	event  string
+	data   interface{}
+}
+
+// fakeBroadcaster stands in for the socket manager. Every send reports
+// reached gateways, or fails with err when it is set. Sends that reach a
+// gateway are recorded.
+type fakeBroadcaster struct {
+	mu      sync.Mutex
+	reached int
+	err     error
+	sent    []sentEvent
+}
+
+func (f *fakeBroadcaster) record(tenant, event string, data interface{}) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.reached == 0 {
+		return 0, nil
+	}
+	f.sent = append(f.sent, sentEvent{tenant: tenant, event: event, data: data})
+	return f.reached, nil
+}
+
+// events returns what was sent so far.
+func (f *fakeBroadcaster) events() []sentEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentEvent(nil), f.sent...)
+}
+
+func (f *fakeBroadcaster) Emit(event string, data interface{}) (int, error) {
+	return f.record("", event, data)
+}
+
+func (f *fakeBroadcaster) EmitTo(id, event string, data interface{}) error {
+	_, err := f.record("", event, data)
+	return err
+}
+
+func (f *fakeBroadcaster) EmitToTenant(tenant, event string, data interface{}) (int, error) {
+	return f.record(tenant, event, data)
+}
+
+func (f *fakeBroadcaster) EmitToTenantRoom(tenant, room, event string, data interface{}) (int, error) {
+	return f.record(tenant, event, data)
+}
+
+func (f *fakeBroadcaster) Dispatch(tenant, event string, data interface{}) (socketserver.Route, error) {
+	reached, err := f.record(tenant, event, data)
+	if err != nil {
+		return socketserver.Route{}, err
+	}
+	if reached == 0 {
+		return socketserver.Route{}, socketserver.ErrNoClients
+	}
+	return socketserver.Route{ClientID: "fake"}, nil
+}
+
+func (f *fakeBroadcaster) DispatchExcept(tenant, _, event string, data interface{}) (socketserver.Route, error) {
+	return f.Dispatch(tenant, event, data)
+}
+
+func (f *fakeBroadcaster) EmitToAvailable(event string, data interface{}) (string, error) {
+	route, err := f.Dispatch("", event, data)
+	return route.ClientID, err
+}
+
+func (f *fakeBroadcaster) EmitToAvailableExcept(_, event string, data interface{}) (string, error) {
+	return f.EmitToAvailable(event, data)
+}
+
+func (f *fakeBroadcaster) EmitWithAck(_ context.Context, tenant, event string, data interface{}) (socketserver.Ack, error) {
+	route, err := f.Dispatch(tenant, event, data)
+	if err != nil {
+		return socketserver.Ack{}, err
+	}
+	return socketserver.Ack{Route: route, Status: socketserver.StatusDelivered}, nil
+}
+
+// fakeGateways is an empty gateway pool for the inspection side.
+type fakeGateways struct{}
+
+func (fakeGateways) ClientCount() int                               { return 0 }
+func (fakeGateways) Clients() []socketserver.ClientInfo             { return nil }
+func (fakeGateways) TenantClients(string) []socketserver.ClientInfo { return nil }
+func (fakeGateways) ListClients(string) []socketserver.AdminClient  { return nil }
+func (fakeGateways) Stats() socketserver.Stats                      { return socketserver.Stats{} }
+func (fakeGateways) TenantStats(string) socketserver.Stats          { return socketserver.Stats{} }
+func (fakeGateways) Gauges() socketserver.Gauges                    { return socketserver.Gauges{} }
+func (fakeGateways) Serving() bool                                  { return true }
+func (fakeGateways) SessionStats() socketserver.SessionStats        { return socketserver.SessionStats{} }
+func (fakeGateways) SweepSessions() int                             { return 0 }
+func (fakeGateways) Delivery(string) (socketserver.PhoneDelivery, bool) {
+	return socketserver.PhoneDelivery{}, false
+}
+func (fakeGateways) SetDraining(string, string, bool) error     { return socketserver.ErrClientNotFound }
+func (fakeGateways) RegisterMetrics(*metrics.Registry)          {}
+func (fakeGateways) OnClientConnect(func(id, tenant string))    {}
+func (fakeGateways) OnSended(func(id, tenant, phone string))    {}
+func (fakeGateways) OnSendFailed(func(socketserver.FailedSend)) {}
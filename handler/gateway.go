@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+
+	"sms_service/middleware"
+	"sms_service/socketserver"
+
+	"github.com/gin-gonic/gin"
+)
+
+// withGateway adds the gateway that took a message to a send response, for
+// tracing non-delivery reports to a device. Gateway IDs are internal, so
+// they are only shown to callers that authenticated with an API key, and
+// the gateway's address is masked.
+func withGateway(c *gin.Context, fields gin.H, r socketserver.Route) gin.H {
+	if r.ClientID == "" || !authenticated(c) {
+		return fields
+	}
+	gw := gin.H{
+		"client_id":   r.ClientID,
+		"remote_addr": maskAddr(r.RemoteAddr),
+	}
+	if r.Device != "" {
+		gw["device"] = r.Device
+	}
+	fields["gateway"] = gw
+	return fields
+}
+
+// authenticated reports whether the request carried a valid API key.
+// APIKeyAuth sets the tenant only when it checked one.
+func authenticated(c *gin.Context) bool {
+	_, ok := c.Get(middleware.TenantKey)
+	return ok
+}
+
+// maskAddr drops the port and the host part of an address: the last octet
+// of an IPv4 address, everything past the /64 of an IPv6 one.
+func maskAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		v4 := ip.To4()
+		return fmt.Sprintf("%d.%d.%d.x", v4[0], v4[1], v4[2])
+	default:
+		return fmt.Sprintf("%s/64", ip.Mask(net.CIDRMask(64, 128)))
+	}
+}
@@ -1,236 +1,1004 @@
-package handler
-
-import (
-	"context"
-	"crypto/rand"
-	"fmt"
-	"log"
-	"math/big"
-	"net/http"
-	"regexp"
-	"strings"
-	"time"
-
-	"sms_service/socketserver"
-
-	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
-)
-
-// Patterns mirror the original Node.js regexes exactly.
-var (
-	phonePattern   = regexp.MustCompile(`^[6][1-5][0-9]{6}$`)
-	sendSMSPattern = regexp.MustCompile(`^(\+993)?6[1-5]\d{6}`)
-)
-
-const (
-	otpTTLSeconds time.Duration = 1800
-	otpKeyPrefix                = "otp:"
-)
-
-// Handler holds shared dependencies for all HTTP handlers.
-type Handler struct {
-	redis  *redis.Client
-	socket *socketserver.Manager
-}
-
-// New creates a Handler with the given dependencies.
-func New(rdb *redis.Client, sm *socketserver.Manager) *Handler {
-	return &Handler{redis: rdb, socket: sm}
-}
-
-// OTP handles POST /otp.
-// Generates a 5-digit code, stores it in Redis for 30 min, and emits
-// the "otp" Socket.IO event to all connected clients.
-func (h *Handler) OTP(c *gin.Context) {
-	ip := c.ClientIP()
-	log.Printf("[OTP] Request received | ip=%s", ip)
-
-	var body struct {
-		Phone string `json:"phone"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		log.Printf("[OTP] Failed to parse request body | ip=%s | error=%v", ip, err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
-		return
-	}
-	if !phonePattern.MatchString(body.Phone) {
-		log.Printf("[OTP] Invalid phone number | ip=%s | phone=%q", ip, body.Phone)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
-		return
-	}
-
-	ctx := context.Background()
-	key := otpKeyPrefix + body.Phone
-
-	// If an OTP already exists, tell the caller to wait.
-	existing, err := h.redis.Get(ctx, key).Result()
-	if err != nil && err != redis.Nil {
-		log.Printf("[OTP] Redis GET error | ip=%s | phone=%s | error=%v", ip, body.Phone, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
-	}
-	if err == nil && existing != "" {
-		log.Printf("[OTP] OTP already active, rejecting | ip=%s | phone=%s", ip, body.Phone)
-		c.JSON(http.StatusOK, gin.H{
-			"success": false,
-			"message": "OTP already sent. Please wait.",
-		})
-		return
-	}
-
-	code, err := generateOTP()
-	if err != nil {
-		log.Printf("[OTP] Failed to generate OTP | ip=%s | phone=%s | error=%v", ip, body.Phone, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to generate OTP"})
-		return
-	}
-
-	log.Printf("[OTP] Emitting OTP event via socket | ip=%s | phone=+993%s", ip, body.Phone)
-	h.socket.Emit("otp", socketserver.OTPEvent{
-		Phone: fmt.Sprintf("+993%s", body.Phone),
-		Pass:  fmt.Sprintf("Siziň aktiwasiýa koduňyz %s", code),
-	})
-
-	if err := h.redis.SetEx(ctx, key, code, otpTTLSeconds*time.Second).Err(); err != nil {
-		log.Printf("[OTP] Redis SETEX error | ip=%s | phone=%s | error=%v", ip, body.Phone, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
-	}
-
-	log.Printf("[OTP] OTP stored and sent successfully | ip=%s | phone=%s | ttl=%ds", ip, body.Phone, otpTTLSeconds)
-	c.JSON(http.StatusOK, gin.H{"success": true})
-}
-
-// Compare handles POST /compare.
-// Verifies the submitted OTP against the value stored in Redis.
-func (h *Handler) Compare(c *gin.Context) {
-	ip := c.ClientIP()
-	log.Printf("[COMPARE] Request received | ip=%s", ip)
-
-	var body struct {
-		Phone string `json:"phone"`
-		Pass  string `json:"pass"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		log.Printf("[COMPARE] Failed to parse request body | ip=%s | error=%v", ip, err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
-		return
-	}
-
-	ctx := context.Background()
-	key := otpKeyPrefix + body.Phone
-
-	cached, err := h.redis.Get(ctx, key).Result()
-	if err == redis.Nil {
-		log.Printf("[COMPARE] OTP not found or expired | ip=%s | phone=%s", ip, body.Phone)
-		c.JSON(http.StatusOK, gin.H{"success": false, "message": "OTP expired"})
-		return
-	}
-	if err != nil {
-		log.Printf("[COMPARE] Redis GET error | ip=%s | phone=%s | error=%v", ip, body.Phone, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
-	}
-
-	if body.Pass != cached {
-		log.Printf("[COMPARE] Invalid OTP attempt | ip=%s | phone=%s", ip, body.Phone)
-		c.JSON(http.StatusOK, gin.H{"success": false, "message": "Invalid OTP"})
-		return
-	}
-
-	if err := h.redis.Del(ctx, key).Err(); err != nil {
-		log.Printf("[COMPARE] Redis DEL error | ip=%s | phone=%s | error=%v", ip, body.Phone, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
-		return
-	}
-
-	log.Printf("[COMPARE] OTP verified and cleared | ip=%s | phone=%s", ip, body.Phone)
-	c.JSON(http.StatusOK, gin.H{"success": true})
-}
-
-// GroupSMS handles POST /group_sms.
-// Emits a custom message to all connected clients via Socket.IO.
-func (h *Handler) GroupSMS(c *gin.Context) {
-	ip := c.ClientIP()
-	log.Printf("[GROUP_SMS] Request received | ip=%s", ip)
-
-	var body struct {
-		Phone   string `json:"phone"`
-		Message string `json:"message"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		log.Printf("[GROUP_SMS] Failed to parse request body | ip=%s | error=%v", ip, err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request: Invalid phone number"})
-		return
-	}
-	if !phonePattern.MatchString(body.Phone) {
-		log.Printf("[GROUP_SMS] Invalid phone number | ip=%s | phone=%q", ip, body.Phone)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request: Invalid phone number"})
-		return
-	}
-
-	phone := fmt.Sprintf("+993%s", body.Phone)
-
-	log.Printf("[GROUP_SMS] Emitting group SMS via socket | ip=%s | phone=%s | message_len=%d", ip, phone, len(body.Message))
-	h.socket.Emit("otp", socketserver.OTPEvent{
-		Phone: phone,
-		Pass:  body.Message,
-	})
-
-	log.Printf("[GROUP_SMS] Group SMS sent successfully | ip=%s | phone=%s", ip, phone)
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Group SMS sent successfully",
-		"phone":   phone,
-	})
-}
-
-// SendSMS handles POST /send-sms.
-// Accepts phone numbers with or without the +993 prefix.
-func (h *Handler) SendSMS(c *gin.Context) {
-	ip := c.ClientIP()
-	log.Printf("[SEND_SMS] Request received | ip=%s", ip)
-
-	var body struct {
-		Phone   string `json:"phone"`
-		Message string `json:"message"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		log.Printf("[SEND_SMS] Failed to parse request body | ip=%s | error=%v", ip, err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
-		return
-	}
-	if !sendSMSPattern.MatchString(body.Phone) {
-		log.Printf("[SEND_SMS] Invalid phone number | ip=%s | phone=%q", ip, body.Phone)
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Bad request"})
-		return
-	}
-
-	phone := strings.TrimPrefix(body.Phone, "+993")
-	fullPhone := fmt.Sprintf("+993%s", phone)
-
-	log.Printf("[SEND_SMS] Emitting SMS via socket | ip=%s | phone=%s | message_len=%d", ip, fullPhone, len(body.Message))
-	h.socket.Emit("otp", socketserver.OTPEvent{
-		Phone: fullPhone,
-		Pass:  body.Message,
-	})
-
-	log.Printf("[SEND_SMS] SMS sent successfully | ip=%s | phone=%s", ip, fullPhone)
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Message sent",
-		"phone":   fullPhone,
-		"pass":    body.Message,
-	})
-}
-
-// generateOTP returns a zero-padded 5-digit OTP string in the range [10000, 99999].
-// Uses crypto/rand for cryptographic safety.
-func generateOTP() (string, error) {
-	n, err := rand.Int(rand.Reader, big.NewInt(90000))
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%d", n.Int64()+10000), nil
-}
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"sms_service/config"
+	"sms_service/eventbus"
+	"sms_service/httpgateway"
+	"sms_service/i18n"
+	"sms_service/logging"
+	"sms_service/metrics"
+	"sms_service/middleware"
+	"sms_service/otpstore"
+	"sms_service/phone"
+	"sms_service/socketserver"
+	"sms_service/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler holds shared dependencies for all HTTP handlers.
+type Handler struct {
+	live atomic.Pointer[settings]
+	otps *otpstore.Store
+	// socket is used for gateway inspection and maintenance; messages go
+	// out through send.
+	socket   socketserver.Gateways
+	send     socketserver.Broadcaster
+	messages *i18n.Catalog
+	// hooks delivers per-message callback_url events.
+	hooks *webhook.Sender
+	// bus exports lifecycle events for analytics; nil when disabled.
+	bus *eventbus.Bus
+
+	stats counters
+	// registry renders stats and socket gauges for Prometheus.
+	registry *metrics.Registry
+
+	startedAt time.Time
+}
+
+// settings is the config together with what is parsed from it, swapped as
+// one unit by Reload.
+type settings struct {
+	cfg      *config.Config
+	otpRules []otpRule
+	// otpTemplates maps template key to OTP message wording.
+	otpTemplates map[string]string
+	// otpLangTemplates maps language to the wording of the "default"
+	// template.
+	otpLangTemplates map[string]string
+	// gateway is the HTTP SMS gateway OTPs fall back to; nil when none is
+	// configured.
+	gateway *httpgateway.Client
+}
+
+// New creates a Handler with the given dependencies; bus may be nil. send
+// and gw are normally the same *socketserver.Manager. It fails if the
+// handler-level configuration (e.g. OTP prefix rules) is invalid.
+func New(cfg *config.Config, otps *otpstore.Store, send socketserver.Broadcaster, gw socketserver.Gateways, msgs *i18n.Catalog, bus *eventbus.Bus) (*Handler, error) {
+	h := &Handler{
+		otps:      otps,
+		socket:    gw,
+		send:      send,
+		messages:  msgs,
+		hooks:     webhook.NewSender(cfg.CallbackTimeout, cfg.CallbackMaxAttempts, callbackBackoff),
+		bus:       bus,
+		registry:  metrics.New(),
+		startedAt: time.Now(),
+	}
+	if err := h.Reload(cfg); err != nil {
+		return nil, err
+	}
+	h.registerMetrics()
+	gw.RegisterMetrics(h.registry)
+	gw.OnClientConnect(h.drainBacklog)
+	gw.OnSended(h.reportSended)
+	gw.OnSendFailed(h.redispatch)
+	return h, nil
+}
+
+// Reload validates cfg and, if it is valid, makes it the config used by
+// subsequent requests. On error the current config stays in place.
+func (h *Handler) Reload(cfg *config.Config) error {
+	rules, err := parseOTPRules(cfg.OTPPrefixRules)
+	if err != nil {
+		return err
+	}
+	templates, err := parseOTPTemplates(cfg.OTPTemplates, cfg.OTPMessageTemplate)
+	if err != nil {
+		return err
+	}
+	langTemplates, err := parseOTPLangTemplates(cfg.OTPLangTemplates, cfg.OTPDefaultLang, templates[defaultTemplateKey])
+	if err != nil {
+		return err
+	}
+	var gateway *httpgateway.Client
+	if cfg.SMSGatewayURL != "" {
+		gateway, err = httpgateway.New(cfg.SMSGatewayURL, cfg.SMSGatewayMethod, cfg.SMSGatewayAuthHeader, cfg.SMSGatewayTimeout)
+		if err != nil {
+			return fmt.Errorf("SMS_GATEWAY_URL: %w", err)
+		}
+	}
+	h.live.Store(&settings{cfg: cfg, otpRules: rules, otpTemplates: templates, otpLangTemplates: langTemplates, gateway: gateway})
+	return nil
+}
+
+// conf returns the current config.
+func (h *Handler) conf() *config.Config {
+	return h.live.Load().cfg
+}
+
+// reply writes a JSON response carrying a stable machine-readable "code"
+// and a "message" translated into the caller's language. The language comes
+// from the "lang" query parameter or the Accept-Language header.
+func (h *Handler) reply(c *gin.Context, status int, code string, fields gin.H) {
+	if fields == nil {
+		fields = gin.H{}
+	}
+	lang := h.messages.Match(c.Query("lang"), c.GetHeader("Accept-Language"))
+	fields["code"] = code
+	fields["message"] = h.messages.Translate(lang, code)
+	c.JSON(status, fields)
+}
+
+// storeRetryAfter is the Retry-After, in seconds, sent with a 503 while
+// Redis is unreachable.
+const storeRetryAfter = 5
+
+// storeFailed answers a request whose OTP store call failed. An unreachable
+// Redis gets 503 so clients know to retry; anything else a generic 500. The
+// caller logs the detail; it is never echoed to the client.
+func (h *Handler) storeFailed(c *gin.Context, err error) {
+	if otpstore.Unavailable(err) {
+		c.Header("Retry-After", strconv.Itoa(storeRetryAfter))
+		h.reply(c, http.StatusServiceUnavailable, i18n.ServiceUnavailable, nil)
+		return
+	}
+	h.reply(c, http.StatusInternalServerError, i18n.InternalError, nil)
+}
+
+// OTP handles POST /otp.
+// Generates a code, stores it in Redis for OTP_TTL_SECONDS (30 min by
+// default), and then emits the "otp" Socket.IO event to the caller's
+// gateways, falling back to the HTTP SMS gateway (SMS_GATEWAY_URL) when
+// none is connected. An optional template_key picks the message wording
+// (see OTP_TEMPLATES). With OTP_CONFIRM_DELIVERY the code is kept only once
+// a Socket.IO gateway acks it; that mode has no HTTP fallback.
+func (h *Handler) OTP(c *gin.Context) {
+	ip := c.ClientIP()
+	log.Printf("[OTP] Request received | ip=%s", ip)
+
+	var body struct {
+		Phone       string `json:"phone"`
+		TemplateKey string `json:"template_key"`
+		// Lang picks the language of the default template (tk, ru, en, ...).
+		Lang        string `json:"lang"`
+		CallbackURL string `json:"callback_url"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("[OTP] Failed to parse request body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+	fullPhone, err := phone.NormalizeLocal(body.Phone)
+	if err != nil {
+		log.Printf("[OTP] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+	template, lang, ok := h.live.Load().otpTemplate(body.TemplateKey, body.Lang)
+	if body.TemplateKey == "" {
+		body.TemplateKey = defaultTemplateKey
+	}
+	if !ok {
+		log.Printf("[OTP] Unknown template key | ip=%s | phone=%s | template_key=%q", ip, logging.Phone(body.Phone), body.TemplateKey)
+		h.reply(c, http.StatusBadRequest, i18n.UnknownTemplate, nil)
+		return
+	}
+
+	messageID, err := newMessageID()
+	if err != nil {
+		log.Printf("[OTP] Failed to generate message id | ip=%s | phone=%s | error=%v", ip, logging.Phone(body.Phone), err)
+		h.reply(c, http.StatusInternalServerError, i18n.InternalError, nil)
+		return
+	}
+	msg, ok := h.callbackMessage(c, "OTP", body.CallbackURL, messageID, fullPhone)
+	if !ok {
+		return
+	}
+
+	code, ttl, ok := h.issue(c, "OTP", body.Phone)
+	if !ok {
+		return
+	}
+
+	event := socketserver.OTPEvent{
+		Phone:     fullPhone,
+		Pass:      renderOTP(template, code),
+		Category:  socketserver.CategoryOTP,
+		MessageID: messageID,
+	}
+	log.Printf("[OTP] Emitting OTP event via socket | ip=%s | phone=%s | message_id=%s | template_key=%s | lang=%s",
+		ip, logging.Phone(fullPhone), messageID, body.TemplateKey, lang)
+	if h.conf().OTPConfirmDelivery {
+		h.sendOTPConfirmed(c, body.Phone, event, msg)
+		return
+	}
+	sent, err := h.otpSender().send(c.Request.Context(), c.GetString(middleware.TenantKey), event)
+	reached, route := sent.reached, sent.route
+
+	// With no gateway connected the message can wait in the backlog for one,
+	// and the code stays valid for when it arrives.
+	if errors.Is(err, socketserver.ErrNoClients) && h.hold(c.GetString(middleware.TenantKey), event, ttl, msg) {
+		h.reply(c, http.StatusAccepted, i18n.MessageHeld, gin.H{
+			"success":    true,
+			"queued":     true,
+			"status":     "queued",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	// No gateway took the message: the code is stored but undeliverable.
+	// Drop it so the user can request a new one straight away instead of
+	// being told to wait for a code that never arrives.
+	if reached == 0 {
+		h.stats.otpSendFailed.Add(1)
+		h.notify(msg, webhook.StatusFailed, "")
+		h.publish(eventbus.TypeFailed, eventbus.KindOTP, c.GetString(middleware.TenantKey), messageID, fullPhone)
+		log.Printf("[OTP] No gateway reached, discarding stored OTP | ip=%s | phone=%s | message_id=%s", ip, logging.Phone(body.Phone), messageID)
+		if err := h.otps.Delete(context.Background(), body.Phone); err != nil {
+			log.Printf("[OTP] Failed to discard undeliverable OTP | ip=%s | phone=%s | error=%v", ip, logging.Phone(body.Phone), err)
+		}
+		h.reply(c, http.StatusServiceUnavailable, i18n.NoGateway, gin.H{
+			"success":    false,
+			"queued":     false,
+			"status":     "failed",
+			"message_id": messageID,
+		})
+		return
+	}
+
+	h.stats.otpSent.Add(1)
+	h.notify(msg, webhook.StatusDispatched, route.ClientID)
+	h.publish(eventbus.TypeSent, eventbus.KindOTP, c.GetString(middleware.TenantKey), messageID, fullPhone)
+	log.Printf("[OTP] OTP stored and sent successfully | ip=%s | phone=%s | ttl=%s | channel=%s | gateways=%d | message_id=%s | client=%s",
+		ip, logging.Phone(body.Phone), ttl, sent.channel, reached, messageID, route.ClientID)
+	c.JSON(http.StatusOK, withGateway(c, gin.H{"success": true, "status": "sent", "channel": sent.channel, "message_id": messageID}, route))
+}
+
+// sendOTPConfirmed emits an already stored OTP with an ack callback and
+// keeps the code only if a gateway confirms it sent the SMS within
+// SendWaitTimeout; the first ack wins. Otherwise the code is deleted and the
+// caller gets an error, so a user is never left waiting for a code that
+// may not arrive. The code is stored before the emit rather than after the
+// ack so a user who is quicker than the ack can still verify it.
+func (h *Handler) sendOTPConfirmed(c *gin.Context, phone string, event socketserver.OTPEvent, msg *message) {
+	ip := c.ClientIP()
+	tenant := c.GetString(middleware.TenantKey)
+	id := event.MessageID
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.conf().SendWaitTimeout)
+	defer cancel()
+
+	log.Printf("[OTP] Emitting OTP event and waiting for ack | ip=%s | phone=%s | message_id=%s | timeout=%s",
+		ip, logging.Phone(event.Phone), id, h.conf().SendWaitTimeout)
+	ack, err := h.send.EmitWithAck(ctx, tenant, "otp", event)
+	if err == nil && ack.Status != socketserver.StatusFailed {
+		h.stats.otpSent.Add(1)
+		h.notify(msg, webhook.StatusAcked, ack.ClientID)
+		h.notify(msg, webhook.StatusDelivered, ack.ClientID)
+		h.publish(eventbus.TypeSent, eventbus.KindOTP, tenant, id, event.Phone)
+		log.Printf("[OTP] OTP delivery confirmed | ip=%s | phone=%s | message_id=%s | client=%s", ip, logging.Phone(phone), id, ack.ClientID)
+		c.JSON(http.StatusOK, withGateway(c, gin.H{"success": true, "status": socketserver.StatusDelivered, "message_id": id}, ack.Route))
+		return
+	}
+
+	h.stats.otpSendFailed.Add(1)
+	if err == nil {
+		h.notify(msg, webhook.StatusAcked, ack.ClientID)
+	}
+	h.notify(msg, webhook.StatusFailed, ack.ClientID)
+	h.publish(eventbus.TypeFailed, eventbus.KindOTP, tenant, id, event.Phone)
+	if delErr := h.otps.Delete(context.Background(), phone); delErr != nil {
+		log.Printf("[OTP] Failed to discard unconfirmed OTP | ip=%s | phone=%s | error=%v", ip, logging.Phone(phone), delErr)
+	}
+
+	fields := gin.H{"success": false, "status": socketserver.StatusFailed, "message_id": id}
+	switch {
+	case errors.Is(err, socketserver.ErrNoClients):
+		log.Printf("[OTP] No gateway connected, discarding stored OTP | ip=%s | phone=%s | message_id=%s", ip, logging.Phone(phone), id)
+		h.reply(c, http.StatusServiceUnavailable, i18n.NoGateway, fields)
+	case errors.Is(err, socketserver.ErrFanoutExceeded):
+		log.Printf("[OTP] Broadcast refused, discarding stored OTP | ip=%s | phone=%s | message_id=%s", ip, logging.Phone(phone), id)
+		h.reply(c, http.StatusServiceUnavailable, i18n.BroadcastRefused, fields)
+	case err != nil:
+		log.Printf("[OTP] No ack before deadline, discarding stored OTP | ip=%s | phone=%s | message_id=%s | error=%v", ip, logging.Phone(phone), id, err)
+		h.reply(c, http.StatusGatewayTimeout, i18n.OTPUnconfirmed, fields)
+	default:
+		log.Printf("[OTP] Gateway reported failure, discarding stored OTP | ip=%s | phone=%s | message_id=%s | client=%s", ip, logging.Phone(phone), id, ack.ClientID)
+		h.reply(c, http.StatusBadGateway, i18n.DeliveryFailed, withGateway(c, fields, ack.Route))
+	}
+}
+
+// issue generates a code for phone and stores it, honouring an already
+// active code, the active-OTP cap and per-prefix rules. It answers the
+// request itself and returns ok=false on any failure; tag prefixes its logs.
+//
+// A blocked request gets 429 with Retry-After set to the seconds left.
+// With OTP_RESEND_COOLDOWN set, an active code only blocks a new one until
+// the cooldown has passed; with OTP_CODE_HISTORY above 1 it never does. The
+// new code then replaces it, and with history the previous codes keep
+// verifying.
+func (h *Handler) issue(c *gin.Context, tag, national string) (code string, ttl time.Duration, ok bool) {
+	ip := c.ClientIP()
+	ctx := context.Background()
+	rule := h.otpRuleFor(national)
+	ttl = rule.ttl(h.conf().OTPTTL)
+	history := h.conf().OTPCodeHistory
+	cooldown := h.conf().OTPResendCooldown
+
+	// If an OTP already exists, tell the caller to wait.
+	existing, err := h.otps.Get(ctx, national)
+	if err != nil && !errors.Is(err, otpstore.ErrNotFound) {
+		log.Printf("[%s] Redis GET error | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+		h.storeFailed(c, err)
+		return "", 0, false
+	}
+	replace := err == nil && existing.Code != ""
+	if replace {
+		var wait time.Duration
+		switch {
+		case cooldown > 0:
+			wait, err = h.otps.ClaimResend(ctx, national, cooldown)
+		case history <= 1:
+			wait, err = h.otps.TTL(ctx, national)
+		}
+		if err != nil && !errors.Is(err, otpstore.ErrNotFound) {
+			log.Printf("[%s] Redis cooldown check error | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+			h.storeFailed(c, err)
+			return "", 0, false
+		}
+		if wait > 0 {
+			secs := int(math.Ceil(wait.Seconds()))
+			log.Printf("[%s] OTP already active, rejecting | ip=%s | phone=%s | retry_after=%ds", tag, ip, logging.Phone(national), secs)
+			c.Header("Retry-After", strconv.Itoa(secs))
+			h.reply(c, http.StatusTooManyRequests, i18n.OTPAlreadySent, gin.H{"success": false, "retry_after": secs})
+			return "", 0, false
+		}
+	}
+
+	// Global ceiling on outstanding codes: a hard stop on SMS spend if
+	// something floods /otp.
+	if h.conf().MaxActiveOTPs > 0 {
+		reserved, err := h.otps.Reserve(ctx, national, ttl, h.conf().MaxActiveOTPs)
+		if err != nil {
+			log.Printf("[%s] Redis reserve error | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+			h.storeFailed(c, err)
+			return "", 0, false
+		}
+		if !reserved {
+			log.Printf("[%s] Active OTP cap reached, rejecting | ip=%s | phone=%s | max=%d", tag, ip, logging.Phone(national), h.conf().MaxActiveOTPs)
+			h.reply(c, http.StatusServiceUnavailable, i18n.AtCapacity, gin.H{"success": false})
+			return "", 0, false
+		}
+	}
+
+	if rule != nil {
+		code, err = generateCode(rule.Length, rule.Alphabet)
+	} else {
+		code, err = generateOTP(h.conf().OTPLength, h.conf().OTPAlphabet)
+	}
+	if err != nil {
+		log.Printf("[%s] Failed to generate OTP | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+		h.reply(c, http.StatusInternalServerError, i18n.OTPGenerateFailed, nil)
+		return "", 0, false
+	}
+	rec, err := otpstore.NewRecord(code)
+	if err != nil {
+		log.Printf("[%s] Failed to create OTP record | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+		h.reply(c, http.StatusInternalServerError, i18n.OTPGenerateFailed, nil)
+		return "", 0, false
+	}
+
+	// Store before emitting: if the store fails the user must not receive a
+	// code that /compare could never verify. A new code is created only if
+	// none exists, so when two requests race past the check above only one
+	// stores and emits.
+	switch {
+	case replace && history > 1:
+		log.Printf("[%s] Replacing active OTP, previous codes stay valid | ip=%s | phone=%s | history=%d", tag, ip, logging.Phone(national), history)
+		err = h.otps.Rotate(ctx, national, rec, ttl, history)
+	case replace:
+		err = h.otps.Save(ctx, national, rec, ttl)
+	default:
+		err = h.otps.Create(ctx, national, rec, ttl)
+	}
+	if errors.Is(err, otpstore.ErrExists) {
+		// The concurrent request that won also holds the active slot; it is
+		// the same member, so it must not be released here.
+		secs := int(math.Ceil(ttl.Seconds()))
+		if left, err := h.otps.TTL(ctx, national); err == nil {
+			secs = int(math.Ceil(left.Seconds()))
+		}
+		log.Printf("[%s] OTP created concurrently, rejecting | ip=%s | phone=%s | retry_after=%ds", tag, ip, logging.Phone(national), secs)
+		c.Header("Retry-After", strconv.Itoa(secs))
+		h.reply(c, http.StatusTooManyRequests, i18n.OTPAlreadySent, gin.H{"success": false, "retry_after": secs})
+		return "", 0, false
+	}
+	if err != nil {
+		log.Printf("[%s] Redis SETEX error, OTP not sent | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+		// A code that was being replaced is still stored and keeps its slot.
+		if !replace {
+			if relErr := h.otps.Release(ctx, national); relErr != nil {
+				log.Printf("[%s] Failed to release active slot | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), relErr)
+			}
+		}
+		h.storeFailed(c, err)
+		return "", 0, false
+	}
+	h.stats.otpIssued.Add(1)
+	if cooldown > 0 {
+		if err := h.otps.StartResend(ctx, national, cooldown); err != nil {
+			log.Printf("[%s] Failed to start resend cooldown | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(national), err)
+		}
+	}
+	h.publish(eventbus.TypeCreated, eventbus.KindOTP, c.GetString(middleware.TenantKey), "", phone.CountryCode()+national)
+	return code, ttl, true
+}
+
+// Compare handles POST /compare.
+// Verifies the submitted OTP against the value stored in Redis and consumes
+// it on a match, atomically, so a racing compare or invalidate cannot act on
+// the same code.
+func (h *Handler) Compare(c *gin.Context) {
+	ip := c.ClientIP()
+	log.Printf("[COMPARE] Request received | ip=%s", ip)
+
+	var body struct {
+		Phone string `json:"phone"`
+		Pass  string `json:"pass"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("[COMPARE] Failed to parse request body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+	fullPhone, err := phone.NormalizeLocal(body.Phone)
+	if err != nil {
+		log.Printf("[COMPARE] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusBadRequest, i18n.InvalidPhone, nil)
+		return
+	}
+
+	ctx := context.Background()
+
+	// The lockout is checked before the code: a locked user is told they
+	// are locked, not that a (possibly correct) code is wrong.
+	retryAfter, err := h.otps.Consume(ctx, body.Phone, body.Pass, h.conf().OTPMaxAttempts, h.conf().OTPLockout)
+	switch {
+	case errors.Is(err, otpstore.ErrLocked):
+		h.stats.otpVerifyFailed.Add(1)
+		secs := int(math.Ceil(retryAfter.Seconds()))
+		log.Printf("[COMPARE] Phone locked out | ip=%s | phone=%s | retry_after=%ds", ip, logging.Phone(body.Phone), secs)
+		c.Header("Retry-After", strconv.Itoa(secs))
+		h.reply(c, http.StatusTooManyRequests, i18n.OTPLocked, gin.H{
+			"success":     false,
+			"locked":      true,
+			"retry_after": secs,
+		})
+		return
+	case errors.Is(err, otpstore.ErrAttemptsExhausted):
+		h.stats.otpVerifyFailed.Add(1)
+		secs := int(math.Ceil(retryAfter.Seconds()))
+		log.Printf("[COMPARE] Too many attempts, OTP deleted and phone locked | ip=%s | phone=%s | retry_after=%ds", ip, logging.Phone(body.Phone), secs)
+		c.Header("Retry-After", strconv.Itoa(secs))
+		h.reply(c, http.StatusTooManyRequests, i18n.OTPAttemptsExhausted, gin.H{
+			"success":     false,
+			"locked":      true,
+			"retry_after": secs,
+		})
+		return
+	case errors.Is(err, otpstore.ErrNotFound):
+		h.stats.otpVerifyFailed.Add(1)
+		log.Printf("[COMPARE] OTP not found or expired | ip=%s | phone=%s", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusOK, i18n.OTPExpired, gin.H{"success": false})
+		return
+	case errors.Is(err, otpstore.ErrMismatch):
+		h.stats.otpVerifyFailed.Add(1)
+		log.Printf("[COMPARE] Invalid OTP attempt | ip=%s | phone=%s", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusOK, i18n.InvalidOTP, gin.H{"success": false})
+		return
+	case err != nil:
+		log.Printf("[COMPARE] Redis consume error | ip=%s | phone=%s | error=%v", ip, logging.Phone(body.Phone), err)
+		h.storeFailed(c, err)
+		return
+	}
+
+	h.stats.otpVerified.Add(1)
+	h.publish(eventbus.TypeVerified, eventbus.KindOTP, c.GetString(middleware.TenantKey), "", fullPhone)
+	log.Printf("[COMPARE] OTP verified and cleared | ip=%s | phone=%s", ip, logging.Phone(body.Phone))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Invalidate handles POST /otp/invalidate.
+// Revokes the active OTP for a phone. The delete is atomic with respect to
+// Compare: a code is either verified or revoked, never both.
+func (h *Handler) Invalidate(c *gin.Context) {
+	ip := c.ClientIP()
+	log.Printf("[OTP] Invalidate request received | ip=%s", ip)
+
+	var body struct {
+		Phone string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("[OTP] Failed to parse invalidate body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+	if _, err := phone.NormalizeLocal(body.Phone); err != nil {
+		log.Printf("[OTP] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusBadRequest, i18n.InvalidPhone, nil)
+		return
+	}
+
+	if err := h.otps.Delete(c.Request.Context(), body.Phone); err != nil {
+		log.Printf("[OTP] Redis DEL error | ip=%s | phone=%s | error=%v", ip, logging.Phone(body.Phone), err)
+		h.storeFailed(c, err)
+		return
+	}
+
+	log.Printf("[OTP] OTP invalidated | ip=%s | phone=%s", ip, logging.Phone(body.Phone))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Status handles GET /otp/status?phone=....
+// Reports whether a code is pending for the phone and how long it has left.
+// It only reads: no code is ever created or replaced here.
+func (h *Handler) Status(c *gin.Context) {
+	ip := c.ClientIP()
+	national := c.Query("phone")
+	if _, err := phone.NormalizeLocal(national); err != nil {
+		log.Printf("[OTP] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(national))
+		h.reply(c, http.StatusBadRequest, i18n.InvalidPhone, nil)
+		return
+	}
+
+	ttl, err := h.otps.TTL(c.Request.Context(), national)
+	switch {
+	case errors.Is(err, otpstore.ErrNotFound):
+		c.JSON(http.StatusOK, gin.H{"pending": false})
+		return
+	case err != nil:
+		log.Printf("[OTP] Redis TTL error | ip=%s | phone=%s | error=%v", ip, logging.Phone(national), err)
+		h.storeFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pending": true, "expires_in": int(math.Ceil(ttl.Seconds()))})
+}
+
+// GroupSMS handles POST /group_sms.
+// Emits a custom message to all connected clients via Socket.IO.
+// A dry run (see dryRun) validates without sending.
+func (h *Handler) GroupSMS(c *gin.Context) {
+	ip := c.ClientIP()
+	log.Printf("[GROUP_SMS] Request received | ip=%s", ip)
+
+	var body struct {
+		Phone   string `json:"phone"`
+		Message string `json:"message"`
+		DryRun  bool   `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("[GROUP_SMS] Failed to parse request body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.InvalidPhone, nil)
+		return
+	}
+	fullPhone, err := phone.NormalizeLocal(body.Phone)
+	if err != nil {
+		log.Printf("[GROUP_SMS] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusBadRequest, i18n.InvalidPhone, nil)
+		return
+	}
+
+	tenant := c.GetString(middleware.TenantKey)
+
+	segments, ok := h.checkLength(c, "GROUP_SMS", body.Message)
+	if !ok {
+		return
+	}
+	if dryRun(c, body.DryRun) {
+		h.replyDryRun(c, "GROUP_SMS", fullPhone, body.Message, segments)
+		return
+	}
+	// The cooldown goes first: a request it rejects must not use up the
+	// dedup claim its retry will need.
+	if h.groupCoolingDown(c, tenant) {
+		return
+	}
+	if h.duplicate(c, "GROUP_SMS", tenant, fullPhone, body.Message) {
+		return
+	}
+
+	log.Printf("[GROUP_SMS] Emitting group SMS via socket | ip=%s | tenant=%s | phone=%s | message_len=%d",
+		ip, tenant, logging.Phone(fullPhone), len(body.Message))
+	reached, err := h.send.EmitToTenant(tenant, "otp", socketserver.OTPEvent{
+		Phone:    fullPhone,
+		Pass:     body.Message,
+		Category: socketserver.CategoryGroup,
+	})
+	if err != nil {
+		h.releaseDuplicate("GROUP_SMS", tenant, fullPhone, body.Message)
+		log.Printf("[GROUP_SMS] Group SMS refused | ip=%s | phone=%s | error=%v", ip, logging.Phone(fullPhone), err)
+		h.reply(c, http.StatusServiceUnavailable, i18n.BroadcastRefused, gin.H{"success": false})
+		return
+	}
+	if reached == 0 {
+		h.releaseDuplicate("GROUP_SMS", tenant, fullPhone, body.Message)
+		h.publish(eventbus.TypeFailed, eventbus.KindSMS, tenant, "", fullPhone)
+		log.Printf("[GROUP_SMS] No gateway connected | ip=%s | tenant=%s | phone=%s", ip, tenant, logging.Phone(fullPhone))
+		h.reply(c, http.StatusServiceUnavailable, i18n.NoGateway, gin.H{"success": false, "phone": fullPhone})
+		return
+	}
+	h.stats.smsEmitted.Add(1)
+	h.publish(eventbus.TypeSent, eventbus.KindSMS, tenant, "", fullPhone)
+
+	log.Printf("[GROUP_SMS] Group SMS sent successfully | ip=%s | phone=%s", ip, logging.Phone(fullPhone))
+	h.reply(c, http.StatusOK, i18n.GroupSMSSent, gin.H{
+		"success":  true,
+		"phone":    fullPhone,
+		"segments": segments,
+	})
+}
+
+// SendSMS handles POST /send-sms.
+// Accepts phone numbers with or without the country code.
+// With ?wait=true the response reflects the gateway's delivery ack; a dry
+// run (see dryRun) validates without sending.
+func (h *Handler) SendSMS(c *gin.Context) {
+	ip := c.ClientIP()
+	log.Printf("[SEND_SMS] Request received | ip=%s", ip)
+
+	var body struct {
+		Phone       string `json:"phone"`
+		Message     string `json:"message"`
+		CallbackURL string `json:"callback_url"`
+		DryRun      bool   `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("[SEND_SMS] Failed to parse request body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+	fullPhone, err := phone.Normalize(body.Phone)
+	if err != nil {
+		log.Printf("[SEND_SMS] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+
+	segments, ok := h.checkLength(c, "SEND_SMS", body.Message)
+	if !ok {
+		return
+	}
+	if dryRun(c, body.DryRun) {
+		if _, ok := h.callbackMessage(c, "SEND_SMS", body.CallbackURL, "", fullPhone); ok {
+			h.replyDryRun(c, "SEND_SMS", fullPhone, body.Message, segments)
+		}
+		return
+	}
+	event := socketserver.OTPEvent{
+		Phone:    fullPhone,
+		Pass:     body.Message,
+		Category: socketserver.CategoryTransactional,
+	}
+
+	// A callback needs an id to tie its events to this send.
+	var msg *message
+	if body.CallbackURL != "" {
+		id, err := newMessageID()
+		if err != nil {
+			log.Printf("[SEND_SMS] Failed to generate message id | ip=%s | error=%v", ip, err)
+			h.reply(c, http.StatusInternalServerError, i18n.InternalError, nil)
+			return
+		}
+		var ok bool
+		if msg, ok = h.callbackMessage(c, "SEND_SMS", body.CallbackURL, id, fullPhone); !ok {
+			return
+		}
+		event.MessageID = id
+	}
+
+	// Claimed only once the request is known to be valid; every path below
+	// that does not send gives the claim back.
+	if h.duplicate(c, "SEND_SMS", c.GetString(middleware.TenantKey), fullPhone, body.Message) {
+		return
+	}
+
+	if c.Query("wait") == "true" {
+		h.sendAndWait(c, event, msg)
+		return
+	}
+
+	log.Printf("[SEND_SMS] Emitting SMS via socket | ip=%s | phone=%s | message_len=%d", ip, logging.Phone(fullPhone), len(body.Message))
+	tenant := c.GetString(middleware.TenantKey)
+	reached, route, err := h.emit(tenant, event)
+	if errors.Is(err, socketserver.ErrNoClients) && h.hold(tenant, event, 0, msg) {
+		fields := gin.H{"success": true, "queued": true, "phone": fullPhone}
+		if event.MessageID != "" {
+			fields["message_id"] = event.MessageID
+		}
+		h.reply(c, http.StatusAccepted, i18n.MessageHeld, fields)
+		return
+	}
+	if err != nil || reached == 0 {
+		h.releaseDuplicate("SEND_SMS", tenant, fullPhone, body.Message)
+		h.notify(msg, webhook.StatusFailed, "")
+		h.publish(eventbus.TypeFailed, eventbus.KindSMS, tenant, event.MessageID, fullPhone)
+		fields := gin.H{"success": false, "phone": fullPhone}
+		if event.MessageID != "" {
+			fields["message_id"] = event.MessageID
+		}
+		switch {
+		case errors.Is(err, socketserver.ErrFanoutExceeded):
+			log.Printf("[SEND_SMS] Broadcast refused | ip=%s | phone=%s | error=%v", ip, logging.Phone(fullPhone), err)
+			h.reply(c, http.StatusServiceUnavailable, i18n.BroadcastRefused, fields)
+		case errors.Is(err, socketserver.ErrQueueFull):
+			log.Printf("[SEND_SMS] All gateway queues full | ip=%s | phone=%s", ip, logging.Phone(fullPhone))
+			h.reply(c, http.StatusServiceUnavailable, i18n.AtCapacity, fields)
+		default:
+			log.Printf("[SEND_SMS] No gateway took the SMS | ip=%s | phone=%s | error=%v", ip, logging.Phone(fullPhone), err)
+			h.reply(c, http.StatusServiceUnavailable, i18n.NoGateway, fields)
+		}
+		return
+	}
+	h.stats.smsEmitted.Add(1)
+	h.notify(msg, webhook.StatusDispatched, route.ClientID)
+	h.publish(eventbus.TypeSent, eventbus.KindSMS, tenant, event.MessageID, fullPhone)
+
+	log.Printf("[SEND_SMS] SMS sent successfully | ip=%s | phone=%s | client=%s", ip, logging.Phone(fullPhone), route.ClientID)
+	fields := gin.H{
+		"success":  true,
+		"phone":    fullPhone,
+		"pass":     body.Message,
+		"segments": segments,
+	}
+	if event.MessageID != "" {
+		fields["message_id"] = event.MessageID
+	}
+	h.reply(c, http.StatusOK, i18n.MessageSent, withGateway(c, fields, route))
+}
+
+// dryRun reports whether a send asked to be validated only, by a
+// "dry_run": true body field or a dry_run=1 (or true) query parameter.
+func dryRun(c *gin.Context, inBody bool) bool {
+	q := c.Query("dry_run")
+	return inBody || q == "1" || q == "true"
+}
+
+// replyDryRun answers a dry-run send with what would have been sent. Nothing
+// is emitted, and no dedup or cooldown state is touched, so a dry run never
+// blocks the real send that follows it.
+func (h *Handler) replyDryRun(c *gin.Context, tag, phone, message string, segments int) {
+	log.Printf("[%s] Dry run, not sending | ip=%s | phone=%s | message_len=%d | segments=%d",
+		tag, c.ClientIP(), logging.Phone(phone), len(message), segments)
+	h.reply(c, http.StatusOK, i18n.DryRun, gin.H{
+		"success":  true,
+		"dry_run":  true,
+		"phone":    phone,
+		"pass":     message,
+		"segments": segments,
+	})
+}
+
+// emit sends a single-recipient message to the tenant's gateways and returns
+// how many took it. With per-gateway queues enabled exactly one gateway gets
+// it, in order behind that gateway's earlier messages, and its Route is
+// returned; otherwise every gateway of the tenant receives it and the Route
+// is empty. A broadcast goes first to the room SOCKET_ROOM_PREFIXES assigns
+// the phone, and to every gateway of the tenant only when no gateway is in
+// that room. The error says why no gateway took it; ErrNoClients means none
+// of the tenant's gateways is connected.
+func (h *Handler) emit(tenant string, event socketserver.OTPEvent) (int, socketserver.Route, error) {
+	if h.conf().SocketQueueSize <= 0 {
+		if room := h.conf().RoomFor(event.Phone); room != "" {
+			reached, err := h.send.EmitToTenantRoom(tenant, room, "otp", event)
+			if err != nil {
+				log.Printf("[SOCKET] Room broadcast failed | tenant=%s | room=%s | phone=%s | error=%v", tenant, room, logging.Phone(event.Phone), err)
+				return 0, socketserver.Route{}, err
+			}
+			if reached > 0 {
+				return reached, socketserver.Route{}, nil
+			}
+			log.Printf("[SOCKET] No gateway in room, broadcasting to tenant | tenant=%s | room=%s | phone=%s", tenant, room, logging.Phone(event.Phone))
+		}
+		reached, err := h.send.EmitToTenant(tenant, "otp", event)
+		if err != nil {
+			log.Printf("[SOCKET] Broadcast failed | tenant=%s | phone=%s | error=%v", tenant, logging.Phone(event.Phone), err)
+			return 0, socketserver.Route{}, err
+		}
+		if reached == 0 {
+			return 0, socketserver.Route{}, socketserver.ErrNoClients
+		}
+		return reached, socketserver.Route{}, nil
+	}
+	route, err := h.send.Dispatch(tenant, "otp", event)
+	if err != nil {
+		log.Printf("[SOCKET] Dispatch failed | tenant=%s | phone=%s | error=%v", tenant, logging.Phone(event.Phone), err)
+		return 0, route, err
+	}
+	return 1, route, nil
+}
+
+// duplicate reports whether the same message was already sent to phone
+// within the dedup window, answering the request itself when it was.
+// Redis errors let the send through: a possible duplicate beats a lost
+// message. A send that then fails must give the claim back with
+// releaseDuplicate.
+func (h *Handler) duplicate(c *gin.Context, tag, tenant, phone, message string) bool {
+	if h.conf().EmitDedupWindow <= 0 {
+		return false
+	}
+	ip := c.ClientIP()
+
+	first, err := h.otps.ClaimEmit(c.Request.Context(), tenant, phone, message, h.conf().EmitDedupWindow)
+	if err != nil {
+		log.Printf("[%s] Dedup check failed, sending anyway | ip=%s | phone=%s | error=%v", tag, ip, logging.Phone(phone), err)
+		return false
+	}
+	if first {
+		return false
+	}
+
+	log.Printf("[%s] Duplicate message suppressed | ip=%s | phone=%s | window=%s", tag, ip, logging.Phone(phone), h.conf().EmitDedupWindow)
+	h.reply(c, http.StatusOK, i18n.DuplicateSuppressed, gin.H{
+		"success":   true,
+		"duplicate": true,
+		"phone":     phone,
+	})
+	return true
+}
+
+// releaseDuplicate gives back the claim duplicate took for a message that
+// did not go out, so a retry within the window is sent rather than answered
+// as a duplicate of nothing.
+func (h *Handler) releaseDuplicate(tag, tenant, phone, message string) {
+	if h.conf().EmitDedupWindow <= 0 {
+		return
+	}
+	if err := h.otps.ReleaseEmit(context.Background(), tenant, phone, message); err != nil {
+		log.Printf("[%s] Failed to release dedup claim | phone=%s | error=%v", tag, logging.Phone(phone), err)
+	}
+}
+
+// groupCoolingDown reports whether a group broadcast is still inside the
+// cooldown started by the previous one, answering 429 itself when it is.
+// Redis errors let the broadcast through, as with dedup.
+func (h *Handler) groupCoolingDown(c *gin.Context, tenant string) bool {
+	interval := h.conf().GroupSMSCooldown
+	if interval <= 0 {
+		return false
+	}
+	ip := c.ClientIP()
+
+	scope := ""
+	if h.conf().GroupSMSCooldownScope == "tenant" {
+		scope = tenant
+	}
+	retryAfter, err := h.otps.ClaimGroupBroadcast(c.Request.Context(), scope, interval)
+	if err != nil {
+		log.Printf("[GROUP_SMS] Cooldown check failed, sending anyway | ip=%s | tenant=%s | error=%v", ip, tenant, err)
+		return false
+	}
+	if retryAfter <= 0 {
+		return false
+	}
+
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	log.Printf("[GROUP_SMS] Cooldown active, rejecting | ip=%s | tenant=%s | retry_after=%ds", ip, tenant, secs)
+	c.Header("Retry-After", strconv.Itoa(secs))
+	h.reply(c, http.StatusTooManyRequests, i18n.GroupSMSCooldown, gin.H{
+		"success":     false,
+		"retry_after": secs,
+	})
+	return true
+}
+
+// sendAndWait emits event and waits up to the configured timeout for a
+// gateway ack. The response distinguishes confirmed delivery, confirmed
+// failure, and "pending" when no ack arrived in time — the message may still
+// be delivered, so a timeout is reported as 202 rather than an error.
+// Lifecycle events go to msg's callback, if any.
+func (h *Handler) sendAndWait(c *gin.Context, event socketserver.OTPEvent, msg *message) {
+	ip := c.ClientIP()
+
+	if event.MessageID == "" {
+		id, err := newMessageID()
+		if err != nil {
+			log.Printf("[SEND_SMS] Failed to generate message id | ip=%s | error=%v", ip, err)
+			h.reply(c, http.StatusInternalServerError, i18n.InternalError, nil)
+			return
+		}
+		event.MessageID = id
+	}
+	id := event.MessageID
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.conf().SendWaitTimeout)
+	defer cancel()
+
+	log.Printf("[SEND_SMS] Emitting SMS and waiting for ack | ip=%s | phone=%s | message_id=%s | timeout=%s",
+		ip, logging.Phone(event.Phone), id, h.conf().SendWaitTimeout)
+	tenant := c.GetString(middleware.TenantKey)
+	ack, err := h.send.EmitWithAck(ctx, tenant, "otp", event)
+
+	refused := errors.Is(err, socketserver.ErrNoClients) || errors.Is(err, socketserver.ErrFanoutExceeded)
+	// An unacked message may still arrive, so only a refusal or a reported
+	// failure frees the dedup claim for a retry.
+	if refused || (err == nil && ack.Status == socketserver.StatusFailed) {
+		h.releaseDuplicate("SEND_SMS", tenant, event.Phone, event.Pass)
+	}
+	if refused {
+		h.publish(eventbus.TypeFailed, eventbus.KindSMS, tenant, id, event.Phone)
+	} else {
+		h.stats.smsEmitted.Add(1)
+		h.notify(msg, webhook.StatusDispatched, "")
+		h.publish(eventbus.TypeSent, eventbus.KindSMS, tenant, id, event.Phone)
+	}
+
+	fields := gin.H{"message_id": id, "phone": event.Phone}
+	switch {
+	case errors.Is(err, socketserver.ErrNoClients):
+		log.Printf("[SEND_SMS] No gateway connected | ip=%s | message_id=%s", ip, id)
+		h.notify(msg, webhook.StatusFailed, "")
+		fields["success"] = false
+		fields["status"] = socketserver.StatusFailed
+		h.reply(c, http.StatusServiceUnavailable, i18n.NoGateway, fields)
+	case errors.Is(err, socketserver.ErrFanoutExceeded):
+		log.Printf("[SEND_SMS] Broadcast refused | ip=%s | message_id=%s | error=%v", ip, id, err)
+		h.notify(msg, webhook.StatusFailed, "")
+		fields["success"] = false
+		fields["status"] = socketserver.StatusFailed
+		h.reply(c, http.StatusServiceUnavailable, i18n.BroadcastRefused, fields)
+	case err != nil:
+		log.Printf("[SEND_SMS] Delivery unconfirmed | ip=%s | message_id=%s | error=%v", ip, id, err)
+		fields["status"] = "pending"
+		h.reply(c, http.StatusAccepted, i18n.DeliveryPending, fields)
+	case ack.Status == socketserver.StatusFailed:
+		log.Printf("[SEND_SMS] Gateway reported failure | ip=%s | message_id=%s | client=%s", ip, id, ack.ClientID)
+		h.notify(msg, webhook.StatusAcked, ack.ClientID)
+		h.notify(msg, webhook.StatusFailed, ack.ClientID)
+		h.publish(eventbus.TypeFailed, eventbus.KindSMS, tenant, id, event.Phone)
+		fields["success"] = false
+		fields["status"] = socketserver.StatusFailed
+		h.reply(c, http.StatusBadGateway, i18n.DeliveryFailed, withGateway(c, fields, ack.Route))
+	default:
+		log.Printf("[SEND_SMS] Delivery confirmed | ip=%s | message_id=%s | client=%s", ip, id, ack.ClientID)
+		h.notify(msg, webhook.StatusAcked, ack.ClientID)
+		h.notify(msg, webhook.StatusDelivered, ack.ClientID)
+		fields["success"] = true
+		fields["status"] = socketserver.StatusDelivered
+		fields["pass"] = event.Pass
+		h.reply(c, http.StatusOK, i18n.MessageSent, withGateway(c, fields, ack.Route))
+	}
+}
+
+// newMessageID returns a random identifier used to correlate a sent message
+// with its delivery ack.
+func newMessageID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generateOTP returns a code of length characters drawn uniformly from
+// alphabet; leading zeros are kept. With length 0 or an empty alphabet it
+// returns the classic 5-digit OTP in the range [10000, 99999]. Uses
+// crypto/rand for cryptographic safety.
+func generateOTP(length int, alphabet string) (string, error) {
+	if length > 0 && alphabet != "" {
+		if length > maxOTPLength {
+			length = maxOTPLength
+		}
+		return generateCode(length, alphabet)
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(90000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", n.Int64()+10000), nil
+}
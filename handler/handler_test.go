@@ -0,0 +1,520 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sms_service/config"
+	"sms_service/i18n"
+	"sms_service/otpstore"
+	"sms_service/socketserver"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// testPhone is a valid national number under the default numbering plan.
+const testPhone = "61234567"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestHandler builds a Handler on miniredis and fb, with the config taken
+// from the environment (set overrides with t.Setenv before calling). The OTP
+// template is the bare code, so tests can read it off the sent event.
+func newTestHandler(t *testing.T, fb *fakeBroadcaster) (*Handler, *miniredis.Miniredis) {
+	t.Helper()
+	t.Setenv("OTP_MESSAGE_TEMPLATE", codePlaceholder)
+
+	mr := miniredis.RunT(t)
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	otps, err := otpstore.New(rdb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := i18n.Load("", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := New(cfg, otps, fb, fakeGateways{}, msgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, mr
+}
+
+// serve runs handle on a JSON POST. It is safe to call from any goroutine.
+func serve(handle gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handle(c)
+	return w
+}
+
+// post runs handle on a JSON POST and returns the status and decoded body.
+func post(t *testing.T, handle gin.HandlerFunc, body string) (int, map[string]interface{}) {
+	t.Helper()
+	w := serve(handle, body)
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return w.Code, out
+}
+
+// sentOTP returns the event fb recorded last.
+func sentOTP(t *testing.T, fb *fakeBroadcaster) socketserver.OTPEvent {
+	t.Helper()
+	sent := fb.events()
+	if len(sent) == 0 {
+		t.Fatal("nothing was sent")
+	}
+	ev, ok := sent[len(sent)-1].data.(socketserver.OTPEvent)
+	if !ok {
+		t.Fatalf("sent %T, want OTPEvent", sent[len(sent)-1].data)
+	}
+	return ev
+}
+
+func TestOTPSendsStoredCode(t *testing.T) {
+	fb := &fakeBroadcaster{reached: 1}
+	h, _ := newTestHandler(t, fb)
+
+	status, body := post(t, h.OTP, `{"phone":"`+testPhone+`"}`)
+	if status != http.StatusOK || body["success"] != true {
+		t.Fatalf("OTP = %d %v, want 200 success", status, body)
+	}
+	ev := sentOTP(t, fb)
+	if ev.Phone != "+993"+testPhone || ev.Category != socketserver.CategoryOTP {
+		t.Fatalf("sent %+v", ev)
+	}
+
+	status, body = post(t, h.Compare, `{"phone":"`+testPhone+`","pass":"`+ev.Pass+`"}`)
+	if status != http.StatusOK || body["success"] != true {
+		t.Fatalf("Compare with the sent code = %d %v, want success", status, body)
+	}
+}
+
+func TestOTPRejectsActiveCode(t *testing.T) {
+	fb := &fakeBroadcaster{reached: 1}
+	h, _ := newTestHandler(t, fb)
+
+	post(t, h.OTP, `{"phone":"`+testPhone+`"}`)
+	status, body := post(t, h.OTP, `{"phone":"`+testPhone+`"}`)
+	if status != http.StatusTooManyRequests || body["code"] != i18n.OTPAlreadySent {
+		t.Fatalf("second OTP = %d %v, want 429 %s", status, body, i18n.OTPAlreadySent)
+	}
+	if n := len(fb.events()); n != 1 {
+		t.Fatalf("sent %d events, want 1", n)
+	}
+}
+
+func TestOTPDiscardsUndeliverableCode(t *testing.T) {
+	fb := &fakeBroadcaster{reached: 0}
+	h, _ := newTestHandler(t, fb)
+
+	status, body := post(t, h.OTP, `{"phone":"`+testPhone+`"}`)
+	if status != http.StatusServiceUnavailable || body["code"] != i18n.NoGateway {
+		t.Fatalf("OTP = %d %v, want 503 %s", status, body, i18n.NoGateway)
+	}
+	if _, err := h.otps.Get(context.Background(), testPhone); !errors.Is(err, otpstore.ErrNotFound) {
+		t.Fatalf("stored code after failed send: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOTPInvalidPhone(t *testing.T) {
+	fb := &fakeBroadcaster{reached: 1}
+	h, _ := newTestHandler(t, fb)
+
+	for _, raw := range []string{`{"phone":"123"}`, `{"phone":"+99361234567"}`, `not json`} {
+		if status, _ := post(t, h.OTP, raw); status != http.StatusBadRequest {
+			t.Errorf("OTP(%s) = %d, want 400", raw, status)
+		}
+	}
+	if n := len(fb.events()); n != 0 {
+		t.Fatalf("sent %d events for invalid requests", n)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		pass    string
+		status  int
+		success bool
+		code    string
+	}{
+		{name: "match", stored: "12345", pass: "12345", status: http.StatusOK, success: true},
+		{name: "mismatch", stored: "12345", pass: "54321", status: http.StatusOK, code: i18n.InvalidOTP},
+		{name: "none stored", pass: "12345", status: http.StatusOK, code: i18n.OTPExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHandler(t, &fakeBroadcaster{})
+			if tt.stored != "" {
+				storeCode(t, h, tt.stored)
+			}
+
+			status, body := post(t, h.Compare, `{"phone":"`+testPhone+`","pass":"`+tt.pass+`"}`)
+			if status != tt.status || body["success"] != tt.success {
+				t.Fatalf("Compare = %d %v, want %d success=%t", status, body, tt.status, tt.success)
+			}
+			if tt.code != "" && body["code"] != tt.code {
+				t.Fatalf("code = %v, want %s", body["code"], tt.code)
+			}
+		})
+	}
+}
+
+func TestCompareConsumesCode(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeBroadcaster{})
+	storeCode(t, h, "12345")
+
+	req := `{"phone":"` + testPhone + `","pass":"12345"}`
+	if _, body := post(t, h.Compare, req); body["success"] != true {
+		t.Fatalf("first Compare = %v, want success", body)
+	}
+	if _, body := post(t, h.Compare, req); body["success"] != false {
+		t.Fatalf("second Compare = %v, want the code consumed", body)
+	}
+}
+
+func TestGroupSMS(t *testing.T) {
+	fb := &fakeBroadcaster{reached: 3}
+	h, _ := newTestHandler(t, fb)
+
+	status, body := post(t, h.GroupSMS, `{"phone":"`+testPhone+`","message":"hello"}`)
+	if status != http.StatusOK || body["code"] != i18n.GroupSMSSent {
+		t.Fatalf("GroupSMS = %d %v, want 200 %s", status, body, i18n.GroupSMSSent)
+	}
+	ev := sentOTP(t, fb)
+	if ev.Pass != "hello" || ev.Category != socketserver.CategoryGroup {
+		t.Fatalf("sent %+v", ev)
+	}
+}
+
+func TestGroupSMSRefused(t *testing.T) {
+	fb := &fakeBroadcaster{err: socketserver.ErrFanoutExceeded}
+	h, _ := newTestHandler(t, fb)
+
+	status, body := post(t, h.GroupSMS, `{"phone":"`+testPhone+`","message":"hello"}`)
+	if status != http.StatusServiceUnavailable || body["code"] != i18n.BroadcastRefused {
+		t.Fatalf("GroupSMS = %d %v, want 503 %s", status, body, i18n.BroadcastRefused)
+	}
+}
+
+func TestGroupSMSNoGateway(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeBroadcaster{})
+
+	status, body := post(t, h.GroupSMS, `{"phone":"`+testPhone+`","message":"hello"}`)
+	if status != http.StatusServiceUnavailable || body["code"] != i18n.NoGateway || body["success"] != false {
+		t.Fatalf("GroupSMS = %d %v, want 503 %s", status, body, i18n.NoGateway)
+	}
+}
+
+func TestSendSMS(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		reached int
+		err     error
+		status  int
+		code    string
+	}{
+		{name: "sent", body: `{"phone":"61234567","message":"hi"}`, reached: 1, status: http.StatusOK, code: i18n.MessageSent},
+		{name: "country code", body: `{"phone":"+99361234567","message":"hi"}`, reached: 1, status: http.StatusOK, code: i18n.MessageSent},
+		{name: "no gateway", body: `{"phone":"61234567","message":"hi"}`, status: http.StatusServiceUnavailable, code: i18n.NoGateway},
+		{name: "fan-out refused", body: `{"phone":"61234567","message":"hi"}`, err: socketserver.ErrFanoutExceeded, status: http.StatusServiceUnavailable, code: i18n.BroadcastRefused},
+		{name: "invalid phone", body: `{"phone":"12","message":"hi"}`, reached: 1, status: http.StatusBadRequest, code: i18n.BadRequest},
+		{name: "too long", body: `{"phone":"61234567","message":"` + strings.Repeat("x", 613) + `"}`, reached: 1, status: http.StatusBadRequest, code: i18n.MessageTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &fakeBroadcaster{reached: tt.reached, err: tt.err}
+			h, _ := newTestHandler(t, fb)
+
+			status, body := post(t, h.SendSMS, tt.body)
+			if status != tt.status || body["code"] != tt.code {
+				t.Fatalf("SendSMS = %d %v, want %d %s", status, body, tt.status, tt.code)
+			}
+			if status == http.StatusOK && sentOTP(t, fb).Phone != "+993"+testPhone {
+				t.Fatalf("sent to %q", sentOTP(t, fb).Phone)
+			}
+		})
+	}
+}
+
+func TestOTPStoreFailureSendsNothing(t *testing.T) {
+	tests := []struct {
+		name   string
+		fail   func(mr *miniredis.Miniredis)
+		status int
+		code   string
+	}{
+		{name: "command error", fail: func(mr *miniredis.Miniredis) { mr.SetError("ERR injected") }, status: http.StatusInternalServerError, code: i18n.InternalError},
+		{name: "redis down", fail: func(mr *miniredis.Miniredis) { mr.Close() }, status: http.StatusServiceUnavailable, code: i18n.ServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fb := &fakeBroadcaster{reached: 1}
+			h, mr := newTestHandler(t, fb)
+			tt.fail(mr)
+
+			status, body := post(t, h.OTP, `{"phone":"`+testPhone+`"}`)
+			if status != tt.status || body["code"] != tt.code {
+				t.Fatalf("OTP = %d %v, want %d %s", status, body, tt.status, tt.code)
+			}
+			if n := len(fb.events()); n != 0 {
+				t.Fatalf("sent %d events although the code was never stored", n)
+			}
+		})
+	}
+}
+
+// storeCode stores code as the active OTP for testPhone.
+func storeCode(t *testing.T, h *Handler, code string) {
+	t.Helper()
+	rec, err := otpstore.NewRecord(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.otps.Create(context.Background(), testPhone, rec, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentCompareVerifiesOnce(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeBroadcaster{})
+	storeCode(t, h, "12345")
+
+	const callers = 20
+	var (
+		wg       sync.WaitGroup
+		verified atomic.Int32
+	)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(h.Compare, `{"phone":"`+testPhone+`","pass":"12345"}`)
+			if strings.Contains(w.Body.String(), `"success":true`) {
+				verified.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := verified.Load(); n != 1 {
+		t.Fatalf("%d of %d concurrent compares verified the code, want exactly 1", n, callers)
+	}
+}
+
+func TestCompareRacingInvalidate(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeBroadcaster{})
+
+	for i := 0; i < 50; i++ {
+		storeCode(t, h, "12345")
+
+		var (
+			wg      sync.WaitGroup
+			compare *httptest.ResponseRecorder
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			compare = serve(h.Compare, `{"phone":"`+testPhone+`","pass":"12345"}`)
+		}()
+		go func() {
+			defer wg.Done()
+			serve(h.Invalidate, `{"phone":"`+testPhone+`"}`)
+		}()
+		wg.Wait()
+
+		// Either order is fine, but the code must be gone afterwards, and a
+		// compare that lost the race must see it as expired, not wrong.
+		if _, err := h.otps.Get(context.Background(), testPhone); !errors.Is(err, otpstore.ErrNotFound) {
+			t.Fatalf("round %d: code survived compare and invalidate: %v", i, err)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(compare.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["success"] != true && body["code"] != i18n.OTPExpired {
+			t.Fatalf("round %d: compare = %v, want success or %s", i, body, i18n.OTPExpired)
+		}
+	}
+}
+
+func TestCompareLockoutBeforeCorrectness(t *testing.T) {
+	t.Setenv("OTP_MAX_ATTEMPTS", "2")
+	t.Setenv("OTP_LOCKOUT_SECONDS", "60")
+	h, mr := newTestHandler(t, &fakeBroadcaster{})
+	storeCode(t, h, "12345")
+
+	wrong := `{"phone":"` + testPhone + `","pass":"00000"}`
+	right := `{"phone":"` + testPhone + `","pass":"12345"}`
+
+	if _, body := post(t, h.Compare, wrong); body["code"] != i18n.InvalidOTP {
+		t.Fatalf("first wrong code = %v, want %s", body, i18n.InvalidOTP)
+	}
+	status, body := post(t, h.Compare, wrong)
+	if status != http.StatusTooManyRequests || body["code"] != i18n.OTPAttemptsExhausted || body["locked"] != true {
+		t.Fatalf("last wrong code = %d %v, want 429 %s", status, body, i18n.OTPAttemptsExhausted)
+	}
+
+	// A fresh code does not lift the lock, and while locked the correct
+	// code is reported as a lockout, never as wrong or accepted.
+	storeCode(t, h, "12345")
+	for _, req := range []string{right, wrong} {
+		status, body := post(t, h.Compare, req)
+		if status != http.StatusTooManyRequests || body["code"] != i18n.OTPLocked || body["locked"] != true {
+			t.Fatalf("Compare(%s) while locked = %d %v, want 429 %s", req, status, body, i18n.OTPLocked)
+		}
+		if secs, _ := body["retry_after"].(float64); secs <= 0 || secs > 60 {
+			t.Fatalf("retry_after = %v, want 1-60", body["retry_after"])
+		}
+	}
+
+	mr.FastForward(61 * time.Second)
+	storeCode(t, h, "12345")
+	if _, body := post(t, h.Compare, right); body["success"] != true {
+		t.Fatalf("Compare after the lockout = %v, want success", body)
+	}
+}
+
+func TestOTPSlotsExpireByRedisClock(t *testing.T) {
+	t.Setenv("MAX_ACTIVE_OTPS", "1")
+	t.Setenv("OTP_TTL_SECONDS", "60")
+	h, mr := newTestHandler(t, &fakeBroadcaster{reached: 1})
+	base := time.Date(2026, time.March, 29, 0, 30, 0, 0, time.UTC)
+	mr.SetTime(base)
+
+	if status, body := post(t, h.OTP, `{"phone":"61234567"}`); status != http.StatusOK {
+		t.Fatalf("first OTP = %d %v", status, body)
+	}
+	if status, body := post(t, h.OTP, `{"phone":"62345678"}`); status != http.StatusServiceUnavailable || body["code"] != i18n.AtCapacity {
+		t.Fatalf("OTP at capacity = %d %v, want 503 %s", status, body, i18n.AtCapacity)
+	}
+
+	// Only Redis's clock has moved past the first slot's expiry; the
+	// process clock has not, and must not be what frees the slot.
+	mr.SetTime(base.Add(61 * time.Second))
+	if status, body := post(t, h.OTP, `{"phone":"62345678"}`); status != http.StatusOK {
+		t.Fatalf("OTP after the slot expired = %d %v, want 200", status, body)
+	}
+}
+
+func TestRateWindowFollowsRedisClock(t *testing.T) {
+	h, mr := newTestHandler(t, &fakeBroadcaster{reached: 1})
+	base := time.Date(2026, time.March, 29, 0, 30, 0, 0, time.UTC)
+	mr.SetTime(base)
+	ctx := context.Background()
+
+	if wait, err := h.otps.AllowRequest(ctx, "/otp", "client", 1, time.Minute); err != nil || wait != 0 {
+		t.Fatalf("first request = %v, %v; want allowed", wait, err)
+	}
+	if wait, err := h.otps.AllowRequest(ctx, "/otp", "client", 1, time.Minute); err != nil || wait <= 0 {
+		t.Fatalf("second request = %v, %v; want limited", wait, err)
+	}
+
+	// As with OTP slots, only Redis's clock moves the window along.
+	mr.SetTime(base.Add(61 * time.Second))
+	if wait, err := h.otps.AllowRequest(ctx, "/otp", "client", 1, time.Minute); err != nil || wait != 0 {
+		t.Fatalf("request after the window = %v, %v; want allowed", wait, err)
+	}
+}
+
+func TestFailedSendDoesNotSuppressRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(h *Handler) gin.HandlerFunc
+		query  string
+		fail   func(fb *fakeBroadcaster)
+	}{
+		{name: "send-sms no gateway", handle: func(h *Handler) gin.HandlerFunc { return h.SendSMS }, fail: func(fb *fakeBroadcaster) { fb.reached = 0 }},
+		{name: "send-sms fan-out refused", handle: func(h *Handler) gin.HandlerFunc { return h.SendSMS }, fail: func(fb *fakeBroadcaster) { fb.err = socketserver.ErrFanoutExceeded }},
+		{name: "send-sms wait no gateway", handle: func(h *Handler) gin.HandlerFunc { return h.SendSMS }, query: "?wait=true", fail: func(fb *fakeBroadcaster) { fb.reached = 0 }},
+		{name: "group_sms refused", handle: func(h *Handler) gin.HandlerFunc { return h.GroupSMS }, fail: func(fb *fakeBroadcaster) { fb.err = socketserver.ErrFanoutExceeded }},
+		{name: "group_sms no gateway", handle: func(h *Handler) gin.HandlerFunc { return h.GroupSMS }, fail: func(fb *fakeBroadcaster) { fb.reached = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("EMIT_DEDUP_SECONDS", "60")
+			fb := &fakeBroadcaster{}
+			tt.fail(fb)
+			h, _ := newTestHandler(t, fb)
+			body := `{"phone":"` + testPhone + `","message":"hello"}`
+			send := func() (int, map[string]interface{}) {
+				w := httptest.NewRecorder()
+				c, _ := gin.CreateTestContext(w)
+				c.Request = httptest.NewRequest(http.MethodPost, "/"+tt.query, strings.NewReader(body))
+				c.Request.Header.Set("Content-Type", "application/json")
+				tt.handle(h)(c)
+				var out map[string]interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+					t.Fatal(err)
+				}
+				return w.Code, out
+			}
+
+			if status, out := send(); status != http.StatusServiceUnavailable {
+				t.Fatalf("failing send = %d %v, want 503", status, out)
+			}
+
+			fb.reached, fb.err = 1, nil
+			status, out := send()
+			if status != http.StatusOK || out["duplicate"] == true {
+				t.Fatalf("retry = %d %v, want it sent", status, out)
+			}
+			if n := len(fb.events()); n != 1 {
+				t.Fatalf("%d events sent, want 1", n)
+			}
+
+			if _, out := send(); out["duplicate"] != true {
+				t.Fatalf("repeat after the real send = %v, want a suppressed duplicate", out)
+			}
+		})
+	}
+}
+
+func TestGroupSMSCooldownKeepsDedupFree(t *testing.T) {
+	t.Setenv("GROUP_SMS_COOLDOWN_SECONDS", "60")
+	t.Setenv("EMIT_DEDUP_SECONDS", "600")
+	fb := &fakeBroadcaster{reached: 1}
+	h, mr := newTestHandler(t, fb)
+
+	if status, body := post(t, h.GroupSMS, `{"phone":"`+testPhone+`","message":"first"}`); status != http.StatusOK {
+		t.Fatalf("first broadcast = %d %v", status, body)
+	}
+	second := `{"phone":"` + testPhone + `","message":"second"}`
+	if status, body := post(t, h.GroupSMS, second); status != http.StatusTooManyRequests || body["code"] != i18n.GroupSMSCooldown {
+		t.Fatalf("broadcast during cooldown = %d %v, want 429 %s", status, body, i18n.GroupSMSCooldown)
+	}
+
+	mr.FastForward(61 * time.Second)
+	status, body := post(t, h.GroupSMS, second)
+	if status != http.StatusOK || body["duplicate"] == true {
+		t.Fatalf("retry after cooldown = %d %v, want it sent", status, body)
+	}
+	if n := len(fb.events()); n != 2 {
+		t.Fatalf("%d broadcasts sent, want 2", n)
+	}
+}
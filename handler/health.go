@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyPingTimeout bounds the Redis ping behind the readiness probe, so a
+// hung connection fails the probe instead of stalling it.
+const readyPingTimeout = 2 * time.Second
+
+// Health handles GET /healthz (and the older GET /health).
+// Liveness only: if this answers at all, the process is serving requests,
+// so it is always 200. The body is {"status":"ok"} plus any configured
+// HEALTH_FIELDS, or plain text when HEALTH_FORMAT=text.
+func (h *Handler) Health(c *gin.Context) {
+	cfg := h.conf()
+	if cfg.HealthFormat == "text" {
+		c.String(http.StatusOK, cfg.HealthText)
+		return
+	}
+
+	body := gin.H{}
+	for k, v := range cfg.HealthFields {
+		body[k] = v
+	}
+	body["status"] = "ok"
+	c.JSON(http.StatusOK, body)
+}
+
+// Ready handles GET /readyz (and the older GET /ready).
+// Returns 503 with a per-check breakdown unless Redis answers a ping, the
+// Socket.IO serve loop is running and the post-start warmup is over, so a
+// load balancer stops routing OTP traffic to a pod that cannot serve it
+// without the pod being restarted. Warmup ends when the configured window
+// elapses or, if enabled, as soon as the first gateway connects — whichever
+// comes first.
+func (h *Handler) Ready(c *gin.Context) {
+	clients := h.socket.Stats().Connected
+	checks := gin.H{}
+	ready := true
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyPingTimeout)
+	defer cancel()
+	if err := h.otps.Ping(ctx); err != nil {
+		ready = false
+		log.Printf("[READY] Redis ping failed | error=%v", err)
+		checks["redis"] = gin.H{"ok": false, "error": "unavailable"}
+	} else {
+		checks["redis"] = gin.H{"ok": true}
+	}
+
+	checks["socket"] = gin.H{"ok": h.socket.Serving()}
+	if !h.socket.Serving() {
+		ready = false
+	}
+
+	remaining := h.conf().WarmupPeriod - time.Since(h.startedAt)
+	if remaining > 0 && !(h.conf().WarmupEndOnClient && clients > 0) {
+		ready = false
+		checks["warmup"] = gin.H{"ok": false, "remaining_seconds": int(math.Ceil(remaining.Seconds()))}
+	} else {
+		checks["warmup"] = gin.H{"ok": true}
+	}
+
+	if !ready {
+		log.Printf("[READY] Not ready | checks=%v", checks)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not_ready", "checks": checks, "connected_clients": clients})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks, "connected_clients": clients})
+}
+
+// RedisHealth handles GET /health/redis.
+// Returns per-operation Redis call counts, errors by type (timeout, nil,
+// connection, other) and latency histograms as seen by the OTP store, to
+// tell Redis-side OTP failures apart from application bugs.
+func (h *Handler) RedisHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ops": h.otps.Metrics().Snapshot()})
+}
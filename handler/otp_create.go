@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"sms_service/i18n"
+	"sms_service/logging"
+	"sms_service/middleware"
+	"sms_service/phone"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateOTP handles POST /otp/create.
+// Generates and stores a code exactly like /otp but sends no SMS; the code
+// is returned to the caller, who delivers it over its own channel (voice,
+// in-app). Because the response carries the code, this needs both
+// OTP_ALLOW_CODE_RETURN and an authenticated API key. The code is verified
+// with /compare as usual.
+func (h *Handler) CreateOTP(c *gin.Context) {
+	ip := c.ClientIP()
+	log.Printf("[OTP_CREATE] Request received | ip=%s", ip)
+
+	if !h.conf().OTPAllowCodeReturn || !authenticated(c) {
+		log.Printf("[OTP_CREATE] Code return not allowed | ip=%s | enabled=%t", ip, h.conf().OTPAllowCodeReturn)
+		h.reply(c, http.StatusForbidden, i18n.CodeReturnDisabled, gin.H{"success": false})
+		return
+	}
+
+	var body struct {
+		Phone string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		log.Printf("[OTP_CREATE] Failed to parse request body | ip=%s | error=%v", ip, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+	if _, err := phone.NormalizeLocal(body.Phone); err != nil {
+		log.Printf("[OTP_CREATE] Invalid phone number | ip=%s | phone=%q", ip, logging.Phone(body.Phone))
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, nil)
+		return
+	}
+
+	code, ttl, ok := h.issue(c, "OTP_CREATE", body.Phone)
+	if !ok {
+		return
+	}
+
+	h.stats.otpCreated.Add(1)
+	log.Printf("[OTP_CREATE] OTP stored and returned to caller | ip=%s | tenant=%s | phone=%s | ttl=%s",
+		ip, c.GetString(middleware.TenantKey), logging.Phone(body.Phone), ttl)
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"otp":         code,
+		"ttl_seconds": int(ttl.Seconds()),
+	})
+}
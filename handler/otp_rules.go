@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOTPLength   = 5
+	defaultOTPAlphabet = "0123456789"
+	maxOTPLength       = 32
+)
+
+// otpRule overrides code shape and lifetime for phones starting with prefix
+// (national number, without the country code). Partner contracts sometimes
+// require e.g. 4-digit codes for one carrier and 6-digit for another.
+type otpRule struct {
+	Prefix     string `json:"prefix"`
+	Length     int    `json:"length"`
+	Alphabet   string `json:"alphabet"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+// parseOTPRules parses the OTP_PREFIX_RULES JSON array, filling omitted
+// fields with defaults and rejecting anything that could not produce a
+// usable code. Rules are sorted longest prefix first so the most specific
+// rule wins.
+func parseOTPRules(raw string) ([]otpRule, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var rules []otpRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("parse OTP_PREFIX_RULES: %w", err)
+	}
+
+	seen := make(map[string]bool, len(rules))
+	for i := range rules {
+		r := &rules[i]
+		if r.Prefix == "" {
+			return nil, fmt.Errorf("OTP_PREFIX_RULES[%d]: prefix is required", i)
+		}
+		if seen[r.Prefix] {
+			return nil, fmt.Errorf("OTP_PREFIX_RULES[%d]: duplicate prefix %q", i, r.Prefix)
+		}
+		seen[r.Prefix] = true
+		if r.Length == 0 {
+			r.Length = defaultOTPLength
+		}
+		if r.Length < 1 || r.Length > maxOTPLength {
+			return nil, fmt.Errorf("OTP_PREFIX_RULES[%d]: length %d out of range 1-%d", i, r.Length, maxOTPLength)
+		}
+		if r.Alphabet == "" {
+			r.Alphabet = defaultOTPAlphabet
+		}
+		if r.TTLSeconds < 0 {
+			return nil, fmt.Errorf("OTP_PREFIX_RULES[%d]: ttl_seconds must not be negative", i)
+		}
+	}
+
+	// Longest prefix first.
+	for i := 1; i < len(rules); i++ {
+		for j := i; j > 0 && len(rules[j].Prefix) > len(rules[j-1].Prefix); j-- {
+			rules[j], rules[j-1] = rules[j-1], rules[j]
+		}
+	}
+	return rules, nil
+}
+
+// otpRuleFor returns the most specific rule matching phone, or nil.
+func (h *Handler) otpRuleFor(phone string) *otpRule {
+	rules := h.live.Load().otpRules
+	for i := range rules {
+		if strings.HasPrefix(phone, rules[i].Prefix) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// ttl returns the rule's TTL, or def when the rule does not set one.
+func (r *otpRule) ttl(def time.Duration) time.Duration {
+	if r == nil || r.TTLSeconds == 0 {
+		return def
+	}
+	return time.Duration(r.TTLSeconds) * time.Second
+}
+
+// generateCode returns a code of length characters drawn uniformly from
+// alphabet using crypto/rand.
+func generateCode(length int, alphabet string) (string, error) {
+	chars := []rune(alphabet)
+	max := big.NewInt(int64(len(chars)))
+	var b strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(chars[n.Int64()])
+	}
+	return b.String(), nil
+}
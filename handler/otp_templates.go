@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// codePlaceholder marks where the code goes in an OTP message template.
+	codePlaceholder = "{{code}}"
+	// defaultTemplateKey names the template used when a request picks none.
+	defaultTemplateKey = "default"
+	defaultOTPTemplate = "Siziň aktiwasiýa koduňyz " + codePlaceholder
+)
+
+// parseOTPTemplates parses the OTP_TEMPLATES JSON object of template key to
+// message, e.g. {"login":"Login code: {{code}}","signup":"Welcome! {{code}}"}.
+// A "default" template is always present: the object's own "default" entry,
+// else def (OTP_MESSAGE_TEMPLATE), else the built-in Turkmen text. Every
+// template must contain {{code}}.
+func parseOTPTemplates(raw, def string) (map[string]string, error) {
+	templates := map[string]string{defaultTemplateKey: defaultOTPTemplate}
+	if def != "" {
+		if !strings.Contains(def, codePlaceholder) {
+			return nil, fmt.Errorf("OTP_MESSAGE_TEMPLATE: template must contain %s", codePlaceholder)
+		}
+		templates[defaultTemplateKey] = def
+	}
+	if strings.TrimSpace(raw) == "" {
+		return templates, nil
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal([]byte(raw), &custom); err != nil {
+		return nil, fmt.Errorf("parse OTP_TEMPLATES: %w", err)
+	}
+	for key, tpl := range custom {
+		if key == "" {
+			return nil, fmt.Errorf("OTP_TEMPLATES: empty template key")
+		}
+		if !strings.Contains(tpl, codePlaceholder) {
+			return nil, fmt.Errorf("OTP_TEMPLATES[%q]: template must contain %s", key, codePlaceholder)
+		}
+		templates[key] = tpl
+	}
+	return templates, nil
+}
+
+// renderOTP fills code into tpl.
+func renderOTP(tpl, code string) string {
+	return strings.ReplaceAll(tpl, codePlaceholder, code)
+}
+
+// builtinOTPLangTemplates is the OTP wording shipped for each language.
+// A customised "default" template replaces the entry for the OTP default
+// language, so OTP_MESSAGE_TEMPLATE and OTP_TEMPLATES keep applying to
+// requests that name no language.
+var builtinOTPLangTemplates = map[string]string{
+	"tk": defaultOTPTemplate,
+	"ru": "Ваш код активации: " + codePlaceholder,
+	"en": "Your activation code: " + codePlaceholder,
+}
+
+// parseOTPLangTemplates builds the language → wording map for the "default"
+// template: the built-in wordings, with defaultLang's taken from def (the
+// resolved "default" template) when that is customised or defaultLang has
+// no built-in wording, overlaid with the OTP_LANG_TEMPLATES JSON
+// object, e.g. {"ru":"Код: {{code}}","de":"Ihr Code: {{code}}"}. Every
+// template must contain {{code}}.
+func parseOTPLangTemplates(raw, defaultLang, def string) (map[string]string, error) {
+	templates := make(map[string]string, len(builtinOTPLangTemplates)+1)
+	for lang, tpl := range builtinOTPLangTemplates {
+		templates[lang] = tpl
+	}
+	if _, ok := templates[defaultLang]; !ok || def != defaultOTPTemplate {
+		templates[defaultLang] = def
+	}
+	if strings.TrimSpace(raw) == "" {
+		return templates, nil
+	}
+
+	var custom map[string]string
+	if err := json.Unmarshal([]byte(raw), &custom); err != nil {
+		return nil, fmt.Errorf("parse OTP_LANG_TEMPLATES: %w", err)
+	}
+	for lang, tpl := range custom {
+		if lang = otpLang(lang); lang == "" {
+			return nil, fmt.Errorf("OTP_LANG_TEMPLATES: empty language")
+		}
+		if !strings.Contains(tpl, codePlaceholder) {
+			return nil, fmt.Errorf("OTP_LANG_TEMPLATES[%q]: template must contain %s", lang, codePlaceholder)
+		}
+		templates[lang] = tpl
+	}
+	return templates, nil
+}
+
+// otpLang reduces a language tag like "ru-RU" to its lower-case primary
+// subtag "ru".
+func otpLang(tag string) string {
+	tag, _, _ = strings.Cut(strings.TrimSpace(tag), "-")
+	tag, _, _ = strings.Cut(tag, "_")
+	return strings.ToLower(tag)
+}
+
+// otpTemplate picks the wording for an OTP request. A named template_key
+// wins and ignores lang. Otherwise the "default" template is used in lang,
+// or in the OTP default language when lang is missing or has no wording.
+// It returns the template, the language it is in ("" for a named
+// template) and false for an unknown template_key.
+func (s *settings) otpTemplate(key, lang string) (string, string, bool) {
+	if key != "" && key != defaultTemplateKey {
+		tpl, ok := s.otpTemplates[key]
+		return tpl, "", ok
+	}
+	if tpl, ok := s.otpLangTemplates[otpLang(lang)]; ok {
+		return tpl, otpLang(lang), true
+	}
+	return s.otpLangTemplates[s.cfg.OTPDefaultLang], s.cfg.OTPDefaultLang, true
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"log"
+
+	"sms_service/eventbus"
+	"sms_service/logging"
+	"sms_service/socketserver"
+)
+
+// redispatch hands a message a gateway reported "send_failed" for to another
+// idle gateway, never the one that failed it, up to SendFailedMaxRedispatch
+// times; the count travels in the event so every gateway sees it.
+// Tenant-bound messages go through DispatchExcept so they stay with the
+// tenant's gateways; the rest through EmitToAvailableExcept. A message with
+// no gateway left to take it is dropped and counted as a failed send.
+func (h *Handler) redispatch(f socketserver.FailedSend) {
+	ev, ok := f.Data.(socketserver.OTPEvent)
+	if !ok {
+		log.Printf("[REDISPATCH] Nothing to re-send | id=%s | tenant=%s | phone=%s", f.ClientID, f.Tenant, logging.Phone(f.Phone))
+		return
+	}
+	max := h.conf().SendFailedMaxRedispatch
+	if ev.Redispatch >= max {
+		h.stats.otpSendFailed.Add(1)
+		h.publish(eventbus.TypeFailed, eventbus.KindOTP, f.Tenant, ev.MessageID, ev.Phone)
+		log.Printf("[REDISPATCH] Giving up on message | id=%s | tenant=%s | phone=%s | redispatch=%d | max=%d",
+			f.ClientID, f.Tenant, logging.Phone(ev.Phone), ev.Redispatch, max)
+		return
+	}
+	ev.Redispatch++
+
+	var to string
+	var err error
+	if f.Tenant != "" {
+		var route socketserver.Route
+		route, err = h.send.DispatchExcept(f.Tenant, f.ClientID, f.Event, ev)
+		to = route.ClientID
+	} else {
+		to, err = h.send.EmitToAvailableExcept(f.ClientID, f.Event, ev)
+	}
+	if err != nil {
+		h.stats.otpSendFailed.Add(1)
+		h.publish(eventbus.TypeFailed, eventbus.KindOTP, f.Tenant, ev.MessageID, ev.Phone)
+		log.Printf("[REDISPATCH] No gateway to re-send to | failed_id=%s | tenant=%s | phone=%s | redispatch=%d | error=%v",
+			f.ClientID, f.Tenant, logging.Phone(ev.Phone), ev.Redispatch, err)
+		return
+	}
+	log.Printf("[REDISPATCH] Message re-sent | failed_id=%s | id=%s | tenant=%s | phone=%s | redispatch=%d",
+		f.ClientID, to, f.Tenant, logging.Phone(ev.Phone), ev.Redispatch)
+}
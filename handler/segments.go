@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+
+	"sms_service/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Per-segment capacity of an SMS. A message that fits one segment gets the
+// whole payload; a concatenated one loses a few characters per segment to
+// the header that ties the parts together.
+const (
+	gsm7Single    = 160
+	gsm7Multi     = 153
+	unicodeSingle = 70
+	unicodeMulti  = 67
+)
+
+// gsm7Basic is the GSM 03.38 default alphabet; each costs one septet.
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extended needs an escape septet in front, so each costs two.
+const gsm7Extended = "\f^{}\\[~]|€"
+
+// smsLength returns how many characters msg takes on the wire, how many
+// segments it is split into and whether it goes out as UCS-2. A message
+// entirely in the GSM-7 alphabet is counted in septets; anything else goes
+// out as UCS-2, counted in UTF-16 code units, so an emoji costs two.
+func smsLength(msg string) (chars, segments int, unicode bool) {
+	for _, r := range msg {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			chars++
+		case strings.ContainsRune(gsm7Extended, r):
+			chars += 2
+		default:
+			chars = len(utf16.Encode([]rune(msg)))
+			return chars, segmentsFor(chars, unicodeSingle, unicodeMulti), true
+		}
+	}
+	return chars, segmentsFor(chars, gsm7Single, gsm7Multi), false
+}
+
+// smsLimit turns MAX_SMS_LENGTH, given in GSM-7 characters, into the limit
+// for a message's encoding: as many characters as fit in the same number of
+// segments. The default 612 is four segments, so 612 GSM-7 or 268 UCS-2
+// characters. 0 stays 0 (no limit).
+func smsLimit(max int, unicode bool) int {
+	if max <= 0 || !unicode {
+		return max
+	}
+	if segments := segmentsFor(max, gsm7Single, gsm7Multi); segments > 1 {
+		return segments * unicodeMulti
+	}
+	return unicodeSingle
+}
+
+func segmentsFor(chars, single, multi int) int {
+	switch {
+	case chars == 0:
+		return 0
+	case chars <= single:
+		return 1
+	default:
+		return (chars + multi - 1) / multi
+	}
+}
+
+// checkLength measures message against MAX_SMS_LENGTH (see smsLimit),
+// answering 400 itself when it is too long. It returns the segment count
+// for the response.
+func (h *Handler) checkLength(c *gin.Context, tag, message string) (int, bool) {
+	chars, segments, unicode := smsLength(message)
+	if max := smsLimit(h.conf().MaxSMSLength, unicode); max > 0 && chars > max {
+		log.Printf("[%s] Message too long | ip=%s | chars=%d | max=%d | segments=%d", tag, c.ClientIP(), chars, max, segments)
+		h.reply(c, http.StatusBadRequest, i18n.MessageTooLong, gin.H{
+			"success":  false,
+			"length":   chars,
+			"max":      max,
+			"segments": segments,
+		})
+		return segments, false
+	}
+	return segments, true
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"sms_service/httpgateway"
+	"sms_service/logging"
+	"sms_service/socketserver"
+)
+
+// Channels a message can leave by, reported as "channel" in responses.
+const (
+	channelSocket = "socket"
+	channelHTTP   = "http"
+)
+
+// delivery says which channel took a message and, for the socket channel,
+// how many gateways and which one.
+type delivery struct {
+	channel string
+	reached int
+	route   socketserver.Route
+}
+
+// sender delivers one message over one channel. ErrNoClients means the
+// channel had nobody to hand it to, so the next channel may be tried.
+type sender interface {
+	send(ctx context.Context, tenant string, event socketserver.OTPEvent) (delivery, error)
+}
+
+// socketSender emits to the tenant's Socket.IO gateways (see emit).
+type socketSender struct{ h *Handler }
+
+func (s socketSender) send(_ context.Context, tenant string, event socketserver.OTPEvent) (delivery, error) {
+	reached, route, err := s.h.emit(tenant, event)
+	return delivery{channel: channelSocket, reached: reached, route: route}, err
+}
+
+// httpGatewaySender posts to the configured HTTP SMS gateway.
+type httpGatewaySender struct{ client *httpgateway.Client }
+
+func (s httpGatewaySender) send(ctx context.Context, tenant string, event socketserver.OTPEvent) (delivery, error) {
+	err := s.client.Send(ctx, httpgateway.Message{Phone: event.Phone, Message: event.Pass, MessageID: event.MessageID})
+	if err != nil {
+		log.Printf("[GATEWAY] HTTP gateway send failed | tenant=%s | phone=%s | message_id=%s | error=%v",
+			tenant, logging.Phone(event.Phone), event.MessageID, err)
+		return delivery{channel: channelHTTP}, err
+	}
+	log.Printf("[GATEWAY] Sent via HTTP gateway | tenant=%s | phone=%s | message_id=%s", tenant, logging.Phone(event.Phone), event.MessageID)
+	return delivery{channel: channelHTTP, reached: 1}, nil
+}
+
+// multiSender tries each sender in turn, moving on only when one had no
+// one to hand the message to. The errors of every channel tried are
+// joined, so errors.Is still finds ErrNoClients when all of them failed.
+type multiSender []sender
+
+func (m multiSender) send(ctx context.Context, tenant string, event socketserver.OTPEvent) (delivery, error) {
+	var (
+		d    delivery
+		errs []error
+	)
+	for _, s := range m {
+		var err error
+		d, err = s.send(ctx, tenant, event)
+		if err == nil {
+			return d, nil
+		}
+		errs = append(errs, err)
+		if !errors.Is(err, socketserver.ErrNoClients) {
+			break
+		}
+	}
+	return d, errors.Join(errs...)
+}
+
+// otpSender returns the channels OTPs go out by: the socket gateways, then
+// the HTTP gateway when SMS_GATEWAY_URL is set.
+func (h *Handler) otpSender() sender {
+	if gw := h.live.Load().gateway; gw != nil {
+		return multiSender{socketSender{h}, httpGatewaySender{gw}}
+	}
+	return socketSender{h}
+}
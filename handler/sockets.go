@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"sms_service/i18n"
+	"sms_service/middleware"
+	"sms_service/socketserver"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sockets handles GET /sockets.
+// Returns aggregate client stats together with a per-client snapshot, both
+// limited to the caller's tenant.
+func (h *Handler) Sockets(c *gin.Context) {
+	tenant := c.GetString(middleware.TenantKey)
+	c.JSON(http.StatusOK, gin.H{
+		"stats":   h.socket.TenantStats(tenant),
+		"clients": h.socket.TenantClients(tenant),
+	})
+}
+
+// AdminClients handles GET /admin/clients.
+// Lists the caller's connected clients with their remote address and
+// connection time, unmasked, for debugging who is connected.
+func (h *Handler) AdminClients(c *gin.Context) {
+	c.JSON(http.StatusOK, h.socket.ListClients(c.GetString(middleware.TenantKey)))
+}
+
+// SocketSessions handles GET /sockets/internal.
+// Reports the engine.io session pool next to the client map, to spot
+// sessions that outlive their connections.
+func (h *Handler) SocketSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, h.socket.SessionStats())
+}
+
+// SweepSocketSessions handles POST /sockets/internal/sweep.
+// Reclaims engine.io sessions with no live connection immediately instead
+// of waiting for the next reconcile tick.
+func (h *Handler) SweepSocketSessions(c *gin.Context) {
+	removed := h.socket.SweepSessions()
+	log.Printf("[SOCKETS] Manual session sweep | ip=%s | reclaimed=%d", c.ClientIP(), removed)
+	c.JSON(http.StatusOK, gin.H{"success": true, "reclaimed": removed, "sessions": h.socket.SessionStats()})
+}
+
+// PhoneDeliveries handles GET /sockets/deliveries/:phone.
+// Reports how often gateways confirmed with "sended" that they sent to this
+// phone, and how often their confirmation named a different number.
+func (h *Handler) PhoneDeliveries(c *gin.Context) {
+	phone := c.Param("phone")
+	d, found := h.socket.Delivery(phone)
+	c.JSON(http.StatusOK, gin.H{"phone": phone, "found": found, "delivery": d})
+}
+
+// DrainSocket handles POST /sockets/:id/drain.
+// The client stops receiving new work but finishes what it already has.
+func (h *Handler) DrainSocket(c *gin.Context) {
+	h.setDraining(c, true)
+}
+
+// UndrainSocket handles POST /sockets/:id/undrain.
+// Returns a previously drained client to the dispatch pool.
+func (h *Handler) UndrainSocket(c *gin.Context) {
+	h.setDraining(c, false)
+}
+
+func (h *Handler) setDraining(c *gin.Context, draining bool) {
+	ip := c.ClientIP()
+	id := c.Param("id")
+
+	if err := h.socket.SetDraining(c.GetString(middleware.TenantKey), id, draining); err != nil {
+		if errors.Is(err, socketserver.ErrClientNotFound) {
+			log.Printf("[SOCKETS] Drain target not connected | ip=%s | id=%s | draining=%t", ip, id, draining)
+			h.reply(c, http.StatusNotFound, i18n.SocketNotFound, gin.H{"success": false})
+			return
+		}
+		log.Printf("[SOCKETS] Failed to change drain state | ip=%s | id=%s | error=%v", ip, id, err)
+		h.reply(c, http.StatusInternalServerError, i18n.InternalError, nil)
+		return
+	}
+
+	log.Printf("[SOCKETS] Drain state updated | ip=%s | id=%s | draining=%t", ip, id, draining)
+	c.JSON(http.StatusOK, gin.H{"success": true, "id": id, "draining": draining})
+}
+
+// reservedEvents are Socket.IO's own event names, which gateways would
+// mistake for protocol events.
+var reservedEvents = map[string]bool{"connect": true, "connect_error": true, "disconnect": true, "disconnecting": true, "error": true}
+
+// AdminEmit handles POST /admin/emit, served only with ENABLE_ADMIN_EMIT.
+// Broadcasts {"event": "...", "data": ...} unchanged to the caller's
+// connected clients, so QA can exercise the socket fan-out without going
+// through the phone-validated send endpoints. data is required: a null
+// payload makes the socket library panic on every client, which would
+// drop them all.
+func (h *Handler) AdminEmit(c *gin.Context) {
+	ip := c.ClientIP()
+	var body struct {
+		Event string      `json:"event"`
+		Data  interface{} `json:"data"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Event == "" || reservedEvents[body.Event] || body.Data == nil {
+		log.Printf("[ADMIN_EMIT] Invalid request | ip=%s | event=%q | error=%v", ip, body.Event, err)
+		h.reply(c, http.StatusBadRequest, i18n.BadRequest, gin.H{"success": false})
+		return
+	}
+
+	reached, err := h.send.EmitToTenant(c.GetString(middleware.TenantKey), body.Event, body.Data)
+	switch {
+	case errors.Is(err, socketserver.ErrFanoutExceeded):
+		log.Printf("[ADMIN_EMIT] Broadcast refused | ip=%s | event=%s | error=%v", ip, body.Event, err)
+		h.reply(c, http.StatusServiceUnavailable, i18n.BroadcastRefused, gin.H{"success": false})
+		return
+	case err != nil:
+		log.Printf("[ADMIN_EMIT] Emit failed | ip=%s | event=%s | error=%v", ip, body.Event, err)
+		h.reply(c, http.StatusInternalServerError, i18n.InternalError, nil)
+		return
+	case reached == 0:
+		log.Printf("[ADMIN_EMIT] No clients connected | ip=%s | event=%s", ip, body.Event)
+		h.reply(c, http.StatusServiceUnavailable, i18n.NoGateway, gin.H{"success": false, "reached": 0})
+		return
+	}
+
+	log.Printf("[ADMIN_EMIT] Event emitted | ip=%s | event=%s | reached=%d", ip, body.Event, reached)
+	c.JSON(http.StatusOK, gin.H{"success": true, "event": body.Event, "reached": reached})
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"sms_service/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// counters are process-wide totals since start. They are atomics so the
+// request paths that bump them never contend on a lock.
+type counters struct {
+	// otpIssued counts codes stored by /otp and /otp/create alike.
+	otpIssued       atomic.Int64
+	otpSent         atomic.Int64
+	otpCreated      atomic.Int64
+	otpSendFailed   atomic.Int64
+	otpVerified     atomic.Int64
+	otpVerifyFailed atomic.Int64
+	smsEmitted      atomic.Int64
+	inFlight        atomic.Int64
+}
+
+// TrackInFlight counts API requests currently being served.
+func (h *Handler) TrackInFlight() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.stats.inFlight.Add(1)
+		defer h.stats.inFlight.Add(-1)
+		c.Next()
+	}
+}
+
+// registerMetrics exposes the counters Prometheus scrapes.
+func (h *Handler) registerMetrics() {
+	h.registry.CounterFunc("sms_otp_issued_total", "OTP codes generated and stored.", h.stats.otpIssued.Load)
+	h.registry.CounterFunc("sms_otp_verified_total", "OTP codes verified with /compare.", h.stats.otpVerified.Load)
+	h.registry.CounterFunc("sms_otp_failed_total", "OTP verifications that failed: wrong, expired or locked out.", h.stats.otpVerifyFailed.Load)
+}
+
+// PrometheusMetrics handles GET /metrics/prometheus.
+// Same process counters as /metrics, in the Prometheus text format.
+func (h *Handler) PrometheusMetrics(c *gin.Context) {
+	c.Header("Content-Type", metrics.ContentType)
+	c.Status(http.StatusOK)
+	if err := h.registry.WriteText(c.Writer); err != nil {
+		log.Printf("[METRICS] Failed to write metrics | ip=%s | error=%v", c.ClientIP(), err)
+	}
+}
+
+// Metrics handles GET /metrics.
+// A small liveness-plus-load signal for ops, cheaper than /stats: the
+// connected gateway count, uptime and the number of OTPs issued.
+func (h *Handler) Metrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"connected_clients": h.socket.ClientCount(),
+		"uptime_seconds":    int64(time.Since(h.startedAt).Seconds()),
+		"otp_issued":        h.stats.otpIssued.Load(),
+	})
+}
+
+// Stats handles GET /stats.
+// Returns a JSON snapshot of the service counters for dashboards that cannot
+// scrape a metrics endpoint. Every value is read atomically; nothing here
+// takes the socket manager lock.
+func (h *Handler) Stats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"uptime_seconds":    int64(time.Since(h.startedAt).Seconds()),
+		"otp_issued":        h.stats.otpIssued.Load(),
+		"otp_sent":          h.stats.otpSent.Load(),
+		"otp_created":       h.stats.otpCreated.Load(),
+		"otp_send_failed":   h.stats.otpSendFailed.Load(),
+		"otp_verified":      h.stats.otpVerified.Load(),
+		"otp_verify_failed": h.stats.otpVerifyFailed.Load(),
+		"sms_emitted":       h.stats.smsEmitted.Load(),
+		"in_flight":         h.stats.inFlight.Load(),
+		"clients":           h.socket.Gauges(),
+		"event_bus":         h.bus.Stats(),
+	})
+}
@@ -0,0 +1,105 @@
+// Package httpgateway sends SMS through an HTTP SMS gateway, the fallback
+// channel for when no Socket.IO gateway is connected.
+//
+// Each message is one request carrying a JSON body with the phone, text
+// and message ID. Any 2xx answer counts as accepted. Redirects are not
+// followed.
+package httpgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrTimeout is returned when the gateway does not answer in time. The
+// message may still have been sent.
+var ErrTimeout = errors.New("sms gateway timed out")
+
+// Message is the JSON body sent to the gateway.
+type Message struct {
+	Phone     string `json:"phone"`
+	Message   string `json:"message"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Client talks to one HTTP SMS gateway.
+type Client struct {
+	url     string
+	method  string
+	header  string
+	value   string
+	timeout time.Duration
+	http    *http.Client
+}
+
+// New returns a Client sending to url with method (POST when empty), each
+// request bounded by timeout. auth is an optional "Header: value" added to
+// every request, e.g. "Authorization: Bearer <token>".
+func New(url, method, auth string, timeout time.Duration) (*Client, error) {
+	if method == "" {
+		method = http.MethodPost
+	}
+	c := &Client{
+		url:     url,
+		method:  strings.ToUpper(method),
+		timeout: timeout,
+		http: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	if auth != "" {
+		name, value, ok := strings.Cut(auth, ":")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, errors.New(`auth header must be "Header: value"`)
+		}
+		c.header, c.value = name, strings.TrimSpace(value)
+	}
+	if _, err := http.NewRequest(c.method, url, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Send hands msg to the gateway and returns once it has answered.
+func (c *Client) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, c.method, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.header != "" {
+		req.Header.Set(c.header, c.value)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrTimeout
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sms gateway answered %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package i18n translates the user-facing messages returned by the REST API.
+//
+// Every message is identified by a stable, machine-readable code that is
+// returned alongside the translated text, so clients can branch on the code
+// while showing the message to the user as-is.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message codes. These are part of the API contract and must not change
+// between languages or releases.
+const (
+	BadRequest           = "bad_request"
+	InvalidPhone         = "invalid_phone"
+	OTPAlreadySent       = "otp_already_sent"
+	OTPExpired           = "otp_expired"
+	InvalidOTP           = "invalid_otp"
+	OTPGenerateFailed    = "otp_generate_failed"
+	GroupSMSSent         = "group_sms_sent"
+	MessageSent          = "message_sent"
+	SocketNotFound       = "socket_not_found"
+	NoGateway            = "no_gateway"
+	DeliveryFailed       = "delivery_failed"
+	DeliveryPending      = "delivery_pending"
+	OTPUnconfirmed       = "otp_unconfirmed"
+	MessageHeld          = "message_held"
+	BatchTooLarge        = "batch_too_large"
+	MessageTooLong       = "message_too_long"
+	DryRun               = "dry_run"
+	AtCapacity           = "at_capacity"
+	ServiceUnavailable   = "service_unavailable"
+	InternalError        = "internal_error"
+	DuplicateSuppressed  = "duplicate_suppressed"
+	UnknownTemplate      = "unknown_template"
+	OTPLocked            = "otp_locked"
+	OTPAttemptsExhausted = "otp_attempts_exhausted"
+	GroupSMSCooldown     = "group_sms_cooldown"
+	InvalidCallbackURL   = "invalid_callback_url"
+	BroadcastRefused     = "broadcast_refused"
+	CodeReturnDisabled   = "code_return_disabled"
+)
+
+// builtin holds the translations shipped with the binary. English strings
+// match the responses the service has always returned.
+var builtin = map[string]map[string]string{
+	"en": {
+		BadRequest:           "Bad request",
+		InvalidPhone:         "Bad request: Invalid phone number",
+		OTPAlreadySent:       "OTP already sent. Please wait.",
+		OTPExpired:           "OTP expired",
+		InvalidOTP:           "Invalid OTP",
+		OTPGenerateFailed:    "Failed to generate OTP",
+		GroupSMSSent:         "Group SMS sent successfully",
+		MessageSent:          "Message sent",
+		SocketNotFound:       "Socket not found",
+		NoGateway:            "No SMS gateway connected",
+		DeliveryFailed:       "Message delivery failed",
+		DeliveryPending:      "Message accepted, delivery not yet confirmed",
+		OTPUnconfirmed:       "No gateway confirmed sending the code, please request a new one",
+		MessageHeld:          "No SMS gateway connected, message queued for delivery",
+		BatchTooLarge:        "Bad request: too many messages in one batch",
+		MessageTooLong:       "Bad request: message is too long",
+		DryRun:               "Validation passed, message not sent (dry run)",
+		AtCapacity:           "System at capacity, please try again later",
+		ServiceUnavailable:   "Service temporarily unavailable, please try again later",
+		InternalError:        "Internal server error",
+		DuplicateSuppressed:  "Duplicate message suppressed",
+		UnknownTemplate:      "Bad request: Unknown template key",
+		OTPLocked:            "Too many attempts. Please try again later.",
+		OTPAttemptsExhausted: "Too many attempts, request a new code",
+		GroupSMSCooldown:     "Group SMS sent too recently. Please try again later.",
+		InvalidCallbackURL:   "Bad request: callback_url not allowed",
+		BroadcastRefused:     "Broadcast refused: too many gateways connected",
+		CodeReturnDisabled:   "Returning OTP codes is not enabled for this caller",
+	},
+	"tk": {
+		BadRequest:           "Nädogry haýyş",
+		InvalidPhone:         "Nädogry haýyş: telefon belgisi nädogry",
+		OTPAlreadySent:       "Kod eýýäm iberildi. Garaşmagyňyzy haýyş edýäris.",
+		OTPExpired:           "Kodyň möhleti geçdi",
+		InvalidOTP:           "Nädogry kod",
+		OTPGenerateFailed:    "Kod döredip bolmady",
+		GroupSMSSent:         "Toparlaýyn SMS üstünlikli iberildi",
+		MessageSent:          "Habar iberildi",
+		SocketNotFound:       "Birikme tapylmady",
+		NoGateway:            "SMS derwezesi birikmedik",
+		DeliveryFailed:       "Habary ibermek başartmady",
+		DeliveryPending:      "Habar kabul edildi, iberilişi entek tassyklanmady",
+		OTPUnconfirmed:       "Kodyň iberilişini hiç bir derweze tassyklamady, täze kod soraň",
+		MessageHeld:          "SMS derwezesi birikmedik, habar iberilmek üçin nobata goýuldy",
+		BatchTooLarge:        "Nädogry haýyş: bir toparda habarlar gaty köp",
+		MessageTooLong:       "Nädogry haýyş: habar gaty uzyn",
+		DryRun:               "Barlag üstünlikli, habar iberilmedi (synag)",
+		AtCapacity:           "Ulgam doly ýüklenen, biraz soňra synanyşyň",
+		ServiceUnavailable:   "Hyzmat wagtlaýyn elýeterli däl, biraz soňra synanyşyň",
+		InternalError:        "Serwerde içki ýalňyşlyk",
+		DuplicateSuppressed:  "Gaýtalanýan habar iberilmedi",
+		UnknownTemplate:      "Nädogry haýyş: näbelli şablon açary",
+		OTPLocked:            "Synanyşyklar gaty köp. Biraz soňra gaýtadan synanyşyň.",
+		OTPAttemptsExhausted: "Synanyşyklar gaty köp, täze kod soraň",
+		GroupSMSCooldown:     "Toparlaýyn SMS ýaňy iberildi. Biraz soňra gaýtadan synanyşyň.",
+		InvalidCallbackURL:   "Nädogry haýyş: callback_url rugsat berilmedik",
+		BroadcastRefused:     "Ýaýratma ret edildi: birikdirilen derwezeler gaty köp",
+		CodeReturnDisabled:   "Bu ulanyjy üçin kody gaýtarmak açylmadyk",
+	},
+	"ru": {
+		BadRequest:           "Неверный запрос",
+		InvalidPhone:         "Неверный запрос: неверный номер телефона",
+		OTPAlreadySent:       "Код уже отправлен. Пожалуйста, подождите.",
+		OTPExpired:           "Срок действия кода истёк",
+		InvalidOTP:           "Неверный код",
+		OTPGenerateFailed:    "Не удалось сгенерировать код",
+		GroupSMSSent:         "Групповое SMS успешно отправлено",
+		MessageSent:          "Сообщение отправлено",
+		SocketNotFound:       "Соединение не найдено",
+		NoGateway:            "Нет подключённого SMS-шлюза",
+		DeliveryFailed:       "Не удалось доставить сообщение",
+		DeliveryPending:      "Сообщение принято, доставка ещё не подтверждена",
+		OTPUnconfirmed:       "Ни один шлюз не подтвердил отправку кода, запросите новый",
+		MessageHeld:          "Нет подключённого SMS-шлюза, сообщение поставлено в очередь",
+		BatchTooLarge:        "Неверный запрос: слишком много сообщений в одном пакете",
+		MessageTooLong:       "Неверный запрос: сообщение слишком длинное",
+		DryRun:               "Проверка пройдена, сообщение не отправлено (пробный запуск)",
+		AtCapacity:           "Система перегружена, повторите попытку позже",
+		ServiceUnavailable:   "Сервис временно недоступен, повторите попытку позже",
+		InternalError:        "Внутренняя ошибка сервера",
+		DuplicateSuppressed:  "Повторное сообщение не отправлено",
+		UnknownTemplate:      "Неверный запрос: неизвестный ключ шаблона",
+		OTPLocked:            "Слишком много попыток. Повторите попытку позже.",
+		OTPAttemptsExhausted: "Слишком много попыток, запросите новый код",
+		GroupSMSCooldown:     "Групповое SMS отправлялось недавно. Повторите попытку позже.",
+		InvalidCallbackURL:   "Неверный запрос: callback_url не разрешён",
+		BroadcastRefused:     "Рассылка отклонена: подключено слишком много шлюзов",
+		CodeReturnDisabled:   "Возврат кода не разрешён для этого клиента",
+	},
+}
+
+// Catalog resolves message codes to translated text.
+type Catalog struct {
+	fallback string
+	messages map[string]map[string]string
+}
+
+// Load builds a Catalog from the built-in translations, overlaid with the
+// JSON file at path (if non-empty). The file maps language → code → text,
+// e.g. {"ru": {"otp_expired": "..."}}. fallback is the language used when
+// the caller's preference is missing or unsupported.
+func Load(path, fallback string) (*Catalog, error) {
+	messages := make(map[string]map[string]string, len(builtin))
+	for lang, msgs := range builtin {
+		messages[lang] = make(map[string]string, len(msgs))
+		for code, text := range msgs {
+			messages[lang][code] = text
+		}
+	}
+
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read messages file: %w", err)
+		}
+		var overrides map[string]map[string]string
+		if err := json.Unmarshal(raw, &overrides); err != nil {
+			return nil, fmt.Errorf("parse messages file %s: %w", path, err)
+		}
+		for lang, msgs := range overrides {
+			lang = strings.ToLower(lang)
+			if messages[lang] == nil {
+				messages[lang] = make(map[string]string, len(msgs))
+			}
+			for code, text := range msgs {
+				messages[lang][code] = text
+			}
+		}
+	}
+
+	fallback = strings.ToLower(fallback)
+	if _, ok := messages[fallback]; !ok {
+		return nil, fmt.Errorf("default language %q has no translations", fallback)
+	}
+	return &Catalog{fallback: fallback, messages: messages}, nil
+}
+
+// Translate returns the text for code in lang, falling back to the default
+// language, then English, then the code itself.
+func (c *Catalog) Translate(lang, code string) string {
+	for _, l := range []string{lang, c.fallback, "en"} {
+		if text, ok := c.messages[l][code]; ok {
+			return text
+		}
+	}
+	return code
+}
+
+// Match picks the best supported language for the given explicit choice
+// (e.g. a "lang" query parameter) or Accept-Language header value.
+// An explicit choice wins when it is supported.
+func (c *Catalog) Match(explicit, acceptLanguage string) string {
+	if l := primaryTag(explicit); l != "" {
+		if _, ok := c.messages[l]; ok {
+			return l
+		}
+	}
+
+	type pref struct {
+		lang string
+		q    float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if l := primaryTag(tag); l != "" && q > 0 {
+			prefs = append(prefs, pref{lang: l, q: q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if _, ok := c.messages[p.lang]; ok {
+			return p.lang
+		}
+	}
+	return c.fallback
+}
+
+// primaryTag reduces a language tag like "ru-RU" to its primary subtag "ru".
+func primaryTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "*" {
+		return ""
+	}
+	return tag
+}
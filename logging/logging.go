@@ -0,0 +1,151 @@
+// Package logging switches the standard logger between the service's
+// human-readable lines and one JSON object per line for log aggregators.
+//
+// Call sites keep using log.Printf in the "[TAG] Message | key=value | ..."
+// style; in JSON mode a writer installed on the standard logger parses each
+// line into level, tag, msg, caller and one field per key=value pair, so
+// nothing has to be rewritten to get structured output.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by Setup.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Log levels, derived from the line: a [PANIC] tag is an error, an [ALERT]
+// tag or an error field a warning, anything else info.
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// textFlags is the standard logger's prefix in text mode: date, time and
+// file:line, in UTC so lines from different hosts line up.
+const textFlags = log.LstdFlags | log.Lshortfile | log.LUTC
+
+// Setup configures the standard logger for format ("" means text).
+func Setup(format string) error {
+	switch format {
+	case "", FormatText:
+		log.SetFlags(textFlags)
+		log.SetOutput(os.Stderr)
+	case FormatJSON:
+		// The JSON writer stamps the time itself; only file:line is kept
+		// from the logger's own prefix.
+		log.SetFlags(log.Lshortfile)
+		log.SetOutput(NewJSONWriter(os.Stderr))
+	default:
+		return fmt.Errorf("unknown log format %q (want %q or %q)", format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// JSONWriter turns the standard logger's lines into JSON objects. The log
+// package calls Write once per entry, so each call is one line.
+type JSONWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewJSONWriter returns a JSONWriter writing to out.
+func NewJSONWriter(out io.Writer) *JSONWriter {
+	return &JSONWriter{out: out}
+}
+
+// Write parses one log entry and writes it as a JSON line. It always
+// reports len(p) so a malformed line never makes the logger give up.
+func (w *JSONWriter) Write(p []byte) (int, error) {
+	b, err := json.Marshal(parseLine(string(p)))
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{"level": LevelError, "msg": string(bytes.TrimSpace(p))})
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(append(b, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseLine splits "file.go:12: [TAG][SUB] Message | k=v | k=v" into
+// fields. Text after the first newline (stack traces) goes to "detail".
+func parseLine(line string) map[string]interface{} {
+	line = strings.TrimSuffix(line, "\n")
+	entry := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": LevelInfo,
+	}
+
+	// Lshortfile prefix: "file.go:12: ".
+	if i := strings.Index(line, ": "); i > 0 && !strings.ContainsAny(line[:i], " [|") && strings.Contains(line[:i], ".go:") {
+		entry["caller"] = line[:i]
+		line = line[i+2:]
+	}
+
+	var tags []string
+	for strings.HasPrefix(line, "[") {
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			break
+		}
+		tags = append(tags, line[1:end])
+		line = line[end+1:]
+	}
+	line = strings.TrimLeft(line, " ")
+	for i, tag := range tags {
+		switch tag {
+		case "PANIC":
+			entry["level"] = LevelError
+		case "ALERT":
+			if entry["level"] != LevelError {
+				entry["level"] = LevelWarn
+			}
+		}
+		if i == 0 {
+			entry["tag"] = tag
+		}
+	}
+
+	head, tail := line, ""
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		head, tail = line[:i], line[i:]
+	}
+	parts := strings.Split(head, " | ")
+	entry["msg"] = strings.TrimSpace(parts[0])
+	if tail != "" {
+		entry["detail"] = strings.TrimPrefix(tail, "\n")
+	}
+	for _, kv := range parts[1:] {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" || strings.Contains(k, " ") {
+			entry["msg"] = fmt.Sprintf("%s | %s", entry["msg"], kv)
+			continue
+		}
+		if _, taken := entry[k]; taken {
+			k = "field_" + k
+		}
+		if u, err := strconv.Unquote(v); err == nil {
+			v = u
+		}
+		entry[k] = v
+		if k == "error" && entry["level"] == LevelInfo {
+			entry["level"] = LevelWarn
+		}
+	}
+	return entry
+}
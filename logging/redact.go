@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"sms_service/phone"
+)
+
+// sensitive disables redaction (LOG_SENSITIVE=true), for local debugging
+// only.
+var sensitive atomic.Bool
+
+// SetSensitive turns logging of full phone numbers and message bodies on or
+// off.
+func SetSensitive(on bool) {
+	sensitive.Store(on)
+}
+
+// Sensitive reports whether full phone numbers and message bodies may be
+// logged.
+func Sensitive() bool {
+	return sensitive.Load()
+}
+
+// Phone masks all but the last two digits and the leading operator prefix
+// of a phone number, e.g. "+99361****78" or "61****78".
+func Phone(p string) string {
+	if sensitive.Load() || len(p) < 6 {
+		return p
+	}
+	return p[:len(p)-6] + "****" + p[len(p)-2:]
+}
+
+// Secret hides a value that must never be logged, such as an OTP code or
+// the message text that carries it, keeping only its length.
+func Secret(s string) string {
+	if sensitive.Load() {
+		return s
+	}
+	return fmt.Sprintf("[redacted %d chars]", len([]rune(s)))
+}
+
+// secretKeys are payload fields whose values are hidden entirely.
+var secretKeys = map[string]bool{"pass": true, "code": true, "otp": true, "message": true}
+
+// Payload renders an event payload for a log line with phone numbers
+// masked and message text hidden. Values with a String method render
+// themselves and are expected to redact.
+func Payload(v interface{}) string {
+	if sensitive.Load() {
+		return fmt.Sprintf("%v", v)
+	}
+	switch p := v.(type) {
+	case fmt.Stringer:
+		return p.String()
+	case map[string]interface{}:
+		parts := make([]string, 0, len(p))
+		keys := make([]string, 0, len(p))
+		for k := range p {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			val := fmt.Sprintf("%v", p[k])
+			if secretKeys[strings.ToLower(k)] {
+				val = Secret(val)
+			}
+			parts = append(parts, k+":"+maskPhones(val))
+		}
+		return "map[" + strings.Join(parts, " ") + "]"
+	}
+	return maskPhones(fmt.Sprintf("%v", v))
+}
+
+// maskPhones masks every phone number found in s.
+func maskPhones(s string) string {
+	return phone.InText().ReplaceAllStringFunc(s, Phone)
+}
@@ -1,125 +1,389 @@
-package main
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"runtime/debug"
-	"syscall"
-	"time"
-
-	"sms_service/config"
-	"sms_service/handler"
-	"sms_service/middleware"
-	"sms_service/redisclient"
-	"sms_service/socketserver"
-
-	"github.com/gin-gonic/gin"
-)
-
-func main() {
-	// Include date+time+file:line in every log line so crashes are easy to locate.
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	// Catch any panic that bubbles up to the main goroutine itself.
-	// go-socket.io v1.7.0 internal goroutine panics will NOT be caught here
-	// (each goroutine needs its own recover), but this is a last-resort safety net.
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("[PANIC] main() goroutine panic – stack:\n%v\n%s", r, debug.Stack())
-			os.Exit(1)
-		}
-	}()
-
-	log.Printf("[STARTUP] Loading configuration...")
-	cfg := config.Load()
-	log.Printf("[STARTUP] Config loaded | port=%s | redis=%s:%s",
-		cfg.Port, cfg.RedisHost, cfg.RedisPort)
-
-	rdb := redisclient.NewClient(cfg)
-
-	log.Printf("[STARTUP] Initializing Socket.IO manager...")
-	sm := socketserver.NewManager()
-	h := handler.New(rdb, sm)
-
-	// Start the Socket.IO serve loop.
-	// recover() here catches panics inside the Serve() loop itself.
-	// Panics in go-socket.io's per-connection goroutines are separate and will
-	// still crash the process — that is a known bug in go-socket.io v1.7.0.
-	// Docker's --restart unless-stopped handles the crash+restart automatically.
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("[SOCKET][PANIC] Serve() goroutine panicked | panic=%v\nstack:\n%s",
-					r, debug.Stack())
-			}
-		}()
-		log.Printf("[STARTUP] Socket.IO serve loop starting...")
-		if err := sm.Server.Serve(); err != nil {
-			log.Printf("[SOCKET] Serve() returned error | error=%v", err)
-		}
-	}()
-	defer sm.Server.Close()
-
-	gin.SetMode(gin.ReleaseMode)
-
-	router := gin.New()
-	router.Use(gin.Logger())
-	// gin.Recovery already catches panics in HTTP handler goroutines and logs them.
-	router.Use(gin.Recovery())
-
-	router.Use(middleware.SecurityHeaders())
-	router.Use(middleware.CORS())
-
-	// Health check — first thing to call when debugging ECONNRESET.
-	// If this returns 200 the server is alive. If it times out, the server crashed.
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
-
-	// Socket.IO — both polling and WebSocket upgrade.
-	router.GET("/socket.io/*any", gin.WrapH(sm.Server))
-	router.POST("/socket.io/*any", gin.WrapH(sm.Server))
-
-	// REST API routes.
-	router.POST("/otp", h.OTP)
-	router.POST("/compare", h.Compare)
-	router.POST("/group_sms", h.GroupSMS)
-	router.POST("/send-sms", h.SendSMS)
-
-	addr := fmt.Sprintf("0.0.0.0:%s", cfg.Port)
-
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: router,
-		// Only timeout the header read to guard against Slowloris attacks.
-		// ReadTimeout / WriteTimeout would kill long-lived WebSocket connections.
-		ReadHeaderTimeout: 10 * time.Second,
-		IdleTimeout:       120 * time.Second,
-	}
-
-	go func() {
-		log.Printf("[STARTUP] HTTP server listening | addr=%s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("[STARTUP] Server failed | addr=%s | error=%v", addr, err)
-		}
-	}()
-
-	// Block until SIGINT or SIGTERM (Ctrl-C / docker stop).
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-quit
-	log.Printf("[SHUTDOWN] Signal received: %s – shutting down gracefully...", sig)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("[SHUTDOWN] Forced shutdown | error=%v", err)
-	} else {
-		log.Printf("[SHUTDOWN] Server stopped cleanly")
-	}
-}
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"sms_service/config"
+	"sms_service/eventbus"
+	"sms_service/handler"
+	"sms_service/i18n"
+	"sms_service/logging"
+	"sms_service/middleware"
+	"sms_service/otpstore"
+	"sms_service/phone"
+	"sms_service/redisclient"
+	"sms_service/socketserver"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	// Include date+time+file:line in every log line so crashes are easy to locate.
+	// Times are UTC so log lines line up across hosts and DST changes.
+	// LOG_FORMAT=json switches to one JSON object per line; it is applied
+	// again once the config (and any .env file) is loaded.
+	if err := logging.Setup(os.Getenv("LOG_FORMAT")); err != nil {
+		_ = logging.Setup(logging.FormatText)
+	}
+
+	// Catch any panic that bubbles up to the main goroutine itself.
+	// go-socket.io v1.7.0 internal goroutine panics will NOT be caught here
+	// (each goroutine needs its own recover), but this is a last-resort safety net.
+	// crashHook, once set, records state for the post-mortem before exiting.
+	// Panics recovered further down (HTTP handlers, Socket.IO callbacks and
+	// the serve loop) run it too, without exiting.
+	var crashHook func(reason interface{})
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[PANIC] main() goroutine panic – stack:\n%v\n%s", r, debug.Stack())
+			if crashHook != nil {
+				crashHook(r)
+			}
+			os.Exit(1)
+		}
+	}()
+
+	log.Printf("[STARTUP] Loading configuration...")
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("[STARTUP] Invalid configuration | error=%v", err)
+	}
+	_ = logging.Setup(cfg.LogFormat)
+	logging.SetSensitive(cfg.LogSensitive)
+	if err := phone.Setup(cfg.CountryCode, cfg.PhonePattern); err != nil {
+		log.Fatalf("[STARTUP] Invalid phone numbering plan | country_code=%s | pattern=%q | error=%v", cfg.CountryCode, cfg.PhonePattern, err)
+	}
+	log.Printf("[STARTUP] Config loaded | port=%s | redis=%s:%s",
+		cfg.Port, cfg.RedisHost, cfg.RedisPort)
+
+	// live carries the config that SIGHUP can swap at runtime.
+	live := config.NewLive(cfg)
+
+	rdb, err := redisclient.NewClient(cfg)
+	if err != nil {
+		log.Fatalf("[STARTUP] Failed to connect to Redis | error=%v", err)
+	}
+
+	log.Printf("[STARTUP] Initializing Socket.IO manager...")
+	sm := socketserver.NewManager(live)
+	if cfg.CrashSnapshotPath != "" {
+		crashHook = func(r interface{}) { writeCrashSnapshot(sm, cfg.CrashSnapshotPath, r) }
+		sm.OnPanic(crashHook)
+	}
+	msgs, err := i18n.Load(cfg.MessagesFile, cfg.DefaultLang)
+	if err != nil {
+		log.Fatalf("[STARTUP] Failed to load response messages | file=%s | error=%v", cfg.MessagesFile, err)
+	}
+	otps, err := otpstore.New(rdb, cfg)
+	if err != nil {
+		log.Fatalf("[STARTUP] Invalid OTP store configuration | error=%v", err)
+	}
+
+	// One-time copy of in-flight OTPs after a REDIS_KEY_PREFIX change, so
+	// codes issued before the deploy stay verifiable and their lockouts
+	// and cooldowns stay in force.
+	if cfg.MigratePrefix {
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[STARTUP][PANIC] Prefix migration panicked | panic=%v\nstack:\n%s", r, debug.Stack())
+				}
+			}()
+			log.Printf("[STARTUP] Migrating OTP keys | from=%q | to=%q", cfg.MigrateFromPrefix, cfg.RedisKeyPrefix)
+			copied, skipped, err := otps.MigratePrefix(context.Background(), cfg.MigrateFromPrefix)
+			if err != nil {
+				log.Printf("[STARTUP] OTP key migration failed | copied=%d | skipped=%d | error=%v", copied, skipped, err)
+				return
+			}
+			log.Printf("[STARTUP] OTP key migration complete | copied=%d | skipped=%d", copied, skipped)
+		}()
+	}
+	// Optional export of lifecycle events to a message bus.
+	var bus *eventbus.Bus
+	if cfg.EventBus != "" {
+		pub, err := eventbus.Open(cfg.EventBus, cfg.EventBusAddr, 5*time.Second)
+		if err != nil {
+			log.Fatalf("[STARTUP] Invalid event bus configuration | error=%v", err)
+		}
+		bus = eventbus.New(pub, cfg.EventBusTopic, cfg.EventBusQueueSize)
+		defer bus.Close(5 * time.Second)
+		log.Printf("[STARTUP] Event bus enabled | bus=%s | addr=%s | topic=%s | queue=%d",
+			cfg.EventBus, cfg.EventBusAddr, cfg.EventBusTopic, cfg.EventBusQueueSize)
+	}
+
+	h, err := handler.New(cfg, otps, sm, sm, msgs, bus)
+	if err != nil {
+		log.Fatalf("[STARTUP] Invalid handler configuration | error=%v", err)
+	}
+
+	features := func() config.Features {
+		f := live.Get().Features()
+		f.Transports = sm.Transports()
+		return f
+	}
+	log.Printf("[STARTUP] Features | %s", features())
+
+	// Start the Socket.IO serve loop.
+	// recover() here catches panics inside the Serve() loop itself.
+	// Panics in go-socket.io's per-connection goroutines are separate: those
+	// raised by our callbacks are recovered by socketserver's safeHandler,
+	// but a panic inside go-socket.io v1.7.0 itself still crashes the process.
+	// Docker's --restart unless-stopped handles the crash+restart automatically.
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[SOCKET][PANIC] Serve() goroutine panicked | panic=%v\nstack:\n%s",
+					r, debug.Stack())
+				if crashHook != nil {
+					crashHook(r)
+				}
+			}
+		}()
+		if err := sm.Serve(); err != nil {
+			log.Printf("[SOCKET] Serve() returned error | error=%v", err)
+		}
+	}()
+
+	// Background jobs stop when main returns.
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	go sm.RunReconciler(bgCtx, cfg.ReconcileInterval)
+	go sm.RunIdleMonitor(bgCtx, cfg.SocketIdleCheckInterval)
+
+	gin.SetMode(cfg.GinMode)
+
+	router := gin.New()
+	// Recovery catches panics in HTTP handler goroutines, logs them with the
+	// request ID and answers a JSON 500. It runs first so every request has
+	// an ID.
+	router.Use(middleware.Recovery(log.Default(), crashHook))
+	router.Use(gin.Logger())
+
+	router.Use(middleware.SecurityHeaders())
+	router.Use(middleware.CORS(live))
+	router.Use(middleware.ClientKey(live))
+
+	// Health check — first thing to call when debugging ECONNRESET.
+	// If this returns 200 the server is alive. If it times out, the server crashed.
+	// Liveness: 200 whenever the process answers; a failure means restart.
+	router.GET("/healthz", h.Health)
+	router.GET("/health", h.Health)
+	// Readiness: 503 while Redis is unreachable, the socket server is not
+	// serving or the post-deploy warmup is running; a failure means stop
+	// sending traffic, not restart.
+	router.GET("/readyz", h.Ready)
+	router.GET("/ready", h.Ready)
+	// Which features this deployment runs with; answers "why does prod
+	// behave differently than staging" without shell access.
+	router.GET("/health/detail", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "features": features()})
+	})
+	// Redis call counters and latency from the app's side of the connection.
+	router.GET("/health/redis", h.RedisHealth)
+	// Connected gateways, uptime and OTPs issued, for lightweight monitoring;
+	// the Prometheus variant adds verification and socket error counters.
+	router.GET("/metrics", h.Metrics)
+	router.GET("/metrics/prometheus", h.PrometheusMetrics)
+
+	// Socket.IO — both polling and WebSocket upgrade.
+	router.GET("/socket.io/*any", gin.WrapH(sm.Server))
+	router.POST("/socket.io/*any", gin.WrapH(sm.Server))
+
+	// REST API routes. When API keys are configured every route below
+	// requires one, and sends are scoped to the key's tenant. Responses are
+	// signed for tenants with a signing key. Each caller may have at most
+	// MAX_CONCURRENT_PER_IP of them in flight, and RATE_LIMITS per route
+	// within RATE_LIMIT_WINDOW_SECONDS.
+	api := router.Group("/", middleware.ConcurrencyLimit(live), middleware.RateLimit(live, otps), middleware.APIKeyAuth(live), middleware.SignResponses(live), h.TrackInFlight())
+	// Retries carrying the same Idempotency-Key get the first response back.
+	api.POST("/otp", middleware.Idempotency(live, otps), h.OTP)
+	api.POST("/otp/invalidate", h.Invalidate)
+	api.GET("/otp/status", h.Status)
+	api.POST("/otp/create", h.CreateOTP)
+	api.POST("/compare", h.Compare)
+	api.POST("/group_sms", h.GroupSMS)
+	api.POST("/send-sms", h.SendSMS)
+	api.POST("/bulk-sms", h.BulkSMS)
+
+	// Socket client inspection and maintenance, scoped to the caller's
+	// tenant.
+	api.GET("/sockets", h.Sockets)
+	api.POST("/sockets/:id/drain", h.DrainSocket)
+	api.POST("/sockets/:id/undrain", h.UndrainSocket)
+
+	// Counter snapshot for dashboards that cannot scrape metrics.
+	if cfg.StatsEnabled {
+		api.GET("/stats", h.Stats)
+	}
+
+	// Operator routes also need ADMIN_API_KEY in X-Admin-Key. The session
+	// pool and delivery counters are process-wide, not per tenant.
+	admin := api.Group("/", middleware.AdminAuth(live))
+	admin.GET("/sockets/internal", h.SocketSessions)
+	admin.POST("/sockets/internal/sweep", h.SweepSocketSessions)
+	admin.GET("/sockets/deliveries/:phone", h.PhoneDeliveries)
+
+	// Connected clients with remote address and connection time.
+	admin.GET("/admin/clients", h.AdminClients)
+	// Arbitrary broadcasts for integration tests; never enable in production.
+	if cfg.AdminEmitEnabled {
+		admin.POST("/admin/emit", h.AdminEmit)
+	}
+
+	addr := fmt.Sprintf("0.0.0.0:%s", cfg.Port)
+
+	srv := &http.Server{
+		Addr: addr,
+		// REST routes are bounded by HTTPHandlerTimeout. Socket.IO stays
+		// untimed, and so does /bulk-sms: it streams results and runs as
+		// long as the batch does.
+		Handler: middleware.Timeout(router, cfg.HTTPHandlerTimeout, "/socket.io/", "/bulk-sms"),
+		// Only timeout the header read to guard against Slowloris attacks.
+		// ReadTimeout / WriteTimeout would kill long-lived WebSocket connections.
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+
+	go func() {
+		var err error
+		if cfg.TLSEnabled() {
+			log.Printf("[STARTUP] HTTPS server listening | addr=%s | cert=%s", addr, cfg.TLSCertFile)
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("[STARTUP] HTTP server listening | addr=%s", addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("[STARTUP] Server failed | addr=%s | error=%v", addr, err)
+		}
+	}()
+
+	// Optional plain-HTTP listener that only redirects to HTTPS.
+	var redirect *http.Server
+	if cfg.TLSEnabled() && cfg.TLSRedirectPort != "" {
+		redirectAddr := fmt.Sprintf("0.0.0.0:%s", cfg.TLSRedirectPort)
+		redirect = &http.Server{
+			Addr:              redirectAddr,
+			Handler:           httpsRedirect(cfg.Port),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Printf("[STARTUP] HTTP→HTTPS redirect listening | addr=%s", redirectAddr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("[STARTUP] Redirect server failed | addr=%s | error=%v", redirectAddr, err)
+			}
+		}()
+	}
+
+	// SIGHUP reloads the hot-reloadable config without dropping sockets.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(live, h)
+		}
+	}()
+
+	// Block until SIGINT or SIGTERM (Ctrl-C / docker stop).
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	log.Printf("[SHUTDOWN] Signal received: %s – shutting down gracefully...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Warn gateways and give in-flight messages a moment to be confirmed
+	// before their connections go away with the HTTP server.
+	socketCtx, cancelSocket := context.WithTimeout(ctx, socketShutdownWait)
+	if err := sm.Shutdown(socketCtx); err != nil {
+		log.Printf("[SHUTDOWN] Socket.IO server close error | error=%v", err)
+	}
+	cancelSocket()
+
+	if redirect != nil {
+		_ = redirect.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("[SHUTDOWN] Forced shutdown | error=%v", err)
+	} else {
+		log.Printf("[SHUTDOWN] Server stopped cleanly")
+	}
+}
+
+// socketShutdownWait bounds how long shutdown waits for gateways to confirm
+// messages already handed to them.
+const socketShutdownWait = 5 * time.Second
+
+// httpsRedirect answers every request with a permanent redirect to the same
+// host and path on the HTTPS port.
+func httpsRedirect(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// crashSnapshotTimeout bounds writing the crash snapshot, in case the panic
+// left a socket manager lock held.
+const crashSnapshotTimeout = 3 * time.Second
+
+// writeCrashSnapshot dumps the socket clients and undelivered messages to
+// path before a crash exit.
+func writeCrashSnapshot(sm *socketserver.Manager, path string, reason interface{}) {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("snapshot panicked: %v", r)
+			}
+		}()
+		done <- sm.WriteSnapshot(path, fmt.Sprint(reason))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Printf("[PANIC] Failed to write crash snapshot | path=%s | error=%v", path, err)
+			return
+		}
+		log.Printf("[PANIC] Crash snapshot written | path=%s", path)
+	case <-time.After(crashSnapshotTimeout):
+		log.Printf("[PANIC] Crash snapshot timed out | path=%s | timeout=%s", path, crashSnapshotTimeout)
+	}
+}
+
+// reloadConfig re-reads the config and swaps its hot-reloadable subset into
+// the running handlers and middleware. Listeners, Redis and socket
+// connections are left untouched. An invalid config is logged and ignored.
+func reloadConfig(live *config.Live, h *handler.Handler) {
+	log.Printf("[RELOAD] SIGHUP received, reloading configuration...")
+	next, err := config.Reload()
+	if err != nil {
+		log.Printf("[RELOAD] Failed to read configuration, keeping current | error=%v", err)
+		return
+	}
+	merged := live.Get().WithHot(next)
+	if err := h.Reload(merged); err != nil {
+		log.Printf("[RELOAD] Invalid configuration, keeping current | error=%v", err)
+		return
+	}
+	live.Set(merged)
+	logging.SetSensitive(merged.LogSensitive)
+	log.Printf("[RELOAD] Configuration reloaded | %s", merged.Features())
+}
@@ -0,0 +1,74 @@
+// Package metrics renders process counters in the Prometheus text
+// exposition format without depending on the Prometheus client library.
+//
+// The values themselves stay where they are maintained (atomics in the
+// handler and the socket manager); a Registry only holds a read function
+// per metric and formats them on each scrape. Metrics carry no labels.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ContentType is the Content-Type of WriteText's output.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+type metric struct {
+	name, help, typ string
+	read            func() int64
+}
+
+// Registry is a set of named metrics, written in registration order.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// New returns an empty Registry.
+func New() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// CounterFunc registers a monotonically increasing value read by fn.
+func (r *Registry) CounterFunc(name, help string, fn func() int64) {
+	r.add(metric{name: name, help: help, typ: typeCounter, read: fn})
+}
+
+// GaugeFunc registers a value that may go up and down, read by fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() int64) {
+	r.add(metric{name: name, help: help, typ: typeGauge, read: fn})
+}
+
+// add panics on a duplicate name: that is a wiring bug, caught at startup.
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name))
+	}
+	r.names[m.name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric with its HELP and TYPE lines.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.typ, m.name, m.read())
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientKeyKey is the gin context key holding the rate-limit key of the
+// caller's address (see RateLimitKey).
+const ClientKeyKey = "client_key"
+
+// ClientKey records the caller's rate-limit key in the context under
+// ClientKeyKey, for limiters to share one notion of "the same client".
+// Logs keep using the full c.ClientIP().
+func ClientKey(live *config.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ClientKeyKey, RateLimitKey(c.ClientIP(), live.Get().IPv6LimitPrefix))
+		c.Next()
+	}
+}
+
+// RateLimitKey normalises an address for rate limiting. IPv4 addresses
+// (including IPv4-mapped IPv6) are used whole. IPv6 addresses are masked to
+// their first v6Prefix bits, because a single subscriber is usually handed a
+// whole /64 and could otherwise rotate addresses within it to dodge limits.
+// A port, brackets or zone are stripped; anything unparseable is returned
+// unchanged.
+func RateLimitKey(addr string, v6Prefix int) string {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addr
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	if v6Prefix <= 0 || v6Prefix > 128 {
+		v6Prefix = 128
+	}
+	masked := ip.Mask(net.CIDRMask(v6Prefix, 128))
+	return masked.String() + "/" + strconv.Itoa(v6Prefix)
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit caps the requests one caller may have in flight at once
+// at cfg.MaxConcurrentPerIP, answering 429 beyond it, so a single source
+// opening many slow requests cannot tie up handler goroutines. Callers are
+// keyed by ClientKeyKey, so it must run after ClientKey. A caller's entry is
+// dropped as soon as its last request finishes, so idle callers cost
+// nothing.
+func ConcurrencyLimit(live *config.Live) gin.HandlerFunc {
+	var (
+		mu       sync.Mutex
+		inFlight = make(map[string]int)
+	)
+
+	return func(c *gin.Context) {
+		max := live.Get().MaxConcurrentPerIP
+		if max <= 0 {
+			c.Next()
+			return
+		}
+		key := c.GetString(ClientKeyKey)
+
+		mu.Lock()
+		n := inFlight[key]
+		if n >= max {
+			mu.Unlock()
+			log.Printf("[LIMIT] Too many concurrent requests | ip=%s | key=%s | in_flight=%d | max=%d | path=%s",
+				c.ClientIP(), key, n, max, c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Too many concurrent requests"})
+			return
+		}
+		inFlight[key] = n + 1
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			if inFlight[key] <= 1 {
+				delete(inFlight, key)
+			} else {
+				inFlight[key]--
+			}
+			mu.Unlock()
+		}()
+		c.Next()
+	}
+}
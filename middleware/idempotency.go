@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyHeader lets a caller retry a request safely: a repeat with the
+// same key within the window gets the first response back instead of
+// running again.
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen bounds the header so it cannot bloat Redis keys.
+const maxIdempotencyKeyLen = 128
+
+// IdempotencyStore keeps responses for replay in a shared store, so a
+// retry is answered the same whichever instance it reaches.
+type IdempotencyStore interface {
+	// ClaimIdempotency returns claimed true for the first request with key;
+	// otherwise the value saved for it, nil while that request still runs.
+	ClaimIdempotency(ctx context.Context, key string, ttl time.Duration) (stored []byte, claimed bool, err error)
+	SaveIdempotency(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	ReleaseIdempotency(ctx context.Context, key string) error
+}
+
+// storedResponse is the replayable part of a response.
+type storedResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+// Idempotency replays the stored response to requests repeating an
+// Idempotency-Key seen within cfg.IdempotencyTTL, marked with
+// Idempotent-Replayed: true. A repeat arriving while the first request still
+// runs gets 409. Keys are scoped to the tenant (TenantKey), caller
+// (ClientKeyKey) and route, so it must run after APIKeyAuth and ClientKey.
+// 5xx responses are not kept, so a retry after a server error runs again.
+// Requests without the header, and all requests when the store fails, pass
+// straight through.
+func Idempotency(live *config.Live, store IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ttl := live.Get().IdempotencyTTL
+		header := c.GetHeader(IdempotencyHeader)
+		if header == "" || ttl <= 0 {
+			c.Next()
+			return
+		}
+		if len(header) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Idempotency-Key too long", "max": maxIdempotencyKeyLen})
+			return
+		}
+		key := c.GetString(TenantKey) + ":" + c.GetString(ClientKeyKey) + ":" + c.FullPath() + ":" + header
+
+		raw, claimed, err := store.ClaimIdempotency(c.Request.Context(), key, ttl)
+		var stored storedResponse
+		if err == nil && raw != nil {
+			err = json.Unmarshal(raw, &stored)
+		}
+		if err != nil {
+			log.Printf("[IDEMPOTENCY] Claim failed, running request | ip=%s | path=%s | error=%v", c.ClientIP(), c.FullPath(), err)
+			c.Next()
+			return
+		}
+		if raw != nil {
+			log.Printf("[IDEMPOTENCY] Replaying stored response | ip=%s | path=%s | key=%q | status=%d", c.ClientIP(), c.FullPath(), header, stored.Status)
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+		if !claimed {
+			log.Printf("[IDEMPOTENCY] Request with this key still running | ip=%s | path=%s | key=%q", c.ClientIP(), c.FullPath(), header)
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "A request with this Idempotency-Key is in progress"})
+			return
+		}
+
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		// The caller may have gone away by now (that is what it retries
+		// after), so the result is kept regardless.
+		ctx := context.WithoutCancel(c.Request.Context())
+		completed := false
+		defer func() {
+			c.Writer = w.ResponseWriter
+			if !completed {
+				// A panic: let the retry run again.
+				_ = store.ReleaseIdempotency(ctx, key)
+			}
+		}()
+		c.Next()
+		completed = true
+
+		body := w.buf.Bytes()
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(body)
+
+		if w.status >= http.StatusInternalServerError {
+			err = store.ReleaseIdempotency(ctx, key)
+		} else {
+			raw, _ = json.Marshal(storedResponse{
+				Status:      w.status,
+				ContentType: w.ResponseWriter.Header().Get("Content-Type"),
+				Body:        body,
+			})
+			err = store.SaveIdempotency(ctx, key, raw, ttl)
+		}
+		if err != nil {
+			log.Printf("[IDEMPOTENCY] Failed to store result | ip=%s | path=%s | key=%q | status=%d | error=%v", c.ClientIP(), c.FullPath(), header, w.status, err)
+		}
+	}
+}
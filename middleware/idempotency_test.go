@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memIdempotency is an in-process IdempotencyStore.
+type memIdempotency struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (s *memIdempotency) ClaimIdempotency(_ context.Context, key string, _ time.Duration) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.keys[key]; ok {
+		return v, false, nil
+	}
+	s.keys[key] = nil
+	return nil, true, nil
+}
+
+func (s *memIdempotency) SaveIdempotency(_ context.Context, key string, value []byte, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = value
+	return nil
+}
+
+func (s *memIdempotency) ReleaseIdempotency(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+func TestIdempotencyKeysAreTenantScoped(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	live := config.NewLive(&config.Config{IdempotencyTTL: time.Minute})
+	runs := 0
+	r := gin.New()
+	r.POST("/otp", func(c *gin.Context) {
+		c.Set(TenantKey, c.GetHeader("X-Tenant"))
+		c.Set(ClientKeyKey, "shared-nat")
+	}, Idempotency(live, &memIdempotency{keys: map[string][]byte{}}), func(c *gin.Context) {
+		runs++
+		c.JSON(http.StatusOK, gin.H{"tenant": c.GetString(TenantKey)})
+	})
+
+	send := func(tenant string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/otp", nil)
+		req.Header.Set(IdempotencyHeader, "retry-1")
+		req.Header.Set("X-Tenant", tenant)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	send("tenant-a")
+	if w := send("tenant-a"); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("repeat from the same tenant was not replayed: %d %s", w.Code, w.Body)
+	}
+	w := send("tenant-b")
+	if w.Header().Get("Idempotent-Replayed") != "" || w.Body.String() != `{"tenant":"tenant-b"}` {
+		t.Fatalf("tenant-b got tenant-a's response: %s", w.Body)
+	}
+	if runs != 2 {
+		t.Fatalf("handler ran %d times, want 2", runs)
+	}
+}
@@ -1,60 +1,180 @@
-package middleware
-
-import (
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-)
-
-// CORS allows requests from any origin.
-func CORS() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		origin := c.Request.Header.Get("Origin")
-		if origin != "" {
-			// Echo the request origin so credentials work alongside the wildcard.
-			c.Header("Access-Control-Allow-Origin", origin)
-			c.Header("Access-Control-Allow-Credentials", "true")
-		} else {
-			c.Header("Access-Control-Allow-Origin", "*")
-		}
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Vary", "Origin")
-
-		// Handle preflight.
-		if c.Request.Method == http.MethodOptions {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-
-		c.Next()
-	}
-}
-
-// SecurityHeaders sets the same security headers that helmet.js applied in
-// the Node.js version.
-func SecurityHeaders() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// frameguard: deny
-		c.Header("X-Frame-Options", "DENY")
-		// noSniff
-		c.Header("X-Content-Type-Options", "nosniff")
-		// xssFilter
-		c.Header("X-XSS-Protection", "1; mode=block")
-		// hsts: maxAge=123456, includeSubDomains=false
-		c.Header("Strict-Transport-Security", "max-age=123456")
-		// referrerPolicy
-		c.Header("Referrer-Policy", "origin, unsafe-url")
-		// contentSecurityPolicy
-		c.Header("Content-Security-Policy",
-			"default-src 'self'; script-src 'self' securecoding.com")
-		// dnsPrefetchControl: allow
-		c.Header("X-DNS-Prefetch-Control", "on")
-		// ieNoOpen
-		c.Header("X-Download-Options", "noopen")
-		// crossOriginOpenerPolicy
-		c.Header("Cross-Origin-Opener-Policy", "same-origin")
-
-		c.Next()
-	}
-}
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantKey is the gin context key holding the tenant of the authenticated
+// API key ("" in single-tenant deployments).
+const TenantKey = "tenant"
+
+// APIKeyAuth requires a valid X-API-Key header and records the key's tenant
+// in the context under TenantKey. It is a no-op when no keys are configured.
+// Any number of keys may be valid at once, so a key is rotated by adding the
+// new one to API_KEYS, moving callers over, then dropping the old one.
+func APIKeyAuth(live *config.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := live.Get()
+		if len(cfg.APIKeys) == 0 {
+			c.Next()
+			return
+		}
+
+		tenant, ok := cfg.LookupAPIKey(c.GetHeader("X-API-Key"))
+		if !ok {
+			log.Printf("[AUTH] Missing or invalid API key | ip=%s | path=%s", c.ClientIP(), c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+
+		c.Set(TenantKey, tenant)
+		c.Next()
+	}
+}
+
+// AdminAuth guards operator routes with ADMIN_API_KEY, sent as X-Admin-Key
+// in addition to the tenant's API key. Unlike APIKeyAuth it fails closed:
+// with no admin key configured every request is refused.
+func AdminAuth(live *config.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := live.Get()
+		if cfg.AdminAPIKey == "" {
+			log.Printf("[AUTH] Admin route disabled, no admin key configured | ip=%s | path=%s", c.ClientIP(), c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Forbidden"})
+			return
+		}
+		if !cfg.IsAdminKey(c.GetHeader("X-Admin-Key")) {
+			log.Printf("[AUTH] Missing or invalid admin key | ip=%s | path=%s", c.ClientIP(), c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CORS rejection modes.
+const (
+	// CORSRejectJSON answers disallowed origins with a JSON 403.
+	CORSRejectJSON = "json"
+	// CORSRejectHeaderless serves the request without any CORS headers, so
+	// the browser itself blocks the response and reports a proper CORS error.
+	CORSRejectHeaderless = "headerless"
+)
+
+// CORS allows requests from the configured origins, or from any origin when
+// none are configured. Requests without an Origin header (server-to-server)
+// are never rejected. The allowed headers and methods come from config; the
+// matcher and header values are rebuilt only when the config changes.
+func CORS(live *config.Live) gin.HandlerFunc {
+	var cached atomic.Pointer[corsState]
+
+	return func(c *gin.Context) {
+		cfg := live.Get()
+		st := cached.Load()
+		if st == nil || st.cfg != cfg {
+			st = &corsState{
+				cfg:     cfg,
+				allowed: newOriginMatcher(cfg.AllowedOrigins),
+				headers: strings.Join(cfg.CORSAllowHeaders, ", "),
+				methods: strings.Join(cfg.CORSAllowMethods, ", "),
+			}
+			cached.Store(st)
+		}
+		allowed := st.allowed
+
+		origin := c.Request.Header.Get("Origin")
+		c.Header("Vary", "Origin")
+
+		if origin != "" && !allowed.empty() {
+			if !allowed.allows(origin) {
+				if cfg.CORSLogRejected {
+					log.Printf("[CORS] Origin rejected | origin=%s | ip=%s | path=%s", origin, c.ClientIP(), c.Request.URL.Path)
+				}
+				if cfg.CORSRejectMode == CORSRejectHeaderless {
+					if c.Request.Method == http.MethodOptions {
+						c.AbortWithStatus(http.StatusNoContent)
+						return
+					}
+					c.Next()
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Not allowed by CORS"})
+				return
+			}
+		}
+
+		if origin != "" {
+			// Echo the request origin so credentials work alongside the wildcard.
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		} else {
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
+		headers := st.headers
+		if cfg.CORSEchoRequestHeaders {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+			if requested := c.Request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				headers = requested
+			}
+		}
+		c.Header("Access-Control-Allow-Headers", headers)
+		c.Header("Access-Control-Allow-Methods", st.methods)
+
+		// Handle preflight. Only its result may be cached by the browser.
+		if c.Request.Method == http.MethodOptions {
+			if cfg.CORSMaxAge > 0 {
+				c.Header("Access-Control-Max-Age", strconv.Itoa(int(cfg.CORSMaxAge.Seconds())))
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// corsState pairs a config with the origin matcher and header values built
+// from it.
+type corsState struct {
+	cfg     *config.Config
+	allowed *originMatcher
+	// headers and methods are the joined Access-Control-Allow-* values.
+	headers string
+	methods string
+}
+
+// SecurityHeaders sets the same security headers that helmet.js applied in
+// the Node.js version.
+func SecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// frameguard: deny
+		c.Header("X-Frame-Options", "DENY")
+		// noSniff
+		c.Header("X-Content-Type-Options", "nosniff")
+		// xssFilter
+		c.Header("X-XSS-Protection", "1; mode=block")
+		// hsts: maxAge=123456, includeSubDomains=false
+		c.Header("Strict-Transport-Security", "max-age=123456")
+		// referrerPolicy
+		c.Header("Referrer-Policy", "origin, unsafe-url")
+		// contentSecurityPolicy
+		c.Header("Content-Security-Policy",
+			"default-src 'self'; script-src 'self' securecoding.com")
+		// dnsPrefetchControl: allow
+		c.Header("X-DNS-Prefetch-Control", "on")
+		// ieNoOpen
+		c.Header("X-Download-Options", "noopen")
+		// crossOriginOpenerPolicy
+		c.Header("Cross-Origin-Opener-Policy", "same-origin")
+
+		c.Next()
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import "strings"
+
+// originMatcher decides whether a browser Origin is on the CORS allowlist.
+// All normalisation of the configured list happens once at construction, so
+// the per-request cost is one string normalisation, one map lookup and a
+// suffix check per wildcard entry.
+type originMatcher struct {
+	exact     map[string]struct{}
+	wildcards []wildcardOrigin
+}
+
+// wildcardOrigin is an allowlist entry like "https://*.example.com[:port]".
+// It matches any subdomain of the domain, at any depth, with the same
+// scheme and port, but never the bare domain itself.
+type wildcardOrigin struct {
+	// scheme includes "://".
+	scheme string
+	// suffix is ".example.com" plus any ":port".
+	suffix string
+}
+
+func newOriginMatcher(origins []string) *originMatcher {
+	m := &originMatcher{exact: make(map[string]struct{}, len(origins))}
+	for _, o := range origins {
+		o = normalizeOrigin(o)
+		if w, ok := parseWildcardOrigin(o); ok {
+			m.wildcards = append(m.wildcards, w)
+			continue
+		}
+		m.exact[o] = struct{}{}
+	}
+	return m
+}
+
+// parseWildcardOrigin recognises a normalised "scheme://*.domain" entry.
+func parseWildcardOrigin(o string) (wildcardOrigin, bool) {
+	i := strings.Index(o, "://*.")
+	if i <= 0 || len(o) == i+len("://*.") {
+		return wildcardOrigin{}, false
+	}
+	return wildcardOrigin{scheme: o[:i+len("://")], suffix: o[i+len("://*"):]}, true
+}
+
+// matches reports whether the normalised origin is a subdomain covered by w.
+// The suffix starts with a dot, so "evil-example.com" never matches
+// "*.example.com", and the part before it must be a non-empty host name, so
+// neither does "example.com".
+func (w wildcardOrigin) matches(origin string) bool {
+	rest, ok := strings.CutPrefix(origin, w.scheme)
+	if !ok {
+		return false
+	}
+	sub, ok := strings.CutSuffix(rest, w.suffix)
+	if !ok || sub == "" {
+		return false
+	}
+	for _, label := range strings.Split(sub, ".") {
+		if label == "" || strings.Trim(label, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// empty reports whether no origins are configured (allow all).
+func (m *originMatcher) empty() bool {
+	return len(m.exact) == 0 && len(m.wildcards) == 0
+}
+
+func (m *originMatcher) allows(origin string) bool {
+	origin = normalizeOrigin(origin)
+	if _, ok := m.exact[origin]; ok {
+		return true
+	}
+	for _, w := range m.wildcards {
+		if w.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeOrigin lower-cases an origin and drops a trailing slash, since
+// scheme and host are case-insensitive and config entries are hand-written.
+func normalizeOrigin(o string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o)), "/")
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOriginMatcher(t *testing.T) {
+	m := newOriginMatcher([]string{"https://app.example.com/", "HTTPS://*.Example.org", "http://*.local.test:8080"})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com/", true},
+		{"http://app.example.com", false},
+		{"https://other.example.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evil-example.org", false},
+		{"https://a..example.org", false},
+		{"http://dev.local.test:8080", true},
+		{"http://dev.local.test", false},
+	}
+	for _, tt := range tests {
+		if got := m.allows(tt.origin); got != tt.want {
+			t.Errorf("allows(%q) = %t, want %t", tt.origin, got, tt.want)
+		}
+	}
+}
+
+// benchOrigins returns n allowlist entries, one in ten of them wildcards.
+func benchOrigins(n int) []string {
+	origins := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if i%10 == 0 {
+			origins = append(origins, fmt.Sprintf("https://*.tenant%d.example.com", i))
+		} else {
+			origins = append(origins, fmt.Sprintf("https://app%d.example.com", i))
+		}
+	}
+	return origins
+}
+
+func BenchmarkOriginMatcher500(b *testing.B) {
+	m := newOriginMatcher(benchOrigins(500))
+	for _, bm := range []struct{ name, origin string }{
+		{"exact", "https://app499.example.com"},
+		{"wildcard", "https://eu.tenant490.example.com"},
+		{"miss", "https://attacker.example.net"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.allows(bm.origin)
+			}
+		})
+	}
+}
+
+// BenchmarkCORS500 measures the whole middleware per request with 500
+// configured origins. The matcher is built on the first request only.
+func BenchmarkCORS500(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	live := config.NewLive(&config.Config{
+		AllowedOrigins:   benchOrigins(500),
+		CORSRejectMode:   CORSRejectJSON,
+		CORSAllowHeaders: []string{"Content-Type", "Authorization"},
+		CORSAllowMethods: []string{"GET", "POST", "OPTIONS"},
+	})
+	r := gin.New()
+	r.Use(CORS(live))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, bm := range []struct{ name, origin string }{
+		{"exact", "https://app499.example.com"},
+		{"wildcard", "https://eu.tenant490.example.com"},
+		{"miss", "https://attacker.example.net"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Origin", bm.origin)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
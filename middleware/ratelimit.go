@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sms_service/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter counts requests in a shared store, so limits hold across
+// every instance of the service.
+type RateLimiter interface {
+	// AllowRequest returns 0 when client may make another request to route,
+	// otherwise how long until it may.
+	AllowRequest(ctx context.Context, route, client string, limit int, window time.Duration) (time.Duration, error)
+}
+
+// RateLimit allows each caller cfg.RateLimit(route) requests per route
+// within a sliding cfg.RateLimitWindow, answering 429 with Retry-After
+// beyond it. Callers are keyed by ClientKeyKey, so it must run after
+// ClientKey. When the limiter fails the request is let through: a Redis
+// outage should not take the API down with it.
+func RateLimit(live *config.Live, limiter RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := live.Get()
+		route := c.FullPath()
+		limit := cfg.RateLimit(route)
+		if limit <= 0 || cfg.RateLimitWindow <= 0 {
+			c.Next()
+			return
+		}
+		key := c.GetString(ClientKeyKey)
+
+		wait, err := limiter.AllowRequest(c.Request.Context(), route, key, limit, cfg.RateLimitWindow)
+		if err != nil {
+			log.Printf("[LIMIT] Rate limit check failed, allowing | ip=%s | path=%s | error=%v", c.ClientIP(), route, err)
+			c.Next()
+			return
+		}
+		if wait > 0 {
+			secs := int(math.Ceil(wait.Seconds()))
+			log.Printf("[LIMIT] Rate limit exceeded | ip=%s | key=%s | path=%s | limit=%d | window=%s | retry_after=%ds",
+				c.ClientIP(), key, route, limit, cfg.RateLimitWindow, secs)
+			c.Header("Retry-After", strconv.Itoa(secs))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Too many requests", "retry_after": secs})
+			return
+		}
+		c.Next()
+	}
+}
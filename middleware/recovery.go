@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDKey is the gin context key holding the request ID set by
+// Recovery; the same ID is echoed in the X-Request-ID response header.
+const RequestIDKey = "request_id"
+
+// Recovery replaces gin.Recovery: a panicking handler is logged through
+// logger with its stack and request ID, and the caller gets a JSON 500
+// carrying the same ID instead of gin's empty response. onPanic, if set, is
+// given each recovered panic after it is logged. It also assigns every
+// request an ID (the caller's X-Request-ID if sent), so it should be the
+// first middleware.
+func Recovery(logger *log.Logger, onPanic func(reason interface{})) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header("X-Request-ID", id)
+
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				// The client went away; net/http handles this silently.
+				panic(r)
+			}
+			logger.Printf("[HTTP][PANIC] Handler panicked | request_id=%s | method=%s | path=%s | ip=%s | panic=%v\nstack:\n%s",
+				id, c.Request.Method, c.Request.URL.Path, c.ClientIP(), r, debug.Stack())
+			if onPanic != nil {
+				onPanic(r)
+			}
+			if c.Writer.Written() {
+				// Too late for a clean error: part of the response is out.
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"code":       "internal_error",
+				"message":    "Internal server error",
+				"request_id": id,
+			})
+		}()
+		c.Next()
+	}
+}
+
+// newRequestID returns a random request identifier.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoveryReportsPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var reported interface{}
+	r := gin.New()
+	r.Use(Recovery(log.New(io.Discard, "", 0), func(reason interface{}) { reported = reason }))
+	r.GET("/", func(c *gin.Context) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if reported != "boom" {
+		t.Fatalf("onPanic got %v, want boom", reported)
+	}
+}
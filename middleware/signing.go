@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"sms_service/config"
+	"sms_service/signing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SignResponses adds X-Signature and X-Signature-Timestamp headers to every
+// response for tenants with a signing key, so partners can verify a response
+// came from us unaltered (see package signing for the canonical form). It
+// must run after APIKeyAuth, which sets the tenant. Tenants without a key
+// get unsigned, unbuffered responses.
+func SignResponses(live *config.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret, ok := live.Get().SigningKey(c.GetString(TenantKey))
+		if !ok {
+			c.Next()
+			return
+		}
+
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		// Restored on the way out even if a handler panics, so Recovery's
+		// error response reaches the client rather than the buffer.
+		defer func() { c.Writer = w.ResponseWriter }()
+		c.Next()
+
+		body := w.buf.Bytes()
+		h := w.ResponseWriter.Header()
+		now := time.Now()
+		h.Set(signing.HeaderTimestamp, signing.Timestamp(now))
+		h.Set(signing.HeaderSignature, signing.Sign(secret, now, body))
+		w.ResponseWriter.WriteHeader(w.status)
+		_, _ = w.ResponseWriter.Write(body)
+	}
+}
+
+// bufferedWriter holds the response back until it can be signed. Flush is a
+// no-op: a streamed response to a signing tenant arrives whole at the end.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (w *bufferedWriter) WriteHeader(code int) { w.status = code }
+func (w *bufferedWriter) WriteHeaderNow()      {}
+func (w *bufferedWriter) Flush()               {}
+func (w *bufferedWriter) Status() int          { return w.status }
+func (w *bufferedWriter) Size() int            { return w.buf.Len() }
+func (w *bufferedWriter) Written() bool        { return false }
+
+func (w *bufferedWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+
+func (w *bufferedWriter) WriteString(s string) (int, error) { return w.buf.WriteString(s) }
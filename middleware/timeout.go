@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// timeoutBody is what a timed-out REST request receives, matching the
+// JSON shape of Recovery's 500.
+const timeoutBody = `{"code":"timeout","message":"Request timed out"}`
+
+// Timeout wraps next with http.TimeoutHandler for every path except those
+// starting with one of untimed. The server itself sets no write timeout,
+// since that would cut long-lived Socket.IO connections; this bounds the
+// REST API instead, so a slow client cannot hold a handler forever.
+// A timeout of 0 returns next unchanged.
+func Timeout(next http.Handler, timeout time.Duration, untimed ...string) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	timed := http.TimeoutHandler(next, timeout, timeoutBody)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range untimed {
+			if strings.HasPrefix(r.URL.Path, p) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		timed.ServeHTTP(timeoutReplyWriter{w}, r)
+	})
+}
+
+// timeoutReplyWriter gives timeoutBody its JSON Content-Type. The timeout
+// reply is the one 503 http.TimeoutHandler writes without copying the
+// handler's headers first, so a 503 with no Content-Type is taken to be it;
+// every other response keeps the type its handler set.
+type timeoutReplyWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutReplyWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
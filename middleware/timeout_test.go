@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutContentType(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle http.HandlerFunc
+		status int
+		ctype  string
+	}{
+		{name: "timed out", status: http.StatusServiceUnavailable, ctype: "application/json; charset=utf-8",
+			handle: func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() }},
+		{name: "handler type kept", status: http.StatusOK, ctype: "text/csv",
+			handle: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/csv")
+				_, _ = w.Write([]byte("a,b\n"))
+			}},
+		{name: "no body", status: http.StatusNoContent, ctype: "",
+			handle: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Timeout(tt.handle, 20*time.Millisecond).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != tt.status || w.Header().Get("Content-Type") != tt.ctype {
+				t.Fatalf("got %d %q, want %d %q", w.Code, w.Header().Get("Content-Type"), tt.status, tt.ctype)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package otpstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// backlogKeyPrefix holds, per tenant, messages sent while no gateway was
+// connected, oldest first.
+const backlogKeyPrefix = "sms_queue:"
+
+// ErrBacklogFull is returned by PushBacklog when the tenant's backlog
+// already holds the maximum number of messages.
+var ErrBacklogFull = errors.New("sms backlog full")
+
+// pushBacklogScript appends ARGV[1] to the list at KEYS[1] unless it already
+// holds ARGV[2] entries. Returns the new length, or 0 when full.
+var pushBacklogScript = redis.NewScript(`
+if redis.call("LLEN", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+return redis.call("RPUSH", KEYS[1], ARGV[1])
+`)
+
+func (s *Store) backlogKey(tenant string) string {
+	return s.prefix + backlogKeyPrefix + tenant
+}
+
+// PushBacklog appends an encoded message to tenant's backlog, holding at
+// most max messages, and returns the backlog's new length. A full backlog
+// keeps what it has and returns ErrBacklogFull.
+func (s *Store) PushBacklog(ctx context.Context, tenant string, payload []byte, max int) (int, error) {
+	var n int64
+	err := s.do("backlog_push", func() (err error) {
+		n, err = pushBacklogScript.Run(ctx, s.rdb, []string{s.backlogKey(tenant)}, payload, max).Int64()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrBacklogFull
+	}
+	return int(n), nil
+}
+
+// PopBacklog removes and returns the oldest message in tenant's backlog, or
+// ErrNotFound when it is empty.
+func (s *Store) PopBacklog(ctx context.Context, tenant string) ([]byte, error) {
+	var b []byte
+	err := s.do("backlog_pop", func() (err error) {
+		b, err = s.rdb.LPop(ctx, s.backlogKey(tenant)).Bytes()
+		return err
+	})
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// ReturnBacklog puts a popped message back at the front of tenant's
+// backlog, for when it could not be sent after all. It ignores the size
+// cap, since the message was already counted against it.
+func (s *Store) ReturnBacklog(ctx context.Context, tenant string, payload []byte) error {
+	return s.do("backlog_return", func() error {
+		return s.rdb.LPush(ctx, s.backlogKey(tenant), payload).Err()
+	})
+}
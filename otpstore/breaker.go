@@ -0,0 +1,83 @@
+package otpstore
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned without calling Redis while the circuit
+// breaker is open.
+var ErrUnavailable = errors.New("redis unavailable")
+
+// Unavailable reports whether err means Redis could not be reached, as
+// opposed to Redis answering with an error or a miss.
+func Unavailable(err error) bool {
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	switch errorType(err) {
+	case ErrorTimeout, ErrorConnection:
+		return true
+	}
+	return false
+}
+
+// breaker fails Redis calls fast after threshold consecutive connection
+// failures, so requests are answered at once instead of each waiting out a
+// dial timeout. After cooldown a single call is let through; its outcome
+// closes the breaker or opens it for another cooldown. A zero threshold
+// never opens.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openTill time.Time
+	probing  bool
+}
+
+// allow reports whether a call may go to Redis now.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openTill) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record feeds the outcome of a call that allow let through.
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	failed := Unavailable(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.threshold
+	b.probing = false
+	if !failed {
+		if wasOpen {
+			log.Printf("[REDIS] Circuit breaker closed, Redis reachable again")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openTill = time.Now().Add(b.cooldown)
+		if !wasOpen {
+			log.Printf("[REDIS] Circuit breaker open, failing fast | failures=%d | cooldown=%s | error=%v", b.failures, b.cooldown, err)
+		}
+	}
+}
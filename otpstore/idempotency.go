@@ -0,0 +1,62 @@
+package otpstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// idempKeyPrefix holds responses kept for replay to retried requests,
+// keyed by caller and Idempotency-Key.
+const idempKeyPrefix = "idemp:"
+
+// idempPending marks a key whose first request has not finished yet.
+const idempPending = "pending"
+
+func (s *Store) idempKey(key string) string {
+	return s.prefix + idempKeyPrefix + key
+}
+
+// ClaimIdempotency claims key for ttl. It returns claimed true when this is
+// the first request with key, which must then SaveIdempotency or
+// ReleaseIdempotency. Otherwise it returns the stored value, or nil while
+// the first request is still running.
+func (s *Store) ClaimIdempotency(ctx context.Context, key string, ttl time.Duration) (stored []byte, claimed bool, err error) {
+	err = s.do("idempotency_claim", func() error {
+		claimed, err = s.rdb.SetNX(ctx, s.idempKey(key), idempPending, ttl).Result()
+		return err
+	})
+	if err != nil || claimed {
+		return nil, claimed, err
+	}
+
+	err = s.do("idempotency_get", func() (err error) {
+		stored, err = s.rdb.Get(ctx, s.idempKey(key)).Bytes()
+		return err
+	})
+	if errors.Is(err, redis.Nil) {
+		// Expired or released between the two calls; treat it as in
+		// progress and let the caller retry rather than race for it.
+		return nil, false, nil
+	}
+	if err != nil || string(stored) == idempPending {
+		return nil, false, err
+	}
+	return stored, false, nil
+}
+
+// SaveIdempotency stores value under a claimed key for ttl.
+func (s *Store) SaveIdempotency(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.do("idempotency_save", func() error {
+		return s.rdb.Set(ctx, s.idempKey(key), value, ttl).Err()
+	})
+}
+
+// ReleaseIdempotency drops a claimed key so a retry runs again.
+func (s *Store) ReleaseIdempotency(ctx context.Context, key string) error {
+	return s.do("idempotency_release", func() error {
+		return s.rdb.Del(ctx, s.idempKey(key)).Err()
+	})
+}
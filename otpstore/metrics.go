@@ -0,0 +1,122 @@
+package otpstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Error types recorded per Redis operation. ErrorNil is a miss rather than a
+// failure, but is counted alongside so a spike in misses is visible too.
+const (
+	ErrorTimeout    = "timeout"
+	ErrorNil        = "nil"
+	ErrorConnection = "connection"
+	ErrorOther      = "other"
+)
+
+// latencyBuckets are the histogram upper bounds in milliseconds. Anything
+// slower lands in the implicit +Inf bucket.
+var latencyBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500}
+
+// OpStats is a point-in-time copy of the counters for one Redis operation.
+type OpStats struct {
+	Total  uint64            `json:"redis_ops_total"`
+	Errors map[string]uint64 `json:"redis_errors_total"`
+	// Latency buckets are cumulative, keyed by upper bound in milliseconds
+	// ("+Inf" for the last), matching Prometheus histogram semantics.
+	Latency    map[string]uint64 `json:"latency_ms_bucket"`
+	LatencySum float64           `json:"latency_ms_sum"`
+}
+
+type opCounters struct {
+	total   uint64
+	errors  map[string]uint64
+	buckets []uint64 // len(latencyBuckets)+1, non-cumulative
+	sumMs   float64
+}
+
+// Metrics counts Redis calls made by the Store, by operation.
+type Metrics struct {
+	mu  sync.Mutex
+	ops map[string]*opCounters
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{ops: make(map[string]*opCounters)}
+}
+
+func (m *Metrics) observe(op string, d time.Duration, err error) {
+	ms := float64(d) / float64(time.Millisecond)
+	b := sort.SearchFloat64s(latencyBuckets, ms)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.ops[op]
+	if c == nil {
+		c = &opCounters{errors: make(map[string]uint64), buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.ops[op] = c
+	}
+	c.total++
+	c.buckets[b]++
+	c.sumMs += ms
+	if err != nil {
+		c.errors[errorType(err)]++
+	}
+}
+
+// Snapshot returns a copy of the counters for every operation seen so far.
+func (m *Metrics) Snapshot() map[string]OpStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]OpStats, len(m.ops))
+	for op, c := range m.ops {
+		s := OpStats{
+			Total:      c.total,
+			Errors:     make(map[string]uint64, len(c.errors)),
+			Latency:    make(map[string]uint64, len(c.buckets)),
+			LatencySum: c.sumMs,
+		}
+		for t, n := range c.errors {
+			s.Errors[t] = n
+		}
+		var cum uint64
+		for i, n := range c.buckets {
+			cum += n
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = formatBound(latencyBuckets[i])
+			}
+			s.Latency[le] = cum
+		}
+		out[op] = s
+	}
+	return out
+}
+
+func formatBound(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// errorType classifies a Redis error so timeouts, misses and broken
+// connections can be told apart when diagnosing OTP failures.
+func errorType(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, redis.Nil):
+		return ErrorNil
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.Is(err, redis.ErrClosed), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, new(*net.OpError)):
+		return ErrorConnection
+	default:
+		return ErrorOther
+	}
+}
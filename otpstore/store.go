@@ -0,0 +1,687 @@
+// Package otpstore persists one-time passwords in Redis.
+//
+// Each active OTP lives under a single key holding a JSON record, so related
+// state (issue time, nonce, earlier codes) is read and written together
+// instead of being scattered across per-feature keys. The wrong-attempt
+// count is the exception: it sits beside the record under
+// otp_attempts:<phone>, so raw-format records are counted too and storing a
+// new code can reset it without touching the record.
+package otpstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"sms_service/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "otp:"
+	// activeKey is a sorted set of phones with an active OTP, scored by
+	// expiry time in unix milliseconds, used for the system-wide cap.
+	activeKey = "otp_active"
+	// dedupKeyPrefix marks a (tenant, phone, message) recently emitted.
+	dedupKeyPrefix = "emit_dedup:"
+	// lockKeyPrefix marks a phone locked out of verification.
+	lockKeyPrefix = "otp_lock:"
+	// attemptsKeyPrefix counts wrong codes against a phone's current OTP.
+	attemptsKeyPrefix = "otp_attempts:"
+	// groupCooldownKeyPrefix marks a recent group SMS broadcast per scope.
+	groupCooldownKeyPrefix = "group_sms_cooldown:"
+	// resendCooldownKeyPrefix marks a phone recently sent a code.
+	resendCooldownKeyPrefix = "otp_cooldown:"
+	// rateKeyPrefix holds a caller's recent request times per route.
+	rateKeyPrefix = "rate:"
+)
+
+// migrateScanCount is the SCAN batch size hint used during prefix migration.
+const migrateScanCount = 500
+
+// Storage formats for newly written records. Reads accept both.
+const (
+	FormatJSON = "json"
+	FormatRaw  = "raw"
+)
+
+// ErrNotFound is returned when no OTP is stored for a phone.
+var ErrNotFound = errors.New("otp not found")
+
+// ErrExists is returned by Create when phone already has a record.
+var ErrExists = errors.New("otp already exists")
+
+// ErrMismatch is returned by Consume when the submitted code is wrong.
+var ErrMismatch = errors.New("otp mismatch")
+
+// ErrLocked is returned by Consume while a phone is locked out after too
+// many wrong codes.
+var ErrLocked = errors.New("otp verification locked")
+
+// ErrAttemptsExhausted is returned by Consume for the wrong code that
+// reaches the attempt limit: the code has been deleted and the phone locked.
+var ErrAttemptsExhausted = errors.New("otp attempts exhausted")
+
+// Record is everything stored for one active OTP.
+type Record struct {
+	Code     string    `json:"code"`
+	IssuedAt time.Time `json:"issued_at"`
+	Nonce    string    `json:"nonce,omitempty"`
+	// KeyID identifies the key a hashed code was derived with, so keys can be
+	// rotated without invalidating codes already issued.
+	KeyID string `json:"kid,omitempty"`
+	// Previous holds earlier codes that still verify, newest first (see
+	// Rotate).
+	Previous []PastCode `json:"previous,omitempty"`
+}
+
+// PastCode is a superseded code that stays valid until ExpiresAt (unix
+// milliseconds, Redis clock).
+type PastCode struct {
+	Code      string `json:"code"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewRecord returns a fresh record for code, stamped with the current time
+// and a random nonce.
+func NewRecord(code string) (*Record, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Record{
+		Code:     code,
+		IssuedAt: time.Now().UTC(),
+		Nonce:    hex.EncodeToString(b),
+	}, nil
+}
+
+// Store reads and writes OTP records.
+type Store struct {
+	rdb     *redis.Client
+	prefix  string
+	format  string
+	metrics *Metrics
+	breaker *breaker
+}
+
+// New creates a Store writing records in cfg.OTPStorageFormat ("json" or
+// "raw") under cfg.RedisKeyPrefix. The raw format stores only the code and
+// exists for rolling back to releases that predate JSON records.
+func New(rdb *redis.Client, cfg *config.Config) (*Store, error) {
+	switch cfg.OTPStorageFormat {
+	case FormatJSON, FormatRaw:
+	default:
+		return nil, fmt.Errorf("unknown OTP storage format %q", cfg.OTPStorageFormat)
+	}
+	return &Store{
+		rdb:     rdb,
+		prefix:  cfg.RedisKeyPrefix,
+		format:  cfg.OTPStorageFormat,
+		metrics: newMetrics(),
+		breaker: &breaker{threshold: cfg.RedisBreakerThreshold, cooldown: cfg.RedisBreakerCooldown},
+	}, nil
+}
+
+// Metrics returns the per-operation Redis counters for this store.
+func (s *Store) Metrics() *Metrics {
+	return s.metrics
+}
+
+// do runs one Redis call and records it under op. While the circuit
+// breaker is open it fails with ErrUnavailable without calling Redis.
+func (s *Store) do(op string, fn func() error) error {
+	if !s.breaker.allow() {
+		s.metrics.observe(op, 0, ErrUnavailable)
+		return ErrUnavailable
+	}
+	start := time.Now()
+	err := fn()
+	s.metrics.observe(op, time.Since(start), err)
+	s.breaker.record(err)
+	return err
+}
+
+func (s *Store) key(phone string) string {
+	return s.prefix + keyPrefix + phone
+}
+
+func (s *Store) attemptsKey(phone string) string {
+	return s.prefix + attemptsKeyPrefix + phone
+}
+
+// Get returns the record stored for phone, or ErrNotFound.
+// Values written by older releases as a bare code string are decoded into a
+// Record with only Code set.
+func (s *Store) Get(ctx context.Context, phone string) (*Record, error) {
+	var raw string
+	err := s.do("get", func() (err error) {
+		raw, err = s.rdb.Get(ctx, s.key(phone)).Result()
+		return err
+	})
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(raw)
+}
+
+// Save writes rec for phone with the given expiry, replacing any existing value.
+func (s *Store) Save(ctx context.Context, phone string, rec *Record, ttl time.Duration) error {
+	val, err := s.encode(rec)
+	if err != nil {
+		return err
+	}
+	return s.do("save", func() error {
+		pipe := s.rdb.TxPipeline()
+		pipe.Set(ctx, s.key(phone), val, ttl)
+		pipe.Del(ctx, s.attemptsKey(phone))
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
+
+// createScript stores ARGV[1] at KEYS[1] for ARGV[2] ms only if no value is
+// there, and then clears the attempt counter at KEYS[2]. Returns 1 when it
+// stored the value, 0 when one already existed.
+var createScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2], "NX") then
+	return 0
+end
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+// Create writes rec for phone with the given expiry unless a record already
+// exists, in which case it returns ErrExists. Check and write are one
+// atomic step, so of two concurrent requests for the same phone exactly
+// one stores its code.
+func (s *Store) Create(ctx context.Context, phone string, rec *Record, ttl time.Duration) error {
+	val, err := s.encode(rec)
+	if err != nil {
+		return err
+	}
+	var n int
+	err = s.do("create", func() (err error) {
+		n, err = createScript.Run(ctx, s.rdb, []string{s.key(phone), s.attemptsKey(phone)}, val, ttl.Milliseconds()).Int()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+// TTL returns how long the record for phone has left, or ErrNotFound.
+func (s *Store) TTL(ctx context.Context, phone string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.do("ttl", func() (err error) {
+		ttl, err = s.rdb.PTTL(ctx, s.key(phone)).Result()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	// go-redis passes PTTL's -2 (no key) and -1 (no expiry) through as is.
+	switch {
+	case ttl == -2:
+		return 0, ErrNotFound
+	case ttl < 0:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Delete removes the record for phone, its attempt count, its resend
+// cooldown and its slot in the active set. Deleting a missing record is not
+// an error.
+func (s *Store) Delete(ctx context.Context, phone string) error {
+	return s.do("delete", func() error {
+		pipe := s.rdb.TxPipeline()
+		pipe.Del(ctx, s.key(phone), s.attemptsKey(phone), s.prefix+resendCooldownKeyPrefix+phone)
+		pipe.ZRem(ctx, s.prefix+activeKey, phone)
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
+
+// rotateScript replaces the record at KEYS[1] with ARGV[1] for ARGV[2] ms,
+// moving the old code and up to ARGV[3]-2 of its unexpired previous codes
+// into the new record's "previous" list, and clears the attempt count at
+// KEYS[2].
+var rotateScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local rec = cjson.decode(ARGV[1])
+local keep = tonumber(ARGV[3]) - 1
+local prev = {}
+local old = redis.call("GET", KEYS[1])
+if old then
+	local o = {code = old}
+	if string.sub(old, 1, 1) == "{" then
+		o = cjson.decode(old)
+	end
+	local ttl = redis.call("PTTL", KEYS[1])
+	if keep > 0 and ttl > 0 then
+		table.insert(prev, {code = o["code"], exp = now + ttl})
+	end
+	if type(o["previous"]) == "table" then
+		for _, p in ipairs(o["previous"]) do
+			if #prev >= keep then
+				break
+			end
+			if tonumber(p["exp"]) > now then
+				table.insert(prev, p)
+			end
+		end
+	end
+end
+if #prev > 0 then
+	rec["previous"] = prev
+end
+redis.call("SET", KEYS[1], cjson.encode(rec), "PX", ARGV[2])
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+// Rotate stores rec as phone's newest code for ttl while the codes it
+// replaces keep verifying until their own expiry, up to keep codes in
+// total. Like Save it starts the wrong-attempt count over. In the raw
+// format there is no room for history and Rotate is a plain Save.
+func (s *Store) Rotate(ctx context.Context, phone string, rec *Record, ttl time.Duration, keep int) error {
+	if s.format == FormatRaw {
+		return s.Save(ctx, phone, rec, ttl)
+	}
+	val, err := s.encode(rec)
+	if err != nil {
+		return err
+	}
+	return s.do("rotate", func() error {
+		return rotateScript.Run(ctx, s.rdb, []string{s.key(phone), s.attemptsKey(phone)}, val, ttl.Milliseconds(), keep).Err()
+	})
+}
+
+// consumeScript checks the phone's lockout first, then compares ARGV[1]
+// with the stored code and its unexpired previous codes and, on a match,
+// deletes the record, its attempt count and its active slot in the same
+// step, so a concurrent compare or invalidate can never see a
+// half-consumed code. A mismatch bumps the count at KEYS[4], which expires
+// with the record; reaching ARGV[3] attempts deletes the record as on a
+// match and locks the phone for ARGV[4] ms. Returns {status, lock ms}:
+// 1 match, 0 no record, -1 mismatch, -2 already locked, -3 locked by this
+// attempt.
+var consumeScript = redis.NewScript(`
+redis.replicate_commands()
+local lock = redis.call("PTTL", KEYS[3])
+if lock > 0 then
+	return {-2, lock}
+end
+local v = redis.call("GET", KEYS[1])
+if not v then
+	return {0, 0}
+end
+local rec
+local code = v
+if string.sub(v, 1, 1) == "{" then
+	rec = cjson.decode(v)
+	code = rec["code"]
+end
+local match = code == ARGV[1]
+if not match and rec and type(rec["previous"]) == "table" then
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	for _, p in ipairs(rec["previous"]) do
+		if p["code"] == ARGV[1] and tonumber(p["exp"]) > now then
+			match = true
+			break
+		end
+	end
+end
+if match then
+	redis.call("DEL", KEYS[1], KEYS[4])
+	redis.call("ZREM", KEYS[2], ARGV[2])
+	return {1, 0}
+end
+local max = tonumber(ARGV[3])
+if max <= 0 then
+	return {-1, 0}
+end
+local n = redis.call("INCR", KEYS[4])
+if n >= max then
+	redis.call("DEL", KEYS[1], KEYS[4])
+	redis.call("ZREM", KEYS[2], ARGV[2])
+	redis.call("SET", KEYS[3], "1", "PX", ARGV[4])
+	return {-3, tonumber(ARGV[4])}
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[4], ttl)
+end
+return {-1, 0}
+`)
+
+// Consume verifies code against the record for phone (its current code or
+// an unexpired previous one) and deletes the record when it matches, as one
+// atomic operation. A locked phone is rejected with
+// ErrLocked before the code is even compared, so a correct guess during a
+// lockout is not confirmed. Otherwise it returns ErrNotFound when no code is
+// stored and ErrMismatch when code is wrong; a wrong code leaves the record
+// in place and counts towards maxAttempts (0 disables the lockout). The
+// last of them deletes the code, locks the phone and returns
+// ErrAttemptsExhausted. On either lock error the duration is the time left
+// on the lock. The count starts over whenever a new code is stored.
+func (s *Store) Consume(ctx context.Context, phone, code string, maxAttempts int, lockout time.Duration) (time.Duration, error) {
+	if lockout <= 0 {
+		maxAttempts = 0
+	}
+	var res []int64
+	err := s.do("consume", func() (err error) {
+		res, err = consumeScript.Run(ctx, s.rdb,
+			[]string{s.key(phone), s.prefix + activeKey, s.prefix + lockKeyPrefix + phone, s.attemptsKey(phone)},
+			code, phone, maxAttempts, lockout.Milliseconds()).Int64Slice()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	switch res[0] {
+	case 0:
+		return 0, ErrNotFound
+	case -1:
+		return 0, ErrMismatch
+	case -2:
+		return time.Duration(res[1]) * time.Millisecond, ErrLocked
+	case -3:
+		return time.Duration(res[1]) * time.Millisecond, ErrAttemptsExhausted
+	}
+	return 0, nil
+}
+
+// reserveScript atomically prunes expired entries from the active set and
+// adds phone if the set is below the cap. A phone already in the set keeps
+// its slot (its expiry is refreshed). Returns 1 when reserved, 0 when full.
+//
+// Expiry scores come from the Redis server's clock, not the caller's, so a
+// wall-clock jump (or skew between instances) on an app host cannot free
+// slots early or pin them past their TTL.
+var reserveScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expiry = now + tonumber(ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZSCORE", KEYS[1], ARGV[2]) then
+	redis.call("ZADD", KEYS[1], expiry, ARGV[2])
+	return 1
+end
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], expiry, ARGV[2])
+return 1
+`)
+
+// Reserve claims one of max system-wide active OTP slots for phone until
+// ttl elapses. It reports false when the system is at capacity. Slots free
+// themselves on expiry, so no decrement is needed when a code is never used.
+func (s *Store) Reserve(ctx context.Context, phone string, ttl time.Duration, max int) (bool, error) {
+	var n int
+	err := s.do("reserve", func() (err error) {
+		n, err = reserveScript.Run(ctx, s.rdb, []string{s.prefix + activeKey},
+			ttl.Milliseconds(), phone, max).Int()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Release gives back phone's active slot without touching its record.
+func (s *Store) Release(ctx context.Context, phone string) error {
+	return s.do("release", func() error {
+		return s.rdb.ZRem(ctx, s.prefix+activeKey, phone).Err()
+	})
+}
+
+// ClaimEmit records that message is about to be sent to phone for tenant
+// and reports whether this is the first such send within window. The key is
+// a hash, so message bodies are never written to Redis.
+func (s *Store) ClaimEmit(ctx context.Context, tenant, phone, message string, window time.Duration) (bool, error) {
+	var first bool
+	err := s.do("emit_dedup", func() (err error) {
+		first, err = s.rdb.SetNX(ctx, s.dedupKey(tenant, phone, message), 1, window).Result()
+		return err
+	})
+	return first, err
+}
+
+// ReleaseEmit drops the claim ClaimEmit took for a message that was not
+// sent after all, so the next attempt within the window goes out instead of
+// being suppressed as a duplicate.
+func (s *Store) ReleaseEmit(ctx context.Context, tenant, phone, message string) error {
+	return s.do("emit_dedup_release", func() error {
+		return s.rdb.Del(ctx, s.dedupKey(tenant, phone, message)).Err()
+	})
+}
+
+func (s *Store) dedupKey(tenant, phone, message string) string {
+	sum := sha256.Sum256([]byte(tenant + "\x00" + phone + "\x00" + message))
+	return s.prefix + dedupKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// cooldownScript claims the cooldown key if it is free. Returns 0 when
+// claimed, otherwise the key's remaining lifetime in milliseconds.
+var cooldownScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], 1, "NX", "PX", ARGV[1]) then
+	return 0
+end
+local pttl = redis.call("PTTL", KEYS[1])
+if pttl < 1 then
+	return 1
+end
+return pttl
+`)
+
+// rateScript keeps a sliding log of request times in a sorted set. It drops
+// entries older than the window, then records this request if fewer than
+// the limit remain. Returns 0 when recorded, otherwise milliseconds until
+// the oldest entry leaves the window. Like reserveScript, it reads the time
+// from the Redis server so every instance shares one window.
+var rateScript = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local window = tonumber(ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+if redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	redis.call("PEXPIRE", KEYS[1], window)
+	return 0
+end
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+local wait = tonumber(oldest[2]) + window - now
+if wait < 1 then
+	return 1
+end
+return wait
+`)
+
+// AllowRequest counts a request by client to route against limit requests
+// per window. It returns 0 when the request may go ahead, otherwise how long
+// until it would.
+func (s *Store) AllowRequest(ctx context.Context, route, client string, limit int, window time.Duration) (time.Duration, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	var ms int64
+	err := s.do("rate_limit", func() (err error) {
+		ms, err = rateScript.Run(ctx, s.rdb, []string{s.prefix + rateKeyPrefix + route + ":" + client},
+			window.Milliseconds(), limit, hex.EncodeToString(b)).Int64()
+		return err
+	})
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+// ClaimGroupBroadcast starts a group SMS cooldown of interval for scope (a
+// tenant, or "" for everyone). It returns 0 when the broadcast may go ahead,
+// otherwise how long until the current cooldown ends.
+func (s *Store) ClaimGroupBroadcast(ctx context.Context, scope string, interval time.Duration) (time.Duration, error) {
+	var ms int64
+	err := s.do("group_cooldown", func() (err error) {
+		ms, err = cooldownScript.Run(ctx, s.rdb, []string{s.prefix + groupCooldownKeyPrefix + scope},
+			interval.Milliseconds()).Int64()
+		return err
+	})
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+// ClaimResend starts a resend cooldown of interval for phone. It returns 0
+// when no cooldown was running, otherwise how long the running one has
+// left.
+func (s *Store) ClaimResend(ctx context.Context, phone string, interval time.Duration) (time.Duration, error) {
+	var ms int64
+	err := s.do("resend_cooldown", func() (err error) {
+		ms, err = cooldownScript.Run(ctx, s.rdb, []string{s.prefix + resendCooldownKeyPrefix + phone},
+			interval.Milliseconds()).Int64()
+		return err
+	})
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+// StartResend (re)starts phone's resend cooldown of interval.
+func (s *Store) StartResend(ctx context.Context, phone string, interval time.Duration) error {
+	return s.do("resend_cooldown_set", func() error {
+		return s.rdb.Set(ctx, s.prefix+resendCooldownKeyPrefix+phone, 1, interval).Err()
+	})
+}
+
+func (s *Store) encode(rec *Record) (string, error) {
+	if s.format == FormatRaw {
+		return rec.Code, nil
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decode(raw string) (*Record, error) {
+	if !strings.HasPrefix(raw, "{") {
+		return &Record{Code: raw}, nil
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return nil, fmt.Errorf("decode OTP record: %w", err)
+	}
+	return &rec, nil
+}
+
+// migrateFamilies are the key families MigratePrefix carries over: the
+// codes, and the wrong-attempt counts, lockouts and resend cooldowns that go
+// with them, so a prefix change neither lifts a lockout nor restarts a
+// cooldown. The active-OTP set is not copied; migrated codes do not count
+// towards MAX_ACTIVE_OTPS until they are reissued.
+var migrateFamilies = []string{keyPrefix, attemptsKeyPrefix, lockKeyPrefix, resendCooldownKeyPrefix}
+
+// MigratePrefix copies every unexpired OTP stored under oldPrefix, with its
+// verification and resend state (see migrateFamilies), to the store's
+// current prefix, preserving the remaining TTL. Keys that already exist
+// under the new prefix are left alone, so a code issued after the prefix
+// change always wins. Old keys are not deleted; they expire on their own.
+func (s *Store) MigratePrefix(ctx context.Context, oldPrefix string) (copied, skipped int, err error) {
+	if oldPrefix == s.prefix {
+		return 0, 0, nil
+	}
+	for _, family := range migrateFamilies {
+		if err = s.migrateFamily(ctx, oldPrefix, family, &copied, &skipped); err != nil {
+			return copied, skipped, err
+		}
+	}
+	return copied, skipped, nil
+}
+
+// migrateFamily copies one key family for MigratePrefix, adding to its
+// counts.
+func (s *Store) migrateFamily(ctx context.Context, oldPrefix, family string, copied, skipped *int) error {
+	var (
+		ttl time.Duration
+		val string
+		ok  bool
+		err error
+	)
+	iter := s.rdb.Scan(ctx, 0, oldPrefix+family+"*", migrateScanCount).Iterator()
+	for iter.Next(ctx) {
+		oldKey := iter.Val()
+		newKey := s.prefix + strings.TrimPrefix(oldKey, oldPrefix)
+
+		err = s.do("migrate_pttl", func() (err error) {
+			ttl, err = s.rdb.PTTL(ctx, oldKey).Result()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// -2: expired between SCAN and PTTL. -1: no expiry, not ours to move.
+		if ttl <= 0 {
+			*skipped++
+			continue
+		}
+
+		err = s.do("migrate_get", func() (err error) {
+			val, err = s.rdb.Get(ctx, oldKey).Result()
+			return err
+		})
+		if err == redis.Nil {
+			*skipped++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.do("migrate_setnx", func() (err error) {
+			ok, err = s.rdb.SetNX(ctx, newKey, val, ttl).Result()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			*skipped++
+			continue
+		}
+		*copied++
+		if *copied%1000 == 0 {
+			log.Printf("[OTPSTORE] Prefix migration progress | from=%q | to=%q | copied=%d | skipped=%d",
+				oldPrefix, s.prefix, *copied, *skipped)
+		}
+	}
+	// The iterator issues SCAN calls lazily inside Next, so only its
+	// final outcome can be recorded.
+	return s.do("migrate_scan", iter.Err)
+}
+
+// Ping checks that Redis answers. It goes through the circuit breaker like
+// every other call, so while the breaker is open it fails fast.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.do("ping", func() error {
+		return s.rdb.Ping(ctx).Err()
+	})
+}
@@ -0,0 +1,61 @@
+package otpstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sms_service/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMigratePrefixCarriesVerificationState(t *testing.T) {
+	t.Setenv("REDIS_KEY_PREFIX", "new:")
+	mr := miniredis.RunT(t)
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	s, err := New(rdb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const phone = "+99361234567"
+	old := map[string]string{
+		"old:" + keyPrefix + phone:               "123456",
+		"old:" + attemptsKeyPrefix + phone:       "2",
+		"old:" + lockKeyPrefix + phone:           "1",
+		"old:" + resendCooldownKeyPrefix + phone: "1",
+	}
+	for k, v := range old {
+		mr.Set(k, v)
+		mr.SetTTL(k, time.Minute)
+	}
+	mr.Set("old:"+keyPrefix+"+99362345678", "no expiry")
+	// Issued under the new prefix after the switch; must not be overwritten.
+	mr.Set("new:"+attemptsKeyPrefix+phone, "0")
+	mr.SetTTL("new:"+attemptsKeyPrefix+phone, time.Minute)
+
+	copied, skipped, err := s.MigratePrefix(context.Background(), "old:")
+	if err != nil || copied != 3 || skipped != 2 {
+		t.Fatalf("MigratePrefix = %d copied, %d skipped, %v; want 3, 2", copied, skipped, err)
+	}
+	for k, v := range old {
+		nk := "new:" + k[len("old:"):]
+		want := v
+		if nk == "new:"+attemptsKeyPrefix+phone {
+			want = "0"
+		}
+		if got, _ := mr.Get(nk); got != want {
+			t.Errorf("%s = %q, want %q", nk, got, want)
+		}
+		if ttl := mr.TTL(nk); ttl <= 0 || ttl > time.Minute {
+			t.Errorf("%s TTL = %v, want the remaining minute", nk, ttl)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package phone validates and normalises the mobile numbers the service
+// sends to.
+//
+// A number is the national part, matching the configured pattern (by
+// default Turkmen mobiles: 6, an operator digit 1-5 and six more digits),
+// optionally preceded by the country code (by default +993). The canonical
+// form, used in events and responses, always carries the country code; OTPs
+// are stored under the national part.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Defaults used until Setup is called: the Turkmen numbering plan.
+const (
+	DefaultCountryCode = "+993"
+	DefaultPattern     = `6[1-5][0-9]{6}`
+)
+
+// ErrInvalid is returned for anything that is not a valid mobile number.
+var ErrInvalid = errors.New("invalid phone number")
+
+var countryCodePattern = regexp.MustCompile(`^\+[1-9][0-9]{0,3}$`)
+
+// plan is the numbering plan in use.
+type plan struct {
+	countryCode string
+	national    *regexp.Regexp
+	// inText finds numbers, with or without the country code, inside free
+	// text.
+	inText *regexp.Regexp
+}
+
+var current atomic.Pointer[plan]
+
+func init() {
+	if err := Setup(DefaultCountryCode, DefaultPattern); err != nil {
+		panic(err)
+	}
+}
+
+// Setup sets the country code ("+" and 1-4 digits) and the regular
+// expression a national number must match in full. It is called once at
+// startup from config (COUNTRY_CODE, PHONE_PATTERN).
+func Setup(countryCode, pattern string) error {
+	if !countryCodePattern.MatchString(countryCode) {
+		return fmt.Errorf("country code %q: want + and 1-4 digits", countryCode)
+	}
+	national, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return fmt.Errorf("phone pattern: %w", err)
+	}
+	inText, err := regexp.Compile(`(\+?` + regexp.QuoteMeta(countryCode[1:]) + `)?(?:` + pattern + `)\b`)
+	if err != nil {
+		return fmt.Errorf("phone pattern: %w", err)
+	}
+	current.Store(&plan{countryCode: countryCode, national: national, inText: inText})
+	return nil
+}
+
+// CountryCode returns the prefix of every canonical number, e.g. "+993".
+func CountryCode() string {
+	return current.Load().countryCode
+}
+
+// InText returns a pattern finding numbers, with or without the country
+// code, inside free text, for redacting logs.
+func InText() *regexp.Regexp {
+	return current.Load().inText
+}
+
+// Normalize accepts a number with or without the country code and returns
+// its canonical form, e.g. "61234567" and "+99361234567" both give
+// "+99361234567".
+func Normalize(raw string) (string, error) {
+	return NormalizeLocal(strings.TrimPrefix(raw, CountryCode()))
+}
+
+// NormalizeLocal accepts only the national part, as the OTP endpoints
+// always have, and returns the canonical form.
+func NormalizeLocal(raw string) (string, error) {
+	p := current.Load()
+	if !p.national.MatchString(raw) {
+		return "", ErrInvalid
+	}
+	return p.countryCode + raw, nil
+}
+
+// National strips the country code from a canonical number.
+func National(canonical string) string {
+	return strings.TrimPrefix(canonical, CountryCode())
+}
@@ -0,0 +1,110 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+		err  error
+	}{
+		{name: "national", raw: "61234567", want: "+99361234567"},
+		{name: "with country code", raw: "+99361234567", want: "+99361234567"},
+		{name: "highest operator", raw: "65999999", want: "+99365999999"},
+		{name: "trailing digits", raw: "61234567890", err: ErrInvalid},
+		{name: "trailing junk", raw: "6123456garbage", err: ErrInvalid},
+		{name: "trailing junk after prefix", raw: "+99361234567x", err: ErrInvalid},
+		{name: "leading junk", raw: "x61234567", err: ErrInvalid},
+		{name: "too short", raw: "6123456", err: ErrInvalid},
+		{name: "too short with prefix", raw: "+9936123456", err: ErrInvalid},
+		{name: "too long with prefix", raw: "+993612345678", err: ErrInvalid},
+		{name: "country code without plus", raw: "99361234567", err: ErrInvalid},
+		{name: "country code only", raw: "+993", err: ErrInvalid},
+		{name: "empty", raw: "", err: ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.raw)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("Normalize(%q) = %q, %v; want %q, %v", tt.raw, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestNormalizeOperatorDigit(t *testing.T) {
+	for _, raw := range []string{"60123456", "66123456", "71234567"} {
+		if _, err := Normalize(raw); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Normalize(%q) error = %v, want ErrInvalid", raw, err)
+		}
+	}
+}
+
+func TestNormalizeLocal(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  error
+	}{
+		{raw: "61234567", want: "+99361234567"},
+		{raw: "+99361234567", err: ErrInvalid},
+		{raw: "99361234567", err: ErrInvalid},
+		{raw: "612345678", err: ErrInvalid},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeLocal(tt.raw)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeLocal(%q) = %q, %v; want %q, %v", tt.raw, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNational(t *testing.T) {
+	if got := National("+99361234567"); got != "61234567" {
+		t.Fatalf("National = %q, want 61234567", got)
+	}
+}
+
+func TestInText(t *testing.T) {
+	text := "sent to +99361234567 and 62345678, not 12345678"
+	got := InText().FindAllString(text, -1)
+	if len(got) != 2 || got[0] != "+99361234567" || got[1] != "62345678" {
+		t.Fatalf("InText found %q", got)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	t.Cleanup(func() {
+		if err := Setup(DefaultCountryCode, DefaultPattern); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := Setup("+7", `9[0-9]{9}`); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Normalize("+79123456789"); err != nil || got != "+79123456789" {
+		t.Fatalf("Normalize under +7 = %q, %v", got, err)
+	}
+	if _, err := Normalize("61234567"); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("old plan still accepted: %v", err)
+	}
+
+	for _, tt := range []struct{ code, pattern string }{
+		{"993", DefaultPattern},
+		{"+0993", DefaultPattern},
+		{"+99312", DefaultPattern},
+		{DefaultCountryCode, `6[1-5`},
+	} {
+		if err := Setup(tt.code, tt.pattern); err == nil {
+			t.Errorf("Setup(%q, %q) accepted", tt.code, tt.pattern)
+		}
+	}
+	if CountryCode() != "+7" {
+		t.Fatalf("a rejected Setup replaced the plan: country code %q", CountryCode())
+	}
+}
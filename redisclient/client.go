@@ -1,27 +1,53 @@
-package redisclient
-
-import (
-	"context"
-	"fmt"
-	"log"
-
-	"github.com/redis/go-redis/v9"
-	"sms_service/config"
-)
-
-func NewClient(cfg *config.Config) *redis.Client {
-	addr := fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort)
-	log.Printf("[REDIS] Connecting | addr=%s", addr)
-
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: cfg.RedisPassword,
-	})
-
-	if err := client.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("[REDIS] Failed to connect | addr=%s | error=%v", addr, err)
-	}
-
-	log.Printf("[REDIS] Connected and ready | addr=%s", addr)
-	return client
-}
+package redisclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"sms_service/config"
+)
+
+// maxRetryDelay caps the backoff between startup pings.
+const maxRetryDelay = 30 * time.Second
+
+// NewClient connects to Redis, pinging up to cfg.RedisConnectRetries times
+// with exponential backoff so a container that starts just before Redis
+// does not fail for good. It returns an error once every attempt failed.
+func NewClient(cfg *config.Config) (*redis.Client, error) {
+	addr := fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort)
+	log.Printf("[REDIS] Connecting | addr=%s | db=%d | pool_size=%d", addr, cfg.RedisDB, cfg.RedisPoolSize)
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     cfg.RedisPassword,
+		DB:           cfg.RedisDB,
+		PoolSize:     cfg.RedisPoolSize,
+		DialTimeout:  cfg.RedisDialTimeout,
+		ReadTimeout:  cfg.RedisReadTimeout,
+		WriteTimeout: cfg.RedisWriteTimeout,
+	})
+
+	delay := cfg.RedisRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := client.Ping(context.Background()).Err()
+		if err == nil {
+			break
+		}
+		if attempt >= cfg.RedisConnectRetries {
+			client.Close()
+			return nil, fmt.Errorf("connect to redis at %s after %d attempts: %w", addr, attempt, err)
+		}
+		log.Printf("[REDIS] Connect failed, retrying | addr=%s | attempt=%d/%d | retry_in=%s | error=%v",
+			addr, attempt, cfg.RedisConnectRetries, delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+
+	log.Printf("[REDIS] Connected and ready | addr=%s", addr)
+	return client, nil
+}
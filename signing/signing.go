@@ -0,0 +1,81 @@
+// Package signing authenticates payloads sent to partners — webhook bodies
+// and API responses — with an HMAC over the body and a timestamp.
+//
+// Canonicalization: the signed message is the decimal unix timestamp in
+// seconds, a single ".", then the raw body bytes exactly as sent:
+//
+//	message   = timestamp + "." + body
+//	signature = "v1=" + lowercase_hex(HMAC-SHA256(secret, message))
+//
+// The timestamp travels in the X-Signature-Timestamp header and the
+// signature in X-Signature. Receivers recompute the signature over the body
+// they received, compare it in constant time, and reject timestamps outside
+// their tolerance to stop replays. Verify does exactly this and is meant to
+// be mirrored by partners in their own language.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names carrying the signature and its timestamp.
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Signature-Timestamp"
+)
+
+const version = "v1="
+
+// Verification errors.
+var (
+	ErrBadTimestamp = errors.New("signature timestamp missing or malformed")
+	ErrExpired      = errors.New("signature timestamp outside tolerance")
+	ErrMismatch     = errors.New("signature mismatch")
+)
+
+// Sign returns the X-Signature value for body signed at ts.
+func Sign(secret string, ts time.Time, body []byte) string {
+	return version + hex.EncodeToString(mac(secret, Timestamp(ts), body))
+}
+
+// Timestamp returns the X-Signature-Timestamp value for ts.
+func Timestamp(ts time.Time) string {
+	return strconv.FormatInt(ts.Unix(), 10)
+}
+
+// Verify checks signature and timestamp (the raw header values) against body.
+// Timestamps further than tolerance from now, in either direction, are
+// rejected.
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadTimestamp
+	}
+	age := time.Since(time.Unix(sec, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrExpired
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, version))
+	if err != nil || !strings.HasPrefix(signature, version) {
+		return ErrMismatch
+	}
+	if !hmac.Equal(got, mac(secret, timestamp, body)) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
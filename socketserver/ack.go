@@ -0,0 +1,108 @@
+package socketserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+
+	"sms_service/logging"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// ErrNoClients is returned when an event needs a client but none is connected.
+var ErrNoClients = errors.New("no socket clients connected")
+
+// Delivery statuses reported back by gateways through Socket.IO acks.
+const (
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Ack is the first acknowledgement received for an emitted event, with the
+// Route of the client that sent it.
+type Ack struct {
+	Route
+	Status string
+	Data   interface{}
+}
+
+// EmitWithAck sends an event to every client of tenant with a Socket.IO ack
+// callback and blocks until the first client acknowledges it or ctx is done.
+// Later acks from other clients are ignored. Draining clients and clients
+// below the minimum firmware for the message or for acks are skipped.
+//
+// Gateways ack with either a status string or an object with a "status"
+// field; "failed" or "error" means the gateway could not deliver, anything
+// else is treated as delivered.
+func (m *Manager) EmitWithAck(ctx context.Context, tenant, event string, data interface{}) (Ack, error) {
+	min := m.minFirmware(data, true)
+	targets := m.snapshot(func(c *client) bool {
+		return c.tenant == tenant && !c.draining && versionAtLeast(c.firmware, min)
+	})
+
+	if len(targets) == 0 {
+		return Ack{}, ErrNoClients
+	}
+	if err := m.checkFanout(event, len(targets)); err != nil {
+		return Ack{}, err
+	}
+	log.Printf("[SOCKET] Emitting event with ack | event=%s | connected_clients=%d | data=%v", event, len(targets), logging.Payload(data))
+
+	// Buffered so late acks never block go-socket.io's read loop.
+	acks := make(chan Ack, len(targets))
+	for _, c := range targets {
+		id, route := c.id, c.route()
+		cb := func(resp interface{}) {
+			select {
+			case acks <- Ack{Route: route, Status: ackStatus(resp), Data: resp}:
+			default:
+			}
+		}
+		if err := emitAckSafe(c.conn, event, data, cb); err != nil {
+			log.Printf("[SOCKET] Emit failed, dropping client | id=%s | event=%s | error=%v", id, event, err)
+			m.remove(id)
+		}
+	}
+
+	select {
+	case ack := <-acks:
+		log.Printf("[SOCKET] Ack received | event=%s | id=%s | status=%s", event, ack.ClientID, ack.Status)
+		return ack, nil
+	case <-ctx.Done():
+		log.Printf("[SOCKET] No ack before deadline | event=%s | error=%v", event, ctx.Err())
+		return Ack{}, ctx.Err()
+	}
+}
+
+// emitAckSafe is emitSafe with an ack callback appended to the arguments.
+func emitAckSafe(conn socketio.Conn, event string, data interface{}, cb func(interface{})) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[SOCKET][PANIC] Emit panicked | id=%s | event=%s | panic=%v\nstack:\n%s",
+				conn.ID(), event, r, debug.Stack())
+			err = fmt.Errorf("emit panicked: %v", r)
+		}
+	}()
+	conn.Emit(event, data, cb)
+	return nil
+}
+
+func ackStatus(resp interface{}) string {
+	var status string
+	switch v := resp.(type) {
+	case string:
+		status = v
+	case map[string]interface{}:
+		status, _ = v["status"].(string)
+	}
+	switch strings.ToLower(status) {
+	case "failed", "error":
+		return StatusFailed
+	default:
+		return StatusDelivered
+	}
+}
@@ -0,0 +1,51 @@
+package socketserver
+
+import (
+	"context"
+
+	"sms_service/metrics"
+)
+
+// Broadcaster is the sending side of the Manager: everything HTTP handlers
+// need to get a message to gateways. Depending on it rather than on
+// *Manager lets a handler be exercised against a fake that records what
+// was sent, without a Socket.IO server.
+type Broadcaster interface {
+	Emit(event string, data interface{}) (int, error)
+	EmitTo(id, event string, data interface{}) error
+	EmitToTenant(tenant, event string, data interface{}) (int, error)
+	EmitToTenantRoom(tenant, room, event string, data interface{}) (int, error)
+	Dispatch(tenant, event string, data interface{}) (Route, error)
+	DispatchExcept(tenant, exclude, event string, data interface{}) (Route, error)
+	EmitToAvailable(event string, data interface{}) (string, error)
+	EmitToAvailableExcept(exclude, event string, data interface{}) (string, error)
+	EmitWithAck(ctx context.Context, tenant, event string, data interface{}) (Ack, error)
+}
+
+// Gateways is the inspection and maintenance side of the Manager, plus the
+// hooks a handler registers for gateway events. Like Broadcaster, it keeps
+// handlers off *Manager.
+type Gateways interface {
+	ClientCount() int
+	Clients() []ClientInfo
+	TenantClients(tenant string) []ClientInfo
+	ListClients(tenant string) []AdminClient
+	Stats() Stats
+	TenantStats(tenant string) Stats
+	Gauges() Gauges
+	Serving() bool
+	SessionStats() SessionStats
+	SweepSessions() int
+	Delivery(phone string) (PhoneDelivery, bool)
+	SetDraining(tenant, id string, draining bool) error
+
+	RegisterMetrics(reg *metrics.Registry)
+	OnClientConnect(fn func(id, tenant string))
+	OnSended(fn func(id, tenant, phone string))
+	OnSendFailed(fn func(FailedSend))
+}
+
+var (
+	_ Broadcaster = (*Manager)(nil)
+	_ Gateways    = (*Manager)(nil)
+)
@@ -0,0 +1,143 @@
+package socketserver
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"sms_service/logging"
+	"sms_service/phone"
+)
+
+// deliveryRetention is how long per-phone delivery stats are kept after the
+// phone's last "sended".
+const deliveryRetention = 24 * time.Hour
+
+// PhoneDelivery counts "sended" confirmations for one phone.
+type PhoneDelivery struct {
+	// Confirmed counts acks naming the phone the message was dispatched to.
+	Confirmed int `json:"confirmed"`
+	// Mismatched counts acks for a message to this phone that named a
+	// different phone, i.e. the gateway may have sent it elsewhere.
+	Mismatched    int       `json:"mismatched"`
+	LastConfirmed time.Time `json:"last_confirmed"`
+	// lastSeen keeps its monotonic reading for retention; LastConfirmed is
+	// UTC wall time for display.
+	lastSeen time.Time
+}
+
+// phoneOf returns the recipient of an outgoing event, "" if it has none.
+func phoneOf(data interface{}) string {
+	if ev, ok := data.(OTPEvent); ok {
+		return ev.Phone
+	}
+	return ""
+}
+
+// sendedPhone extracts the phone a gateway reports in its "sended" payload:
+// either a bare string or an object with a "phone" field.
+func sendedPhone(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if p, ok := v["phone"].(string); ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// normalizePhone reduces a phone to its national digits so "+99361234567",
+// "99361234567" and "61234567" compare equal (for country code +993).
+func normalizePhone(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimPrefix(b.String(), strings.TrimPrefix(phone.CountryCode(), "+"))
+}
+
+// confirm checks a "sended" from client id against the message dispatched to
+// it and records the outcome. Only dispatched (queued-mode) messages can be
+// checked; broadcasts have no single expected recipient. It returns the
+// client's tenant and the phone the message went to: the reported one, or
+// the expected one when the client named none.
+func (m *Manager) confirm(id string, data interface{}) (tenant, phone string) {
+	reported := sendedPhone(data)
+
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, ok := sh.clients[id]
+	if !ok {
+		sh.mu.Unlock()
+		return "", reported
+	}
+	expected := c.inflight
+	c.inflight = ""
+	c.current = queued{}
+	tenant = c.tenant
+	sh.mu.Unlock()
+	if expected == "" || reported == "" {
+		if m.cfg.Get().LogDebug {
+			log.Printf("[SOCKET][DEBUG] 'sended' not correlated | id=%s | expected=%q | reported=%q", id, logging.Phone(expected), logging.Phone(reported))
+		}
+		if reported == "" {
+			return tenant, expected
+		}
+		return tenant, reported
+	}
+
+	key := normalizePhone(expected)
+	m.mu.Lock()
+	d := m.deliveries[key]
+	if d == nil {
+		d = &PhoneDelivery{}
+		m.deliveries[key] = d
+	}
+	now := time.Now()
+	d.lastSeen = now
+	match := normalizePhone(reported) == key
+	if match {
+		d.Confirmed++
+		d.LastConfirmed = now.UTC()
+		m.sendedConfirmed++
+	} else {
+		d.Mismatched++
+		m.sendedMismatched++
+	}
+	m.mu.Unlock()
+
+	if !match {
+		log.Printf("[SOCKET] 'sended' names a different phone than dispatched, gateway bug? | id=%s | device=%s | expected=%s | reported=%s",
+			id, c.device, logging.Phone(expected), logging.Phone(reported))
+	}
+	return tenant, reported
+}
+
+// Delivery returns the delivery stats recorded for phone in the last
+// deliveryRetention.
+func (m *Manager) Delivery(phone string) (PhoneDelivery, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[normalizePhone(phone)]
+	if !ok {
+		return PhoneDelivery{}, false
+	}
+	return *d, true
+}
+
+// pruneDeliveries drops per-phone stats not updated within
+// deliveryRetention.
+func (m *Manager) pruneDeliveries() {
+	cutoff := time.Now().Add(-deliveryRetention)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, d := range m.deliveries {
+		if d.lastSeen.Before(cutoff) {
+			delete(m.deliveries, k)
+		}
+	}
+}
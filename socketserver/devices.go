@@ -0,0 +1,78 @@
+package socketserver
+
+import (
+	"errors"
+	"log"
+)
+
+// errDeviceLimit rejects a connection whose device ID already has
+// cfg.SocketMaxPerDevice connections.
+var errDeviceLimit = errors.New("too many connections for device")
+
+// deviceKey identifies a device within its tenant.
+func deviceKey(tenant, device string) string {
+	return tenant + "\x00" + device
+}
+
+// admitDevice records connection id for the device and applies
+// cfg.SocketMaxPerDevice. It returns the older connections to evict to make
+// room, or ok=false when the new connection must be rejected instead.
+func (m *Manager) admitDevice(tenant, device, id string) (evict []string, ok bool) {
+	cfg := m.cfg.Get()
+	key := deviceKey(tenant, device)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := m.devices[key]
+	if max := cfg.SocketMaxPerDevice; max > 0 && len(ids) >= max {
+		if cfg.SocketDeviceLimitMode == "reject" {
+			log.Printf("[SOCKET] Device connection limit reached, rejecting new connection | id=%s | tenant=%s | device=%s | connections=%d | max=%d",
+				id, tenant, device, len(ids), max)
+			return nil, false
+		}
+		n := len(ids) - max + 1
+		evict = append(evict, ids[:n]...)
+		ids = ids[n:]
+		log.Printf("[SOCKET] Device connection limit reached, evicting oldest | id=%s | tenant=%s | device=%s | evicted=%v | max=%d",
+			id, tenant, device, evict, max)
+	}
+	m.devices[key] = append(ids, id)
+	return evict, true
+}
+
+// forgetDevice drops connection id from its device's entry.
+func (m *Manager) forgetDevice(tenant, device, id string) {
+	key := deviceKey(tenant, device)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := m.devices[key]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(m.devices, key)
+		return
+	}
+	m.devices[key] = ids
+}
+
+// evict closes a connection displaced by a newer one from the same device.
+// Events queued for it are handed to the rest of its tenant, which includes
+// the newer connection.
+func (m *Manager) evict(id string) {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, _ := m.detach(sh, id)
+	sh.mu.Unlock()
+	if c == nil {
+		return
+	}
+	if len(c.queue) > 0 {
+		m.requeue(c.tenant, c.queue)
+	}
+	if err := c.conn.Close(); err != nil {
+		log.Printf("[SOCKET] Failed to close evicted connection | id=%s | device=%s | error=%v", id, c.device, err)
+	}
+}
@@ -0,0 +1,62 @@
+package socketserver
+
+import (
+	"log"
+	"runtime/debug"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// EventHandler processes one inbound Socket.IO event.
+type EventHandler func(s socketio.Conn, event string, data interface{})
+
+// EventMiddleware wraps an EventHandler with cross-cutting logic (metrics,
+// auth checks, validation, logging). It short-circuits an event by returning
+// without calling next.
+type EventMiddleware func(next EventHandler) EventHandler
+
+// Use appends middleware to the chain run for every inbound event.
+// Middleware runs in registration order, outermost first.
+func (m *Manager) Use(mw ...EventMiddleware) {
+	m.mu.Lock()
+	m.middleware = append(m.middleware, mw...)
+	m.mu.Unlock()
+}
+
+// handleEvent registers h for event on the root namespace, routed through
+// the middleware chain. The chain is resolved per event so middleware added
+// with Use after startup still applies.
+func (m *Manager) handleEvent(event string, h EventHandler) {
+	m.mu.Lock()
+	m.events[event] = true
+	m.mu.Unlock()
+
+	m.Server.OnEvent("/", event, safeHandler(event, m.runPanicHooks, func(s socketio.Conn, data interface{}) {
+		m.mu.Lock()
+		chain := make([]EventMiddleware, len(m.middleware))
+		copy(chain, m.middleware)
+		m.mu.Unlock()
+
+		wrapped := h
+		for i := len(chain) - 1; i >= 0; i-- {
+			wrapped = chain[i](wrapped)
+		}
+		wrapped(s, event, data)
+	}))
+}
+
+// recoverEvents keeps a panicking event handler from taking down the
+// connection's read goroutine, and with it the whole process. The panic
+// hooks see every panic it recovers.
+func (m *Manager) recoverEvents(next EventHandler) EventHandler {
+	return func(s socketio.Conn, event string, data interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[SOCKET][PANIC] Event handler panicked | id=%s | event=%s | panic=%v\nstack:\n%s",
+					s.ID(), event, r, debug.Stack())
+				m.runPanicHooks(r)
+			}
+		}()
+		next(s, event, data)
+	}
+}
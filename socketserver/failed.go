@@ -0,0 +1,108 @@
+package socketserver
+
+import (
+	"log"
+	"runtime/debug"
+
+	"sms_service/logging"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// SendFailedEvent is sent by a gateway that could not deliver the message
+// it was handed. The payload is optional: a reason string, or an object
+// with "reason" and the "phone" it failed for.
+const SendFailedEvent = "send_failed"
+
+// FailedSend describes a message a gateway reported it could not deliver.
+type FailedSend struct {
+	ClientID string
+	Tenant   string
+	Reason   string
+	// Phone is the recipient: the one the gateway was dispatched, or the
+	// one it reported.
+	Phone string
+	// Event and Data are the message as dispatched, for handing it to
+	// another gateway. Data is nil when nothing was dispatched to the
+	// client (broadcast mode), so there is nothing to re-send.
+	Event string
+	Data  interface{}
+}
+
+// OnSendFailed registers fn to run when a gateway reports "send_failed". It
+// runs before the gateway is freed; fn re-sends through DispatchExcept or
+// EmitToAvailableExcept so the failed gateway is not picked again.
+func (m *Manager) OnSendFailed(fn func(FailedSend)) {
+	m.mu.Lock()
+	m.failedHooks = append(m.failedHooks, fn)
+	m.mu.Unlock()
+}
+
+// sendFailed handles "send_failed": it takes the client's in-flight message,
+// passes it to the failure hooks and frees the client for its next one.
+func (m *Manager) sendFailed(s socketio.Conn, event string, data interface{}) {
+	if perr := validateSended(data); perr != nil {
+		perr.Event = event
+		m.rejectPayload(s, perr)
+		data = nil
+	}
+	f := FailedSend{ClientID: s.ID(), Reason: failureReason(data), Phone: sendedPhone(data)}
+	if _, ok := data.(string); ok {
+		// A bare string is the reason, not a phone.
+		f.Phone = ""
+	}
+
+	sh := m.clients.shard(s.ID())
+	sh.mu.Lock()
+	c, ok := sh.clients[s.ID()]
+	if !ok {
+		sh.mu.Unlock()
+		log.Printf("[SOCKET] Event 'send_failed' from unknown client | id=%s | remote=%s | data=%v",
+			s.ID(), s.RemoteAddr(), logging.Payload(data))
+		return
+	}
+	f.Tenant = c.tenant
+	if c.inflight != "" {
+		f.Phone = c.inflight
+	}
+	f.Event, f.Data = c.current.event, c.current.data
+	c.inflight = ""
+	c.current = queued{}
+	sh.mu.Unlock()
+
+	log.Printf("[SOCKET] Gateway reported send failure | id=%s | tenant=%s | phone=%s | event=%s | reason=%q",
+		f.ClientID, f.Tenant, logging.Phone(f.Phone), f.Event, f.Reason)
+	m.runFailedHooks(f)
+	m.next(s.ID())
+}
+
+// failureReason extracts the reason from a "send_failed" payload.
+func failureReason(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if r, ok := v["reason"].(string); ok {
+			return r
+		}
+	}
+	return ""
+}
+
+// runFailedHooks calls every send-failed hook. A panicking hook is logged
+// and does not stop the others.
+func (m *Manager) runFailedHooks(f FailedSend) {
+	m.mu.Lock()
+	hooks := m.failedHooks
+	m.mu.Unlock()
+	for _, fn := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[SOCKET][PANIC] Send-failed hook panicked | id=%s | panic=%v\nstack:\n%s", f.ClientID, r, debug.Stack())
+				}
+			}()
+			fn(f)
+		}()
+	}
+}
@@ -0,0 +1,104 @@
+package socketserver
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"sms_service/config"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// emitted is one event a fakeConn was sent.
+type emitted struct {
+	event string
+	data  interface{}
+}
+
+// fakeConn is a socketio.Conn that records emits instead of writing to a
+// transport. Methods the Manager does not use on it fall through to the nil
+// embedded Conn and panic.
+type fakeConn struct {
+	socketio.Conn
+	id string
+	// panics makes Emit panic, as go-socket.io can on a closing connection.
+	panics bool
+	// ack, when set, is passed at once to the ack callback of an emit that
+	// has one.
+	ack interface{}
+
+	mu   sync.Mutex
+	sent []emitted
+}
+
+func (c *fakeConn) ID() string { return c.id }
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+func (c *fakeConn) Join(string)  {}
+func (c *fakeConn) Leave(string) {}
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Emit(event string, v ...interface{}) {
+	if c.panics {
+		panic("write on closed connection")
+	}
+	var data interface{}
+	if len(v) > 0 {
+		data = v[0]
+	}
+	if cb, ok := v[len(v)-1].(func(interface{})); ok && c.ack != nil {
+		defer cb(c.ack)
+	}
+	c.mu.Lock()
+	c.sent = append(c.sent, emitted{event: event, data: data})
+	c.mu.Unlock()
+}
+
+// events returns what the connection was sent so far.
+func (c *fakeConn) events() []emitted {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]emitted(nil), c.sent...)
+}
+
+// newTestManager returns a Manager built from the environment (set overrides
+// with t.Setenv first). Its Socket.IO server is not started.
+func newTestManager(tb testing.TB) *Manager {
+	tb.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return NewManager(config.NewLive(cfg))
+}
+
+// addClient registers a fake connection as a client of tenant, the way
+// OnConnect does, and returns it.
+func addClient(m *Manager, id, tenant string) *fakeConn {
+	conn := &fakeConn{id: id}
+	now := time.Now().UTC()
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	sh.clients[id] = &client{
+		id:          id,
+		conn:        conn,
+		tenant:      tenant,
+		remoteAddr:  conn.RemoteAddr().String(),
+		connectedAt: now,
+		lastEventAt: now,
+	}
+	m.gauges.connected.Add(1)
+	sh.mu.Unlock()
+	return conn
+}
+
+// updateClient changes a connected client's fields under its shard lock.
+func updateClient(m *Manager, id string, fn func(c *client)) {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	fn(sh.clients[id])
+	sh.mu.Unlock()
+}
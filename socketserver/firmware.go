@@ -0,0 +1,89 @@
+package socketserver
+
+import (
+	"strconv"
+	"strings"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// ackMessageType is the SocketMinFirmware key for sends that wait on a
+// Socket.IO ack.
+const ackMessageType = "ack"
+
+// firmwareOf returns the firmware version a gateway sent in its handshake
+// (firmware query parameter or X-Firmware-Version header), if any.
+func firmwareOf(s socketio.Conn) string {
+	u := s.URL()
+	if v := u.Query().Get("firmware"); v != "" {
+		return v
+	}
+	return s.RemoteHeader().Get("X-Firmware-Version")
+}
+
+// minFirmware returns the lowest firmware version that may receive data,
+// "" when any may. The requirement is looked up by the message category
+// and, when ack is set, also by "ack"; the higher of the two applies.
+func (m *Manager) minFirmware(data interface{}, ack bool) string {
+	rules := m.cfg.Get().SocketMinFirmware
+	if len(rules) == 0 {
+		return ""
+	}
+	min := ""
+	if ev, ok := data.(OTPEvent); ok {
+		min = rules[ev.Category]
+	}
+	if v := rules[ackMessageType]; ack && v != "" && !versionAtLeast(min, v) {
+		min = v
+	}
+	return min
+}
+
+// versionAtLeast reports whether version v is at least min, comparing
+// dot-separated numeric parts ("1.10" > "1.9"; a missing part counts as 0).
+// An empty min is always met; an empty or unparseable v never meets a
+// non-empty one.
+func versionAtLeast(v, min string) bool {
+	if min == "" {
+		return true
+	}
+	have, ok := parseVersion(v)
+	if !ok {
+		return false
+	}
+	want, ok := parseVersion(min)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(have) || i < len(want); i++ {
+		var a, b int
+		if i < len(have) {
+			a = have[i]
+		}
+		if i < len(want) {
+			b = want[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
+
+// parseVersion splits "v1.2.3" into its numeric parts.
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	out := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
@@ -0,0 +1,80 @@
+package socketserver
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// touchEvents records when each client last sent any Socket.IO event.
+func (m *Manager) touchEvents(next EventHandler) EventHandler {
+	return func(s socketio.Conn, event string, data interface{}) {
+		sh := m.clients.shard(s.ID())
+		sh.mu.Lock()
+		if c, ok := sh.clients[s.ID()]; ok {
+			c.lastEventAt = time.Now().UTC()
+		}
+		sh.mu.Unlock()
+		next(s, event, data)
+	}
+}
+
+// CheckIdle logs clients that have sent no event for cfg.SocketIdleTimeout
+// and, with cfg.SocketIdleDisconnect, closes them, returning how many were
+// found. Engine.io pings are not events, so a gateway that only answers
+// pings counts as idle: these are the zombie connections the check is for.
+func (m *Manager) CheckIdle() int {
+	cfg := m.cfg.Get()
+	if cfg.SocketIdleTimeout <= 0 {
+		return 0
+	}
+	now := time.Now()
+	cutoff := now.Add(-cfg.SocketIdleTimeout)
+	type idleClient struct {
+		id, tenant, device string
+		lastEventAt        time.Time
+	}
+	var idle []idleClient
+	m.clients.each(func(c *client) bool {
+		if c.lastEventAt.Before(cutoff) {
+			idle = append(idle, idleClient{c.id, c.tenant, c.device, c.lastEventAt})
+		}
+		return true
+	})
+
+	for _, c := range idle {
+		log.Printf("[SOCKET] Idle client | id=%s | tenant=%s | device=%s | idle=%s | disconnect=%t",
+			c.id, c.tenant, c.device, now.Sub(c.lastEventAt).Round(time.Second), cfg.SocketIdleDisconnect)
+		if cfg.SocketIdleDisconnect {
+			m.evict(c.id)
+		}
+	}
+	return len(idle)
+}
+
+// RunIdleMonitor calls CheckIdle every interval until ctx is done.
+// A non-positive interval disables the monitor.
+func (m *Manager) RunIdleMonitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[SOCKET][PANIC] Idle monitor panicked | panic=%v\nstack:\n%s", r, debug.Stack())
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CheckIdle()
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package socketserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/googollee/go-socket.io/engineio/frame"
+	"github.com/googollee/go-socket.io/engineio/packet"
+	"github.com/googollee/go-socket.io/engineio/transport"
+)
+
+const (
+	// eventPeekBytes is how much of each inbound message is buffered to find
+	// the event name; the rest is streamed through untouched.
+	eventPeekBytes = 256
+	// maxTrackedEventNames bounds the per-name unknown-event counters so a
+	// client inventing names cannot grow the map without limit.
+	maxTrackedEventNames = 100
+)
+
+// go-socket.io silently drops events that have no registered handler, so
+// there is no hook to see them. inspectTransport wraps an engine.io transport
+// and peeks at every inbound Socket.IO message to learn its event name before
+// go-socket.io parses it.
+type inspectTransport struct {
+	transport.Transport
+	m *Manager
+}
+
+func (t *inspectTransport) Accept(w http.ResponseWriter, r *http.Request) (transport.Conn, error) {
+	conn, err := t.Transport.Accept(w, r)
+	if err != nil {
+		return nil, err
+	}
+	ic := &inspectConn{Conn: conn, m: t.m}
+
+	// engine.io type-asserts transport conns for http.Handler (to serve
+	// requests) and Pauser (to upgrade away from polling), so the wrapper
+	// must expose exactly the capabilities of what it wraps.
+	h, isHandler := conn.(http.Handler)
+	p, isPauser := conn.(pauser)
+	switch {
+	case isHandler && isPauser:
+		return &inspectPollingConn{inspectConn: ic, handler: h, pauser: p}, nil
+	case isHandler:
+		return &inspectHandlerConn{inspectConn: ic, handler: h}, nil
+	default:
+		return ic, nil
+	}
+}
+
+type pauser interface {
+	Pause()
+	Resume()
+}
+
+// inspectConn reports the event name of each inbound message to the Manager.
+type inspectConn struct {
+	transport.Conn
+	m *Manager
+
+	mu         sync.Mutex
+	disallowed int
+}
+
+func (c *inspectConn) NextReader() (frame.Type, packet.Type, io.ReadCloser, error) {
+	ft, pt, r, err := c.Conn.NextReader()
+	if err != nil || pt != packet.MESSAGE || ft != frame.String {
+		return ft, pt, r, err
+	}
+
+	buf := make([]byte, eventPeekBytes)
+	n, readErr := io.ReadFull(r, buf)
+	if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+		_ = r.Close()
+		return ft, pt, nil, readErr
+	}
+	buf = buf[:n]
+
+	if name, ok := eventName(buf); ok {
+		c.m.observeEvent(c, name)
+	}
+	return ft, pt, &peekedReader{Reader: io.MultiReader(bytes.NewReader(buf), r), closer: r}, nil
+}
+
+type inspectHandlerConn struct {
+	*inspectConn
+	handler http.Handler
+}
+
+func (c *inspectHandlerConn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+type inspectPollingConn struct {
+	*inspectConn
+	handler http.Handler
+	pauser  pauser
+}
+
+func (c *inspectPollingConn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+func (c *inspectPollingConn) Pause()  { c.pauser.Pause() }
+func (c *inspectPollingConn) Resume() { c.pauser.Resume() }
+
+type peekedReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r *peekedReader) Close() error { return r.closer.Close() }
+
+// eventName extracts the event name from the start of a Socket.IO packet:
+// <type>[<attachments>-][/<namespace>,][<ack id>]["name", ...]
+// Only EVENT (2) and BINARY_EVENT (5) packets carry one.
+func eventName(b []byte) (string, bool) {
+	if len(b) == 0 || (b[0] != '2' && b[0] != '5') {
+		return "", false
+	}
+	i := 1
+	if b[0] == '5' {
+		for i < len(b) && b[i] != '-' {
+			i++
+		}
+		i++
+	}
+	if i < len(b) && b[i] == '/' {
+		for i < len(b) && b[i] != ',' {
+			i++
+		}
+		i++
+	}
+	for i < len(b) && b[i] >= '0' && b[i] <= '9' {
+		i++
+	}
+	if i >= len(b) || b[i] != '[' {
+		return "", false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b[i:]))
+	if _, err := dec.Token(); err != nil {
+		return "", false
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return "", false
+	}
+	name, ok := tok.(string)
+	return name, ok
+}
+
+// observeEvent counts and logs events outside the allowlist and, when
+// configured, disconnects clients that keep sending them.
+func (m *Manager) observeEvent(c *inspectConn, name string) {
+	m.mu.Lock()
+	allowed := m.events[name]
+	if len(m.cfg.Get().SocketAllowedEvents) > 0 {
+		allowed = false
+		for _, e := range m.cfg.Get().SocketAllowedEvents {
+			if e == name {
+				allowed = true
+				break
+			}
+		}
+	}
+	if allowed {
+		m.mu.Unlock()
+		return
+	}
+	m.unknownEvents++
+	key := name
+	if _, tracked := m.unknownEventNames[key]; !tracked && len(m.unknownEventNames) >= maxTrackedEventNames {
+		key = "_other"
+	}
+	m.unknownEventNames[key]++
+	m.mu.Unlock()
+
+	if m.cfg.Get().LogDebug {
+		log.Printf("[SOCKET][DEBUG] Disallowed event received | event=%q | remote=%s", name, c.RemoteAddr())
+	}
+
+	limit := m.cfg.Get().SocketMaxDisallowedEvents
+	if limit <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.disallowed++
+	over := c.disallowed >= limit
+	c.mu.Unlock()
+	if over {
+		log.Printf("[SOCKET] Too many disallowed events, disconnecting | remote=%s | limit=%d | last_event=%q",
+			c.RemoteAddr(), limit, name)
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,68 @@
+package socketserver
+
+import (
+	"fmt"
+	"log"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// ErrorEvent is emitted back to a client whose payload was rejected, for
+// events listed in cfg.SocketErrorEvents.
+const ErrorEvent = "error"
+
+// Codes carried in PayloadError.Code.
+const (
+	PayloadInvalidType  = "invalid_type"
+	PayloadInvalidField = "invalid_field"
+)
+
+// PayloadError describes what was wrong with an inbound payload. It is also
+// the body of ErrorEvent.
+type PayloadError struct {
+	Event   string `json:"event"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// rejectPayload logs a rejected payload and, when its event opted in, tells
+// the client why.
+func (m *Manager) rejectPayload(s socketio.Conn, perr *PayloadError) {
+	notify := false
+	for _, e := range m.cfg.Get().SocketErrorEvents {
+		if e == perr.Event {
+			notify = true
+			break
+		}
+	}
+	log.Printf("[SOCKET] Malformed payload rejected | id=%s | remote=%s | event=%s | code=%s | error=%s | notified=%t",
+		s.ID(), s.RemoteAddr(), perr.Event, perr.Code, perr.Message, notify)
+	if notify {
+		if err := emitSafe(s, ErrorEvent, perr); err != nil {
+			log.Printf("[SOCKET] Failed to report payload error | id=%s | event=%s | error=%v", s.ID(), perr.Event, err)
+		}
+	}
+}
+
+// validateSended accepts the "sended" payloads gateways send: nothing, the
+// phone as a string, or an object whose "phone", if present, is a string.
+// Event is left for the caller to fill in.
+func validateSended(data interface{}) *PayloadError {
+	switch v := data.(type) {
+	case nil, string:
+		return nil
+	case map[string]interface{}:
+		if p, ok := v["phone"]; ok {
+			if _, ok := p.(string); !ok {
+				return &PayloadError{Code: PayloadInvalidField, Message: `"phone" must be a string`}
+			}
+		}
+		return nil
+	default:
+		return &PayloadError{Code: PayloadInvalidType, Message: fmt.Sprintf("payload must be a string or an object, got %T", data)}
+	}
+}
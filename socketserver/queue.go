@@ -0,0 +1,239 @@
+package socketserver
+
+import (
+	"errors"
+	"log"
+
+	"sms_service/logging"
+)
+
+// ErrQueueFull is returned by Dispatch when every eligible client's queue is
+// at capacity.
+var ErrQueueFull = errors.New("all client send queues are full")
+
+// ErrNoAvailableClient is returned by EmitToAvailable when every eligible
+// client is busy or draining.
+var ErrNoAvailableClient = errors.New("no idle socket client available")
+
+// Route identifies the gateway a single-recipient event was handed to.
+type Route struct {
+	ClientID   string
+	Device     string
+	RemoteAddr string
+}
+
+// route returns c's Route. c's fields used here never change after connect.
+func (c *client) route() Route {
+	return Route{ClientID: c.id, Device: c.device, RemoteAddr: c.remoteAddr}
+}
+
+// queued is one event waiting for its client to finish the previous one.
+type queued struct {
+	event string
+	data  interface{}
+}
+
+// Dispatch sends an event to exactly one client of tenant, one message at a
+// time per device. An idle client gets the event immediately and is marked
+// busy; otherwise the event joins the FIFO queue of the least-loaded busy
+// client and goes out when that client reports "sended". A client whose
+// queue holds cfg.SocketQueueSize events overflows to the next one;
+// ErrQueueFull means every queue is full and ErrNoClients that none is
+// eligible. It returns the Route of the client the event was assigned to.
+func (m *Manager) Dispatch(tenant, event string, data interface{}) (Route, error) {
+	return m.DispatchExcept(tenant, "", event, data)
+}
+
+// DispatchExcept is Dispatch with client exclude left out of the choice, for
+// re-sending a message its gateway could not send. An empty exclude leaves
+// no one out.
+func (m *Manager) DispatchExcept(tenant, exclude, event string, data interface{}) (Route, error) {
+	for {
+		c, queuedAt, err := m.assign(tenant, exclude, event, data)
+		if err != nil {
+			return Route{}, err
+		}
+		if queuedAt > 0 {
+			log.Printf("[SOCKET] Client busy, event queued | id=%s | event=%s | position=%d", c.id, event, queuedAt)
+			return c.route(), nil
+		}
+		if err := emitSafe(c.conn, event, data); err != nil {
+			log.Printf("[SOCKET] Emit failed, dropping client | id=%s | event=%s | error=%v", c.id, event, err)
+			m.remove(c.id)
+			continue
+		}
+		log.Printf("[SOCKET] Event dispatched | id=%s | event=%s | data=%v", c.id, event, logging.Payload(data))
+		return c.route(), nil
+	}
+}
+
+// EmitToAvailable sends an event to the first idle client, marks it busy
+// until it reports "sended" and returns its ID. Unlike Dispatch it never
+// queues: with no idle client it returns ErrNoAvailableClient (ErrNoClients
+// when none is connected or eligible), so the caller can answer 503. Like
+// Emit it does not look at tenants; tenant-scoped sends go through Dispatch.
+func (m *Manager) EmitToAvailable(event string, data interface{}) (string, error) {
+	return m.EmitToAvailableExcept("", event, data)
+}
+
+// EmitToAvailableExcept is EmitToAvailable with client exclude left out of
+// the choice, as DispatchExcept is for Dispatch.
+func (m *Manager) EmitToAvailableExcept(exclude, event string, data interface{}) (string, error) {
+	min := m.minFirmware(data, false)
+	for {
+		var idle *client
+		eligible := 0
+		m.clients.each(func(c *client) bool {
+			if c.draining || c.id == exclude || !versionAtLeast(c.firmware, min) {
+				return true
+			}
+			eligible++
+			if !c.busy {
+				m.claim(c, event, data)
+				idle = c
+				return false
+			}
+			return true
+		})
+		switch {
+		case idle != nil:
+		case eligible == 0:
+			return "", ErrNoClients
+		default:
+			log.Printf("[SOCKET] No idle client available | event=%s | busy=%d", event, eligible)
+			return "", ErrNoAvailableClient
+		}
+		if err := emitSafe(idle.conn, event, data); err != nil {
+			log.Printf("[SOCKET] Emit failed, dropping client | id=%s | event=%s | error=%v", idle.id, event, err)
+			m.remove(idle.id)
+			continue
+		}
+		log.Printf("[SOCKET] Event sent to idle client | id=%s | event=%s | data=%v", idle.id, event, logging.Payload(data))
+		return idle.id, nil
+	}
+}
+
+// assign picks the client for one event. It returns the client with
+// queuedAt == 0 when the caller must emit now (the client has been marked
+// busy), or the 1-based queue position when it was queued.
+//
+// Clients below the event's minimum firmware, and exclude, are not
+// eligible. An idle client is claimed while its shard is locked. Otherwise
+// the shortest queue is chosen across shards, so it is re-checked under its
+// shard lock before appending and the pick is retried if it went away.
+func (m *Manager) assign(tenant, exclude, event string, data interface{}) (*client, int, error) {
+	limit := m.cfg.Get().SocketQueueSize
+	min := m.minFirmware(data, false)
+	for {
+		var idle, best *client
+		bestLen, eligible, outdated := 0, 0, 0
+		m.clients.each(func(c *client) bool {
+			if c.tenant != tenant || c.draining || c.id == exclude {
+				return true
+			}
+			if !versionAtLeast(c.firmware, min) {
+				outdated++
+				return true
+			}
+			eligible++
+			if !c.busy {
+				m.claim(c, event, data)
+				idle = c
+				return false
+			}
+			if n := len(c.queue); n < limit && (best == nil || n < bestLen) {
+				best, bestLen = c, n
+			}
+			return true
+		})
+		switch {
+		case idle != nil:
+			return idle, 0, nil
+		case eligible == 0:
+			if outdated > 0 {
+				log.Printf("[SOCKET] No client meets minimum firmware | tenant=%s | event=%s | min_firmware=%s | skipped=%d",
+					tenant, event, min, outdated)
+			}
+			return nil, 0, ErrNoClients
+		case best == nil:
+			return nil, 0, ErrQueueFull
+		}
+
+		sh := m.clients.shard(best.id)
+		sh.mu.Lock()
+		if sh.clients[best.id] != best || best.draining || len(best.queue) >= limit {
+			sh.mu.Unlock()
+			continue
+		}
+		if !best.busy {
+			m.claim(best, event, data)
+			sh.mu.Unlock()
+			return best, 0, nil
+		}
+		best.queue = append(best.queue, queued{event: event, data: data})
+		m.gauges.queued.Add(1)
+		pos := len(best.queue)
+		sh.mu.Unlock()
+		return best, pos, nil
+	}
+}
+
+// claim marks an idle client busy with the event carrying data. Callers
+// must hold c's shard lock.
+func (m *Manager) claim(c *client, event string, data interface{}) {
+	c.busy = true
+	c.inflight = phoneOf(data)
+	c.current = queued{event: event, data: data}
+	m.gauges.busy.Add(1)
+}
+
+// next is called when a client reports "sended": it sends the client's next
+// queued event, or marks the client available when its queue is empty.
+func (m *Manager) next(id string) {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, ok := sh.clients[id]
+	if !ok {
+		sh.mu.Unlock()
+		return
+	}
+	// Draining clients still work through what was already queued for them.
+	if len(c.queue) == 0 {
+		if c.busy {
+			c.busy = false
+			m.gauges.busy.Add(-1)
+		}
+		sh.mu.Unlock()
+		return
+	}
+	q := c.queue[0]
+	c.queue = c.queue[1:]
+	c.inflight = phoneOf(q.data)
+	c.current = q
+	m.gauges.queued.Add(-1)
+	remaining := len(c.queue)
+	sh.mu.Unlock()
+
+	if err := emitSafe(c.conn, q.event, q.data); err != nil {
+		log.Printf("[SOCKET] Emit failed, dropping client | id=%s | event=%s | error=%v", id, q.event, err)
+		sh.mu.Lock()
+		c.queue = append([]queued{q}, c.queue...)
+		m.gauges.queued.Add(1)
+		sh.mu.Unlock()
+		m.remove(id)
+		return
+	}
+	log.Printf("[SOCKET] Queued event dispatched | id=%s | event=%s | remaining=%d", id, q.event, remaining)
+}
+
+// requeue hands the queued events of a departed client to the rest of its
+// tenant, in their original order. Events no client can take are dropped
+// and logged.
+func (m *Manager) requeue(tenant string, pending []queued) {
+	for _, q := range pending {
+		if _, err := m.Dispatch(tenant, q.event, q.data); err != nil {
+			log.Printf("[SOCKET] Queued event lost | tenant=%s | event=%s | error=%v | data=%v",
+				tenant, q.event, err, logging.Payload(q.data))
+		}
+	}
+}
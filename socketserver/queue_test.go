@@ -0,0 +1,125 @@
+package socketserver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDispatchKeepsOrderUnderConcurrentEnqueues(t *testing.T) {
+	const workers, perWorker = 8, 50
+	t.Setenv("SOCKET_QUEUE_SIZE", fmt.Sprint(workers*perWorker))
+	m := newTestManager(t)
+	conn := addClient(m, "gw", "")
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for seq := 0; seq < perWorker; seq++ {
+				if _, err := m.Dispatch("", "otp", [2]int{w, seq}); err != nil {
+					t.Errorf("worker %d message %d: %v", w, seq, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Only the first message is out; each "sended" releases the next.
+	if got := len(conn.events()); got != 1 {
+		t.Fatalf("%d messages sent to a busy client, want 1", got)
+	}
+	for i := 1; i < workers*perWorker; i++ {
+		m.next("gw")
+	}
+
+	sent := conn.events()
+	if len(sent) != workers*perWorker {
+		t.Fatalf("sent %d messages, want %d", len(sent), workers*perWorker)
+	}
+	last := make([]int, workers)
+	for i := range last {
+		last[i] = -1
+	}
+	for _, e := range sent {
+		msg := e.data.([2]int)
+		w, seq := msg[0], msg[1]
+		if seq != last[w]+1 {
+			t.Fatalf("worker %d: message %d sent after %d", w, seq, last[w])
+		}
+		last[w] = seq
+	}
+
+	m.next("gw")
+	if st := m.Stats(); st.Busy != 0 || st.Queued != 0 {
+		t.Fatalf("after the last sended: busy=%d queued=%d, want 0", st.Busy, st.Queued)
+	}
+}
+
+func TestDispatchOverflowsFullQueues(t *testing.T) {
+	t.Setenv("SOCKET_QUEUE_SIZE", "2")
+	m := newTestManager(t)
+	a := addClient(m, "a", "")
+	b := addClient(m, "b", "")
+
+	// Two go straight out, then each client queues two.
+	for i := 0; i < 6; i++ {
+		if _, err := m.Dispatch("", "otp", i); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	if _, err := m.Dispatch("", "otp", 6); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("error = %v, want ErrQueueFull", err)
+	}
+	if st := m.Stats(); st.Busy != 2 || st.Queued != 4 {
+		t.Fatalf("busy=%d queued=%d, want 2 and 4", st.Busy, st.Queued)
+	}
+
+	m.next("a")
+	if _, err := m.Dispatch("", "otp", 7); err != nil {
+		t.Fatalf("after a sended: %v", err)
+	}
+	if got := len(a.events()) + len(b.events()); got != 3 {
+		t.Fatalf("%d messages sent, want 3", got)
+	}
+}
+
+func TestRedispatchSkipsFailedGateway(t *testing.T) {
+	t.Setenv("SOCKET_QUEUE_SIZE", "2")
+	m := newTestManager(t)
+	conns := map[string]*fakeConn{"a": addClient(m, "a", ""), "b": addClient(m, "b", "")}
+	m.OnSendFailed(func(f FailedSend) {
+		if _, err := m.DispatchExcept(f.Tenant, f.ClientID, f.Event, f.Data); err != nil {
+			t.Errorf("redispatch: %v", err)
+		}
+	})
+
+	// Both gateways busy, so without the exclusion the failed message could
+	// queue right back on the gateway that failed it.
+	failed, err := m.Dispatch("", "otp", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.Dispatch("", "otp", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.sendFailed(conns[failed.ClientID], SendFailedEvent, "no signal")
+	m.next(other.ClientID)
+
+	if got := conns[failed.ClientID].events(); len(got) != 1 {
+		t.Fatalf("failed gateway got %v, want only the message it failed", got)
+	}
+	got := conns[other.ClientID].events()
+	if len(got) != 2 || got[1].data != "first" {
+		t.Fatalf("other gateway got %v, want the failed message after its own", got)
+	}
+
+	// With the only idle gateway excluded there is no one to take it.
+	if _, err := m.EmitToAvailableExcept(failed.ClientID, "otp", "third"); !errors.Is(err, ErrNoAvailableClient) {
+		t.Fatalf("EmitToAvailableExcept error = %v, want ErrNoAvailableClient", err)
+	}
+}
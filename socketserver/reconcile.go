@@ -0,0 +1,66 @@
+package socketserver
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Reconcile removes clients from the map whose connection no longer exists
+// in go-socket.io (e.g. a disconnect callback was missed or a panic skipped
+// cleanup), returning how many were removed.
+//
+// go-socket.io joins every connection to a room named after its own ID and
+// leaves all rooms on close, so an empty room means the connection is gone.
+func (m *Manager) Reconcile() int {
+	targets := m.snapshot(func(*client) bool { return true })
+
+	removed := 0
+	for _, c := range targets {
+		if m.Server.RoomLen("/", c.id) > 0 {
+			continue
+		}
+		if m.clients.has(c.id) {
+			m.mu.Lock()
+			m.staleRemoved++
+			m.mu.Unlock()
+			removed++
+			m.remove(c.id)
+			log.Printf("[SOCKET] Reconcile: removed stale client | id=%s | tenant=%s", c.id, c.tenant)
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("[SOCKET] Reconcile finished | removed=%d | tracked_clients=%d | engineio_sessions=%d",
+			removed, len(targets)-removed, m.Server.Count())
+	}
+	return removed
+}
+
+// RunReconciler calls Reconcile and SweepSessions, and prunes stale
+// delivery stats, every interval until ctx is done.
+// A non-positive interval disables reconciliation.
+func (m *Manager) RunReconciler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[SOCKET][PANIC] Reconciler panicked | panic=%v\nstack:\n%s", r, debug.Stack())
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Reconcile()
+			m.SweepSessions()
+			m.pruneDeliveries()
+		}
+	}
+}
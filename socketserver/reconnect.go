@@ -0,0 +1,113 @@
+package socketserver
+
+import (
+	"log"
+	"time"
+)
+
+// parkedQueue holds the queue of a device that dropped for a transient
+// reason, waiting for the same device to come back.
+type parkedQueue struct {
+	tenant  string
+	pending []queued
+	timer   *time.Timer
+}
+
+// transient reports whether a disconnect reason is configured as one the
+// client is expected to recover from by reconnecting.
+func (m *Manager) transient(reason string) bool {
+	for _, r := range m.cfg.Get().SocketTransientReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// disconnect removes a client after go-socket.io reports it gone. When the
+// reason is transient, the client identified itself with a device ID and a
+// reconnect grace period is configured, its queued events are held for that
+// device instead of being handed to other clients straight away.
+func (m *Manager) disconnect(id, reason string) int {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, count := m.detach(sh, id)
+	sh.mu.Unlock()
+	if c == nil {
+		return count
+	}
+
+	grace := m.cfg.Get().SocketReconnectGrace
+	if len(c.queue) == 0 {
+		return count
+	}
+	if grace <= 0 || c.device == "" || !m.transient(reason) {
+		log.Printf("[SOCKET] Disconnect treated as permanent, requeueing | id=%s | device=%s | reason=%q | pending=%d",
+			id, c.device, reason, len(c.queue))
+		m.requeue(c.tenant, c.queue)
+		return count
+	}
+
+	key := deviceKey(c.tenant, c.device)
+	m.mu.Lock()
+	p, ok := m.parked[key]
+	if !ok {
+		p = &parkedQueue{tenant: c.tenant}
+		m.parked[key] = p
+		p.timer = time.AfterFunc(grace, func() { m.expireParked(key) })
+	}
+	p.pending = append(p.pending, c.queue...)
+	m.mu.Unlock()
+	log.Printf("[SOCKET] Disconnect treated as transient, holding queue | id=%s | device=%s | reason=%q | pending=%d | grace=%s",
+		id, c.device, reason, len(c.queue), grace)
+	return count
+}
+
+// adoptParked hands a reconnecting device the queue held for it and starts
+// sending it. The caller must not hold any lock.
+func (m *Manager) adoptParked(id string) {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, ok := sh.clients[id]
+	if !ok || c.device == "" {
+		sh.mu.Unlock()
+		return
+	}
+	key := deviceKey(c.tenant, c.device)
+	m.mu.Lock()
+	p, ok := m.parked[key]
+	if ok {
+		p.timer.Stop()
+		delete(m.parked, key)
+	}
+	m.mu.Unlock()
+	if !ok {
+		sh.mu.Unlock()
+		return
+	}
+	c.queue = append(c.queue, p.pending...)
+	m.gauges.queued.Add(int64(len(p.pending)))
+	if !c.busy {
+		c.busy = true
+		m.gauges.busy.Add(1)
+	}
+	sh.mu.Unlock()
+
+	log.Printf("[SOCKET] Device reconnected, resuming held queue | id=%s | device=%s | pending=%d",
+		id, c.device, len(p.pending))
+	m.next(id)
+}
+
+// expireParked gives up on a device that did not reconnect in time and hands
+// its held queue to the rest of its tenant.
+func (m *Manager) expireParked(key string) {
+	m.mu.Lock()
+	p, ok := m.parked[key]
+	delete(m.parked, key)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	log.Printf("[SOCKET] Device did not reconnect in time, requeueing | tenant=%s | pending=%d", p.tenant, len(p.pending))
+	m.requeue(p.tenant, p.pending)
+}
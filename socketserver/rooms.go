@@ -0,0 +1,79 @@
+package socketserver
+
+import (
+	"fmt"
+	"log"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// JoinEvent is sent by a gateway to join a named room, e.g. its region, so
+// messages for that region's numbers are routed to it. The payload is the
+// room name or {"room": "..."}; an empty name leaves the current room.
+const JoinEvent = "join"
+
+// maxRoomLen bounds room names, which come straight from clients.
+const maxRoomLen = 64
+
+// join moves the gateway into the room it asked for. A gateway is in at
+// most one room at a time, so joining a new one leaves the old.
+func (m *Manager) join(s socketio.Conn, event string, data interface{}) {
+	room, perr := roomOf(data)
+	if perr != nil {
+		perr.Event = event
+		m.rejectPayload(s, perr)
+		return
+	}
+
+	sh := m.clients.shard(s.ID())
+	sh.mu.Lock()
+	c, ok := sh.clients[s.ID()]
+	if !ok {
+		sh.mu.Unlock()
+		log.Printf("[SOCKET] Event 'join' from unknown client | id=%s | remote=%s", s.ID(), s.RemoteAddr())
+		return
+	}
+	old := c.room
+	c.room = room
+	sh.mu.Unlock()
+
+	if old != "" && old != room {
+		s.Leave(old)
+	}
+	if room != "" {
+		s.Join(room)
+	}
+	log.Printf("[SOCKET] Client joined room | id=%s | tenant=%s | room=%q | previous=%q", s.ID(), c.tenant, room, old)
+}
+
+// roomOf extracts the room name from a join payload.
+func roomOf(data interface{}) (string, *PayloadError) {
+	var room string
+	switch v := data.(type) {
+	case string:
+		room = v
+	case map[string]interface{}:
+		r, ok := v["room"].(string)
+		if !ok {
+			return "", &PayloadError{Code: PayloadInvalidField, Message: `"room" must be a string`}
+		}
+		room = r
+	default:
+		return "", &PayloadError{Code: PayloadInvalidType, Message: fmt.Sprintf("payload must be a string or an object, got %T", data)}
+	}
+	if len(room) > maxRoomLen {
+		return "", &PayloadError{Code: PayloadInvalidField, Message: fmt.Sprintf("room name longer than %d bytes", maxRoomLen)}
+	}
+	return room, nil
+}
+
+// EmitToRoom broadcasts an event to the clients that joined room.
+func (m *Manager) EmitToRoom(room, event string, data interface{}) (int, error) {
+	return m.EmitWhere(func(c ClientInfo) bool { return c.Room == room }, event, data)
+}
+
+// EmitToTenantRoom broadcasts an event to the clients of tenant that joined
+// room, so region routing never crosses tenants.
+func (m *Manager) EmitToTenantRoom(tenant, room, event string, data interface{}) (int, error) {
+	return m.EmitWhere(func(c ClientInfo) bool { return c.Tenant == tenant && c.Room == room }, event, data)
+}
@@ -0,0 +1,103 @@
+package socketserver
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// errHandlerPanic rejects a connection whose OnConnect handler panicked.
+var errHandlerPanic = errors.New("connect handler panicked")
+
+// handlerFunc lists the callback shapes go-socket.io registers: OnConnect,
+// OnDisconnect, OnError and OnEvent.
+type handlerFunc interface {
+	func(socketio.Conn) error |
+		func(socketio.Conn, string) |
+		func(socketio.Conn, error) |
+		func(socketio.Conn, interface{})
+}
+
+// safeHandler wraps a Socket.IO callback so that a panic in it is logged
+// with its stack and swallowed. go-socket.io runs callbacks on its
+// per-connection goroutines, where an unrecovered panic takes down the
+// whole process. kind names the callback in the log ("connect", "error",
+// "disconnect" or the event name) and report, if set, is given each
+// recovered panic. A panicking OnConnect rejects the connection. Every
+// registration on the server goes through this.
+func safeHandler[F handlerFunc](kind string, report func(reason interface{}), fn F) F {
+	var wrapped interface{}
+	switch f := any(fn).(type) {
+	case func(socketio.Conn) error:
+		wrapped = func(s socketio.Conn) (err error) {
+			defer recoverHandler(kind, s, report, func() { err = errHandlerPanic })
+			return f(s)
+		}
+	case func(socketio.Conn, string):
+		wrapped = func(s socketio.Conn, arg string) {
+			defer recoverHandler(kind, s, report, nil)
+			f(s, arg)
+		}
+	case func(socketio.Conn, error):
+		wrapped = func(s socketio.Conn, arg error) {
+			defer recoverHandler(kind, s, report, nil)
+			f(s, arg)
+		}
+	case func(socketio.Conn, interface{}):
+		wrapped = func(s socketio.Conn, arg interface{}) {
+			defer recoverHandler(kind, s, report, nil)
+			f(s, arg)
+		}
+	}
+	return wrapped.(F)
+}
+
+// recoverHandler is deferred by safeHandler's wrappers. s may be nil (see
+// OnError). report and onPanic, if set, run after the panic is logged.
+func recoverHandler(kind string, s socketio.Conn, report func(reason interface{}), onPanic func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	id := ""
+	if s != nil {
+		id = s.ID()
+	}
+	log.Printf("[SOCKET][PANIC] Socket.IO handler panicked | handler=%s | id=%s | panic=%v\nstack:\n%s",
+		kind, id, r, debug.Stack())
+	if report != nil {
+		report(r)
+	}
+	if onPanic != nil {
+		onPanic()
+	}
+}
+
+// OnPanic registers fn to run for each panic recovered in a Socket.IO
+// callback, after it is logged. The connection is dealt with as without a
+// hook; fn is for recording state, e.g. a crash snapshot.
+func (m *Manager) OnPanic(fn func(reason interface{})) {
+	m.mu.Lock()
+	m.panicHooks = append(m.panicHooks, fn)
+	m.mu.Unlock()
+}
+
+// runPanicHooks calls every panic hook. A panicking hook is logged and does
+// not stop the others.
+func (m *Manager) runPanicHooks(reason interface{}) {
+	m.mu.Lock()
+	hooks := m.panicHooks
+	m.mu.Unlock()
+	for _, fn := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[SOCKET][PANIC] Panic hook panicked | panic=%v\nstack:\n%s", r, debug.Stack())
+				}
+			}()
+			fn(reason)
+		}()
+	}
+}
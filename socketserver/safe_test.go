@@ -0,0 +1,48 @@
+package socketserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+func TestRecoveredPanicsWriteSnapshot(t *testing.T) {
+	m := newTestManager(t)
+	conn := addClient(m, "a", "")
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	m.OnPanic(func(r interface{}) {
+		if err := m.WriteSnapshot(path, fmt.Sprint(r)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	read := func() Snapshot {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			t.Fatal(err)
+		}
+		return snap
+	}
+
+	m.recoverEvents(func(socketio.Conn, string, interface{}) { panic("event boom") })(conn, "sended", nil)
+	if snap := read(); snap.Reason != "event boom" || len(snap.Clients) != 1 {
+		t.Fatalf("snapshot after event panic = %+v", snap)
+	}
+
+	connect := safeHandler("connect", m.runPanicHooks, func(socketio.Conn) error { panic("connect boom") })
+	if err := connect(conn); err != errHandlerPanic {
+		t.Fatalf("connect error = %v, want errHandlerPanic", err)
+	}
+	if snap := read(); snap.Reason != "connect boom" {
+		t.Fatalf("snapshot after connect panic = %+v", snap)
+	}
+}
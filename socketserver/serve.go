@@ -0,0 +1,19 @@
+package socketserver
+
+import "log"
+
+// Serve runs the Socket.IO serve loop until the server is closed. While it
+// runs, Serving reports true; readiness probes use that to tell a pod whose
+// socket server has stopped apart from one that is merely idle.
+func (m *Manager) Serve() error {
+	m.serving.Store(true)
+	defer m.serving.Store(false)
+	log.Printf("[STARTUP] Socket.IO serve loop starting...")
+	return m.Server.Serve()
+}
+
+// Serving reports whether the serve loop is running and the server is not
+// shutting down.
+func (m *Manager) Serving() bool {
+	return m.serving.Load()
+}
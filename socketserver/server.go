@@ -1,134 +1,799 @@
-package socketserver
-
-import (
-	"log"
-	"net/http"
-	"sync"
-
-	socketio "github.com/googollee/go-socket.io"
-	"github.com/googollee/go-socket.io/engineio"
-	"github.com/googollee/go-socket.io/engineio/transport"
-	"github.com/googollee/go-socket.io/engineio/transport/polling"
-	"github.com/googollee/go-socket.io/engineio/transport/websocket"
-)
-
-// OTPEvent matches the shape emitted to Socket.IO clients.
-type OTPEvent struct {
-	Phone string `json:"phone"`
-	Pass  string `json:"pass"`
-}
-
-type client struct {
-	id   string
-	busy bool
-}
-
-// Manager holds the Socket.IO server and tracks connected clients.
-type Manager struct {
-	mu      sync.Mutex
-	clients map[string]*client
-	Server  *socketio.Server
-}
-
-// NewManager creates and configures a Socket.IO server.
-// All origins are allowed.
-func NewManager() *Manager {
-	m := &Manager{
-		clients: make(map[string]*client),
-	}
-
-	allowAll := func(r *http.Request) bool { return true }
-
-	srv := socketio.NewServer(&engineio.Options{
-		Transports: []transport.Transport{
-			&polling.Transport{
-				CheckOrigin: allowAll,
-			},
-			&websocket.Transport{
-				CheckOrigin: allowAll,
-			},
-		},
-	})
-
-	// go-socket.io v1.7.0 fires OnConnect twice for the same connection when
-	// the client upgrades from polling → WebSocket transport. Guard with a
-	// duplicate check so the client map and counter stay correct.
-	srv.OnConnect("/", func(s socketio.Conn) error {
-		m.mu.Lock()
-		if _, exists := m.clients[s.ID()]; exists {
-			m.mu.Unlock()
-			log.Printf("[SOCKET] Duplicate OnConnect (transport upgrade) – ignored | id=%s | remote=%s",
-				s.ID(), s.RemoteAddr())
-			return nil
-		}
-		m.clients[s.ID()] = &client{id: s.ID(), busy: false}
-		count := len(m.clients)
-		m.mu.Unlock()
-		log.Printf("[SOCKET] Client connected | id=%s | remote=%s | total_clients=%d",
-			s.ID(), s.RemoteAddr(), count)
-		return nil
-	})
-
-	// OnError is called when a connection error occurs (e.g. i/o timeout after
-	// a client drops silently). In go-socket.io v1.7.0, `s` can be nil for
-	// errors that occur before a connection is fully established, so we guard
-	// against that to avoid a nil-pointer panic crashing the whole process.
-	srv.OnError("/", func(s socketio.Conn, err error) {
-		if s == nil {
-			log.Printf("[SOCKET] Error (no connection context) | error=%v", err)
-			return
-		}
-		// "i/o timeout" is a normal event – it means the remote peer dropped
-		// the TCP connection without sending a close frame. The client will
-		// reconnect automatically; no action needed.
-		log.Printf("[SOCKET] Connection error | id=%s | remote=%s | error=%v",
-			s.ID(), s.RemoteAddr(), err)
-	})
-
-	srv.OnEvent("/", "otpsender", func(s socketio.Conn, data interface{}) {
-		log.Printf("[SOCKET] Event 'otpsender' received | id=%s | remote=%s | data=%v",
-			s.ID(), s.RemoteAddr(), data)
-	})
-
-	srv.OnEvent("/", "message", func(s socketio.Conn, data interface{}) {
-		log.Printf("[SOCKET] Event 'message' received | id=%s | remote=%s | data=%v",
-			s.ID(), s.RemoteAddr(), data)
-	})
-
-	srv.OnEvent("/", "sended", func(s socketio.Conn, data interface{}) {
-		m.mu.Lock()
-		c, ok := m.clients[s.ID()]
-		if ok {
-			c.busy = false
-		}
-		m.mu.Unlock()
-		if ok {
-			log.Printf("[SOCKET] Event 'sended' – client marked available | id=%s | remote=%s | data=%v",
-				s.ID(), s.RemoteAddr(), data)
-		} else {
-			log.Printf("[SOCKET] Event 'sended' from unknown client | id=%s | remote=%s | data=%v",
-				s.ID(), s.RemoteAddr(), data)
-		}
-	})
-
-	srv.OnDisconnect("/", func(s socketio.Conn, reason string) {
-		m.mu.Lock()
-		delete(m.clients, s.ID())
-		count := len(m.clients)
-		m.mu.Unlock()
-		log.Printf("[SOCKET] Client disconnected | id=%s | remote=%s | reason=%s | total_clients=%d",
-			s.ID(), s.RemoteAddr(), reason, count)
-	})
-
-	m.Server = srv
-	return m
-}
-
-// Emit broadcasts an event to all connected Socket.IO clients.
-func (m *Manager) Emit(event string, data interface{}) {
-	m.mu.Lock()
-	count := len(m.clients)
-	m.mu.Unlock()
-	log.Printf("[SOCKET] Broadcasting event | event=%s | connected_clients=%d | data=%v", event, count, data)
-	m.Server.BroadcastToNamespace("/", event, data)
-}
+package socketserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sms_service/config"
+	"sms_service/logging"
+	"sms_service/metrics"
+
+	socketio "github.com/googollee/go-socket.io"
+	"github.com/googollee/go-socket.io/engineio"
+	"github.com/googollee/go-socket.io/engineio/transport"
+	"github.com/googollee/go-socket.io/engineio/transport/polling"
+	"github.com/googollee/go-socket.io/engineio/transport/websocket"
+)
+
+// Message categories carried in OTPEvent.Category, letting gateways pick a
+// template or route per category (e.g. priority routing for OTPs).
+const (
+	CategoryOTP           = "otp"
+	CategoryGroup         = "group"
+	CategoryTransactional = "transactional"
+)
+
+// OTPEvent matches the shape emitted to Socket.IO clients.
+type OTPEvent struct {
+	Phone string `json:"phone"`
+	Pass  string `json:"pass"`
+	// Category is additive; gateways that predate it simply ignore it.
+	Category string `json:"category,omitempty"`
+	// Redispatch counts how often the message was handed to another gateway
+	// after one reported "send_failed"; it is capped by
+	// SEND_FAILED_MAX_REDISPATCH.
+	Redispatch int `json:"redispatch,omitempty"`
+	// MessageID is set when the sender waits for a delivery ack, so the
+	// gateway's ack can be correlated with the request.
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// String renders e for logs with the phone masked and the message text,
+// which may carry an OTP, hidden (see logging.Sensitive).
+func (e OTPEvent) String() string {
+	return fmt.Sprintf("{phone:%s pass:%s category:%s message_id:%s}",
+		logging.Phone(e.Phone), logging.Secret(e.Pass), e.Category, e.MessageID)
+}
+
+// errUnauthorized rejects socket connections without a valid API key.
+var errUnauthorized = errors.New("unauthorized")
+
+// errTooManyClients rejects socket connections beyond cfg.MaxClients.
+var errTooManyClients = errors.New("too many clients")
+
+// ErrFanoutExceeded is returned when a broadcast would reach more clients
+// than the configured safe maximum.
+var ErrFanoutExceeded = errors.New("broadcast fan-out above safe limit")
+
+// ErrClientNotFound is returned when an operation targets a socket ID that
+// is not currently connected.
+var ErrClientNotFound = errors.New("socket client not found")
+
+type client struct {
+	id     string
+	conn   socketio.Conn
+	tenant string
+	// remoteAddr and connectedAt are recorded once, at connect.
+	remoteAddr  string
+	connectedAt time.Time
+	// lastEventAt is when the client last sent any event (connectedAt
+	// until it does).
+	lastEventAt time.Time
+	// device is the gateway's self-reported device ID, stable across
+	// reconnects ("" if it sent none).
+	device string
+	// firmware is the gateway's self-reported firmware version ("" if it
+	// sent none).
+	firmware string
+	// room is the named room the gateway joined, usually its region ("" if
+	// none).
+	room string
+	busy bool
+	// queue holds events assigned to this client by Dispatch while busy.
+	queue []queued
+	// inflight is the recipient of the event dispatched to this client and
+	// not yet confirmed with "sended".
+	inflight string
+	// current is the event behind inflight, kept so that a "send_failed"
+	// can hand it to another gateway.
+	current queued
+	// draining clients finish in-flight work but receive no new dispatches.
+	draining bool
+}
+
+// info returns the exported snapshot of c. Callers must hold c's shard lock.
+func (c *client) info() ClientInfo {
+	return ClientInfo{ID: c.id, Tenant: c.tenant, Device: c.device, Firmware: c.firmware, Room: c.room, Busy: c.busy, Draining: c.draining, Queued: len(c.queue)}
+}
+
+// available reports whether the client may be handed new work.
+func (c *client) available() bool {
+	return !c.busy && !c.draining
+}
+
+// ClientInfo is a point-in-time snapshot of a connected client.
+type ClientInfo struct {
+	ID       string `json:"id"`
+	Tenant   string `json:"tenant,omitempty"`
+	Device   string `json:"device,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+	Room     string `json:"room,omitempty"`
+	Busy     bool   `json:"busy"`
+	Draining bool   `json:"draining"`
+	Queued   int    `json:"queued"`
+}
+
+// Stats summarises the state of all connected clients.
+type Stats struct {
+	Connected int `json:"connected"`
+	Busy      int `json:"busy"`
+	Draining  int `json:"draining"`
+	Available int `json:"available"`
+	// Queued is the total number of events waiting in per-client queues.
+	Queued int `json:"queued"`
+	// StaleRemoved counts clients dropped by Reconcile since startup,
+	// i.e. how often the map drifted from the real connection set.
+	StaleRemoved int `json:"stale_removed"`
+	// UnknownEvents counts inbound events outside the allowlist, in total
+	// and by name.
+	UnknownEvents     int            `json:"unknown_events"`
+	UnknownEventNames map[string]int `json:"unknown_event_names,omitempty"`
+	// SendedConfirmed and SendedMismatched count "sended" acks whose phone
+	// matched, or did not match, the dispatched recipient.
+	SendedConfirmed  int `json:"sended_confirmed"`
+	SendedMismatched int `json:"sended_mismatched"`
+	// Firmware counts connected clients by reported firmware version,
+	// "unknown" for those that sent none.
+	Firmware map[string]int `json:"firmware"`
+}
+
+// Manager holds the Socket.IO server and tracks connected clients.
+type Manager struct {
+	cfg        *config.Live
+	transports []string
+	// clients is locked per shard; mu guards every other field below.
+	clients    *clientMap
+	mu         sync.Mutex
+	middleware []EventMiddleware
+	Server     *socketio.Server
+	// connectHooks run after each new client is registered.
+	connectHooks []func(id, tenant string)
+	// sendedHooks run for each "sended" from a known client.
+	sendedHooks []func(id, tenant, phone string)
+	// failedHooks run for each "send_failed" from a known client.
+	failedHooks []func(FailedSend)
+	// panicHooks run for each panic recovered in a Socket.IO callback.
+	panicHooks []func(reason interface{})
+
+	// events is the set of event names with a registered handler.
+	events map[string]bool
+
+	staleRemoved      int
+	unknownEvents     int
+	unknownEventNames map[string]int
+
+	// engine is go-socket.io's engine.io server, nil if it could not be
+	// reached (see engineOf). sessions tracks its sessions for reclaiming.
+	engine            *engineio.Server
+	sessions          map[string]trackedSession
+	sessionsReclaimed int
+
+	// parked holds queues of devices that dropped transiently, keyed by
+	// tenant and device ID.
+	parked map[string]*parkedQueue
+	// devices lists the connection IDs of each device, oldest first, keyed
+	// like parked.
+	devices map[string][]string
+
+	// deliveries holds per-phone "sended" correlation results, keyed by
+	// normalized phone (see confirm).
+	deliveries       map[string]*PhoneDelivery
+	sendedConfirmed  int
+	sendedMismatched int
+
+	// serving is true while Serve runs and Shutdown has not started.
+	serving atomic.Bool
+
+	// gauges mirror counts kept under the shard locks so Gauges can be read
+	// lock-free.
+	gauges struct {
+		connected, busy, queued atomic.Int64
+		// errors counts OnError callbacks since start.
+		errors atomic.Int64
+	}
+}
+
+// Gauges is a lock-free snapshot of the client counts. Unlike Stats it never
+// waits on the Manager lock, so it is cheap enough to poll from dashboards.
+type Gauges struct {
+	Connected int64 `json:"connected"`
+	Busy      int64 `json:"busy"`
+	Queued    int64 `json:"queued"`
+}
+
+// Gauges returns the current client gauges.
+func (m *Manager) Gauges() Gauges {
+	return Gauges{
+		Connected: m.gauges.connected.Load(),
+		Busy:      m.gauges.busy.Load(),
+		Queued:    m.gauges.queued.Load(),
+	}
+}
+
+// OnClientConnect registers fn to run, on its own goroutine, after each new
+// client is registered and any queue held for its device was resumed. fn
+// must not block for long: it delays later hooks for the same client.
+func (m *Manager) OnClientConnect(fn func(id, tenant string)) {
+	m.mu.Lock()
+	m.connectHooks = append(m.connectHooks, fn)
+	m.mu.Unlock()
+}
+
+// runConnectHooks calls every connect hook for a new client. A panicking
+// hook is logged and does not stop the others.
+func (m *Manager) runConnectHooks(id, tenant string) {
+	m.mu.Lock()
+	hooks := m.connectHooks
+	m.mu.Unlock()
+	for _, fn := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[SOCKET][PANIC] Connect hook panicked | id=%s | panic=%v\nstack:\n%s", id, r, debug.Stack())
+				}
+			}()
+			fn(id, tenant)
+		}()
+	}
+}
+
+// OnSended registers fn to run when a client reports a message sent. phone
+// is the number it reported, or the one dispatched to it if it named none
+// ("" when neither is known). fn runs on the connection's event goroutine
+// and must not block.
+func (m *Manager) OnSended(fn func(id, tenant, phone string)) {
+	m.mu.Lock()
+	m.sendedHooks = append(m.sendedHooks, fn)
+	m.mu.Unlock()
+}
+
+// runSendedHooks calls every sended hook. A panicking hook is logged and
+// does not stop the others.
+func (m *Manager) runSendedHooks(id, tenant, phone string) {
+	m.mu.Lock()
+	hooks := m.sendedHooks
+	m.mu.Unlock()
+	for _, fn := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[SOCKET][PANIC] Sended hook panicked | id=%s | panic=%v\nstack:\n%s", id, r, debug.Stack())
+				}
+			}()
+			fn(id, tenant, phone)
+		}()
+	}
+}
+
+// ClientCount returns the number of connected clients.
+func (m *Manager) ClientCount() int {
+	return int(m.gauges.connected.Load())
+}
+
+// RegisterMetrics adds the manager's gauges to reg.
+func (m *Manager) RegisterMetrics(reg *metrics.Registry) {
+	reg.GaugeFunc("sms_connected_clients", "Socket.IO gateways currently connected.", m.gauges.connected.Load)
+	reg.CounterFunc("sms_socket_errors_total", "Socket.IO connection errors reported by OnError.", m.gauges.errors.Load)
+}
+
+// NewManager creates and configures a Socket.IO server.
+// All origins are allowed. When API keys are configured, gateways must
+// present one (api_key query parameter or X-API-Key header) and are bound to
+// that key's tenant.
+func NewManager(live *config.Live) *Manager {
+	cfg := live.Get()
+	m := &Manager{
+		cfg:     live,
+		clients: newClientMap(),
+		events:  make(map[string]bool),
+
+		unknownEventNames: make(map[string]int),
+		sessions:          make(map[string]trackedSession),
+		parked:            make(map[string]*parkedQueue),
+		devices:           make(map[string][]string),
+		deliveries:        make(map[string]*PhoneDelivery),
+	}
+	m.middleware = []EventMiddleware{m.recoverEvents, m.touchEvents}
+
+	allowAll := func(r *http.Request) bool { return true }
+
+	var transports []transport.Transport
+	for _, name := range cfg.SocketTransports {
+		var t transport.Transport
+		switch name {
+		case "polling":
+			t = &polling.Transport{CheckOrigin: allowAll}
+		case "websocket":
+			t = &websocket.Transport{CheckOrigin: allowAll}
+		default:
+			continue
+		}
+		m.transports = append(m.transports, t.Name())
+		transports = append(transports, &inspectTransport{Transport: t, m: m})
+	}
+
+	srv := socketio.NewServer(&engineio.Options{
+		Transports:   transports,
+		PingInterval: cfg.SocketPingInterval,
+		PingTimeout:  cfg.SocketPingTimeout,
+		ConnInitor:   m.trackSession,
+	})
+	m.Server = srv
+	if m.engine = engineOf(srv); m.engine == nil {
+		log.Printf("[SOCKET] engine.io server not reachable, sessions will not be reclaimed")
+	}
+
+	// go-socket.io v1.7.0 fires OnConnect twice for the same connection when
+	// the client upgrades from polling → WebSocket transport. Guard with a
+	// duplicate check so the client map and counter stay correct. With
+	// SOCKET_TRANSPORTS=websocket there is no upgrade and no duplicate.
+	srv.OnConnect("/", safeHandler("connect", m.runPanicHooks, func(s socketio.Conn) error {
+		sh := m.clients.shard(s.ID())
+		sh.mu.Lock()
+		if _, exists := sh.clients[s.ID()]; exists {
+			sh.mu.Unlock()
+			log.Printf("[SOCKET] Duplicate OnConnect (transport upgrade) – ignored | id=%s | remote=%s",
+				s.ID(), s.RemoteAddr())
+			return nil
+		}
+		tenant, ok := m.tenantFor(s)
+		if !ok {
+			sh.mu.Unlock()
+			log.Printf("[SOCKET] Connection rejected: missing or invalid API key | id=%s | remote=%s",
+				s.ID(), s.RemoteAddr())
+			return errUnauthorized
+		}
+		device := deviceID(s)
+		var evicted []string
+		if device != "" {
+			if evicted, ok = m.admitDevice(tenant, device, s.ID()); !ok {
+				sh.mu.Unlock()
+				return errDeviceLimit
+			}
+		}
+		// A connection that evicts its device's older ones does not grow
+		// the total, so it is let in even at the cap.
+		max := 0
+		if len(evicted) == 0 {
+			max = m.cfg.Get().MaxClients
+		}
+		count, ok := m.reserveClient(max)
+		if !ok {
+			if device != "" {
+				m.forgetDevice(tenant, device, s.ID())
+			}
+			sh.mu.Unlock()
+			log.Printf("[SOCKET] Connection rejected: client limit reached | id=%s | remote=%s | tenant=%s | connected=%d | max=%d",
+				s.ID(), s.RemoteAddr(), tenant, count, max)
+			return errTooManyClients
+		}
+		firmware := firmwareOf(s)
+		now := time.Now().UTC()
+		sh.clients[s.ID()] = &client{
+			id:          s.ID(),
+			conn:        s,
+			tenant:      tenant,
+			remoteAddr:  s.RemoteAddr().String(),
+			connectedAt: now,
+			lastEventAt: now,
+			device:      device,
+			firmware:    firmware,
+		}
+		sh.mu.Unlock()
+		log.Printf("[SOCKET] Client connected | id=%s | remote=%s | tenant=%s | device=%s | firmware=%s | total_clients=%d",
+			s.ID(), s.RemoteAddr(), tenant, device, firmware, count)
+		for _, old := range evicted {
+			m.evict(old)
+		}
+		// Emitting blocks until go-socket.io starts the write loop, which only
+		// happens after OnConnect returns. A held queue goes out before
+		// anything the hooks send.
+		go func(id string) {
+			m.adoptParked(id)
+			m.runConnectHooks(id, tenant)
+		}(s.ID())
+		return nil
+	}))
+
+	// OnError is called when a connection error occurs (e.g. i/o timeout after
+	// a client drops silently). In go-socket.io v1.7.0, `s` can be nil for
+	// errors that occur before a connection is fully established, so we guard
+	// against that to avoid a nil-pointer panic crashing the whole process.
+	srv.OnError("/", safeHandler("error", m.runPanicHooks, func(s socketio.Conn, err error) {
+		m.gauges.errors.Add(1)
+		if s == nil {
+			log.Printf("[SOCKET] Error (no connection context) | error=%v", err)
+			return
+		}
+		// "i/o timeout" is a normal event – it means the remote peer dropped
+		// the TCP connection without sending a close frame. The client will
+		// reconnect automatically; no action needed.
+		log.Printf("[SOCKET] Connection error | id=%s | remote=%s | error=%v",
+			s.ID(), s.RemoteAddr(), err)
+	}))
+
+	// Inbound events go through the middleware chain (see Use).
+	m.handleEvent("otpsender", func(s socketio.Conn, event string, data interface{}) {
+		log.Printf("[SOCKET] Event '%s' received | id=%s | remote=%s | data=%v",
+			event, s.ID(), s.RemoteAddr(), logging.Payload(data))
+	})
+
+	m.handleEvent("message", func(s socketio.Conn, event string, data interface{}) {
+		log.Printf("[SOCKET] Event '%s' received | id=%s | remote=%s | data=%v",
+			event, s.ID(), s.RemoteAddr(), logging.Payload(data))
+	})
+
+	m.handleEvent("sended", func(s socketio.Conn, event string, data interface{}) {
+		// A malformed payload still frees the gateway for its next message;
+		// only the phone check against the dispatched recipient is skipped.
+		if perr := validateSended(data); perr != nil {
+			perr.Event = event
+			m.rejectPayload(s, perr)
+			data = nil
+		}
+		if m.clients.has(s.ID()) {
+			log.Printf("[SOCKET] Event 'sended' – client finished message | id=%s | remote=%s | data=%v",
+				s.ID(), s.RemoteAddr(), logging.Payload(data))
+			tenant, phone := m.confirm(s.ID(), data)
+			m.runSendedHooks(s.ID(), tenant, phone)
+			m.next(s.ID())
+		} else {
+			log.Printf("[SOCKET] Event 'sended' from unknown client | id=%s | remote=%s | data=%v",
+				s.ID(), s.RemoteAddr(), logging.Payload(data))
+		}
+	})
+
+	m.handleEvent(JoinEvent, m.join)
+	m.handleEvent(SendFailedEvent, m.sendFailed)
+
+	srv.OnDisconnect("/", safeHandler("disconnect", m.runPanicHooks, func(s socketio.Conn, reason string) {
+		count := m.disconnect(s.ID(), reason)
+		m.reclaimSession(s.ID())
+		log.Printf("[SOCKET] Client disconnected | id=%s | remote=%s | reason=%s | total_clients=%d",
+			s.ID(), s.RemoteAddr(), reason, count)
+	}))
+
+	return m
+}
+
+// reserveClient counts a new client in, unless max (if above 0) clients are
+// already connected. The map is sharded, so the cap is enforced on the
+// connected gauge with compare-and-swap: concurrent connects on different
+// shards cannot both take the last slot. It returns the new count, or the
+// current one when refused.
+func (m *Manager) reserveClient(max int) (int64, bool) {
+	for {
+		n := m.gauges.connected.Load()
+		if max > 0 && n >= int64(max) {
+			return n, false
+		}
+		if m.gauges.connected.CompareAndSwap(n, n+1) {
+			return n + 1, true
+		}
+	}
+}
+
+// tenantFor resolves the tenant of a connecting gateway from its API key.
+func (m *Manager) tenantFor(s socketio.Conn) (string, bool) {
+	if len(m.cfg.Get().APIKeys) == 0 {
+		return "", true
+	}
+	u := s.URL()
+	key := u.Query().Get("api_key")
+	if key == "" {
+		key = s.RemoteHeader().Get("X-API-Key")
+	}
+	return m.cfg.Get().LookupAPIKey(key)
+}
+
+// deviceID returns the stable device identifier a gateway sent in its
+// handshake (device_id query parameter or X-Device-ID header), if any.
+func deviceID(s socketio.Conn) string {
+	u := s.URL()
+	if id := u.Query().Get("device_id"); id != "" {
+		return id
+	}
+	return s.RemoteHeader().Get("X-Device-ID")
+}
+
+// Emit broadcasts an event to all connected Socket.IO clients.
+// It returns the number of clients reached.
+func (m *Manager) Emit(event string, data interface{}) (int, error) {
+	return m.EmitWhere(func(ClientInfo) bool { return true }, event, data)
+}
+
+// EmitToTenant broadcasts an event to the clients of one tenant only, so a
+// tenant's messages never reach another tenant's gateways.
+func (m *Manager) EmitToTenant(tenant, event string, data interface{}) (int, error) {
+	return m.EmitWhere(func(c ClientInfo) bool { return c.Tenant == tenant }, event, data)
+}
+
+// EmitTo sends an event to the one client with the given socket ID, or
+// returns ErrClientNotFound if it is not connected. The caller picked the
+// client, so the fan-out cap and minimum firmware do not apply. A client
+// whose connection fails the write is dropped and the error returned.
+func (m *Manager) EmitTo(id, event string, data interface{}) error {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, ok := sh.clients[id]
+	sh.mu.Unlock()
+	if !ok {
+		return ErrClientNotFound
+	}
+	log.Printf("[SOCKET] Emitting event to client | id=%s | event=%s | data=%v", id, event, logging.Payload(data))
+	if err := emitSafe(c.conn, event, data); err != nil {
+		log.Printf("[SOCKET] Emit failed, dropping client | id=%s | event=%s | error=%v", id, event, err)
+		m.remove(id)
+		return err
+	}
+	return nil
+}
+
+// EmitWhere sends an event to every client for which pred returns true and
+// returns the number of clients reached. It is the primitive the other
+// Emit variants are built on. pred runs with a client map shard locked and
+// must not call back into the Manager. Draining clients and clients below
+// the message's minimum firmware are skipped. It sends nothing and returns
+// ErrFanoutExceeded when more clients match than the broadcast cap allows.
+func (m *Manager) EmitWhere(pred func(ClientInfo) bool, event string, data interface{}) (int, error) {
+	min := m.minFirmware(data, false)
+	outdated, drained := 0, 0
+	targets := m.snapshot(func(c *client) bool {
+		if !pred(c.info()) {
+			return false
+		}
+		if c.draining {
+			drained++
+			return false
+		}
+		if !versionAtLeast(c.firmware, min) {
+			outdated++
+			return false
+		}
+		return true
+	})
+	if outdated > 0 {
+		log.Printf("[SOCKET] Skipping clients below minimum firmware | event=%s | min_firmware=%s | skipped=%d", event, min, outdated)
+	}
+	if drained > 0 {
+		log.Printf("[SOCKET] Skipping draining clients | event=%s | skipped=%d", event, drained)
+	}
+	if err := m.checkFanout(event, len(targets)); err != nil {
+		return 0, err
+	}
+	log.Printf("[SOCKET] Emitting event | event=%s | matched_clients=%d | data=%v", event, len(targets), logging.Payload(data))
+	return m.emitAll(targets, event, data), nil
+}
+
+// checkFanout refuses a broadcast to more than cfg.MaxBroadcastFanout
+// clients. That many gateways is never expected, so it points at a
+// misconfiguration or an attack, and sending to all of them would amplify
+// the incident; such sends must be directed (Dispatch) instead.
+func (m *Manager) checkFanout(event string, targets int) error {
+	max := m.cfg.Get().MaxBroadcastFanout
+	if max <= 0 || targets <= max {
+		return nil
+	}
+	log.Printf("[SOCKET][ALERT] Broadcast refused, fan-out above safe limit | event=%s | matched_clients=%d | max=%d | connected=%d",
+		event, targets, max, m.gauges.connected.Load())
+	return ErrFanoutExceeded
+}
+
+// snapshot returns the clients matching pred, copied out shard by shard.
+func (m *Manager) snapshot(pred func(*client) bool) []*client {
+	out := make([]*client, 0, m.gauges.connected.Load())
+	m.clients.each(func(c *client) bool {
+		if pred(c) {
+			out = append(out, c)
+		}
+		return true
+	})
+	return out
+}
+
+// emitAll writes an event to each target in turn and returns how many
+// writes succeeded. Clients are written to one at a time so that a panic on
+// one bad connection (go-socket.io can panic when writing to a connection
+// that is closing) only drops that client instead of aborting the broadcast
+// or the process.
+func (m *Manager) emitAll(targets []*client, event string, data interface{}) int {
+	reached := 0
+	for _, c := range targets {
+		if err := emitSafe(c.conn, event, data); err != nil {
+			log.Printf("[SOCKET] Emit failed, dropping client | id=%s | event=%s | error=%v", c.id, event, err)
+			m.remove(c.id)
+			continue
+		}
+		reached++
+	}
+	return reached
+}
+
+// emitSafe writes one event to one connection, converting a panic inside
+// go-socket.io into an error.
+func emitSafe(conn socketio.Conn, event string, data interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[SOCKET][PANIC] Emit panicked | id=%s | event=%s | panic=%v\nstack:\n%s",
+				conn.ID(), event, r, debug.Stack())
+			err = fmt.Errorf("emit panicked: %v", r)
+		}
+	}()
+	conn.Emit(event, data)
+	return nil
+}
+
+// remove deletes a client from the map and returns the remaining count.
+// Events still queued for the client are handed to the rest of its tenant.
+func (m *Manager) remove(id string) int {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, count := m.detach(sh, id)
+	sh.mu.Unlock()
+
+	if c != nil && len(c.queue) > 0 {
+		m.requeue(c.tenant, c.queue)
+	}
+	return count
+}
+
+// detach deletes a client from its shard sh, returning it (nil if unknown)
+// and the remaining count. Callers must hold sh's lock.
+func (m *Manager) detach(sh *clientShard, id string) (*client, int) {
+	c, ok := sh.clients[id]
+	if !ok {
+		return nil, int(m.gauges.connected.Load())
+	}
+	delete(sh.clients, id)
+	if c.room != "" {
+		c.conn.Leave(c.room)
+	}
+	if c.device != "" {
+		m.forgetDevice(c.tenant, c.device, id)
+	}
+	count := m.gauges.connected.Add(-1)
+	m.gauges.queued.Add(-int64(len(c.queue)))
+	if c.busy {
+		m.gauges.busy.Add(-1)
+	}
+	return c, int(count)
+}
+
+// Transports returns the names of the enabled engine.io transports.
+func (m *Manager) Transports() []string {
+	return m.transports
+}
+
+// Clients returns a snapshot of all connected clients, sorted by ID.
+func (m *Manager) Clients() []ClientInfo {
+	out := make([]ClientInfo, 0, m.gauges.connected.Load())
+	m.clients.each(func(c *client) bool {
+		out = append(out, c.info())
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// TenantClients is Clients restricted to one tenant's connections.
+func (m *Manager) TenantClients(tenant string) []ClientInfo {
+	out := []ClientInfo{}
+	m.clients.each(func(c *client) bool {
+		if c.tenant == tenant {
+			out = append(out, c.info())
+		}
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// AdminClient is a ClientInfo with the connection details only operators
+// should see.
+type AdminClient struct {
+	ClientInfo
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastEventAt time.Time `json:"last_event_at"`
+}
+
+// ListClients returns a snapshot of the tenant's connected clients with
+// their remote address and connection time, sorted by ID. Nothing is masked.
+func (m *Manager) ListClients(tenant string) []AdminClient {
+	out := []AdminClient{}
+	m.clients.each(func(c *client) bool {
+		if c.tenant != tenant {
+			return true
+		}
+		out = append(out, AdminClient{ClientInfo: c.info(), RemoteAddr: c.remoteAddr, ConnectedAt: c.connectedAt, LastEventAt: c.lastEventAt})
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Stats returns aggregate counts over the connected clients, summed shard by
+// shard.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	st := Stats{
+		StaleRemoved:      m.staleRemoved,
+		UnknownEvents:     m.unknownEvents,
+		UnknownEventNames: make(map[string]int, len(m.unknownEventNames)),
+		Firmware:          make(map[string]int),
+		SendedConfirmed:   m.sendedConfirmed,
+		SendedMismatched:  m.sendedMismatched,
+	}
+	for name, n := range m.unknownEventNames {
+		st.UnknownEventNames[name] = n
+	}
+	m.mu.Unlock()
+
+	m.countClients(&st, func(*client) bool { return true })
+	return st
+}
+
+// TenantStats is Stats over one tenant's clients. The process-wide
+// counters (stale removals, unknown events, sended matches) cannot be
+// attributed to a tenant and are left zero.
+func (m *Manager) TenantStats(tenant string) Stats {
+	st := Stats{Firmware: make(map[string]int)}
+	m.countClients(&st, func(c *client) bool { return c.tenant == tenant })
+	return st
+}
+
+// countClients adds the per-client counts of every client keep accepts
+// to st.
+func (m *Manager) countClients(st *Stats, keep func(*client) bool) {
+	m.clients.each(func(c *client) bool {
+		if !keep(c) {
+			return true
+		}
+		st.Connected++
+		if c.firmware == "" {
+			st.Firmware["unknown"]++
+		} else {
+			st.Firmware[c.firmware]++
+		}
+		if c.busy {
+			st.Busy++
+		}
+		if c.draining {
+			st.Draining++
+		}
+		if c.available() {
+			st.Available++
+		}
+		st.Queued += len(c.queue)
+		return true
+	})
+}
+
+// SetDraining marks a client as draining (or clears the flag). A draining
+// client keeps its connection and completes in-flight messages, but is
+// excluded from every new send, queued (Dispatch, EmitToAvailable) and
+// broadcast (EmitWhere and the Emit variants on it, EmitWithAck), so the
+// device can be serviced safely. EmitTo, which names the client, still
+// reaches it. A client of another tenant is reported as ErrClientNotFound.
+func (m *Manager) SetDraining(tenant, id string, draining bool) error {
+	sh := m.clients.shard(id)
+	sh.mu.Lock()
+	c, ok := sh.clients[id]
+	ok = ok && c.tenant == tenant
+	if ok {
+		c.draining = draining
+	}
+	sh.mu.Unlock()
+	if !ok {
+		return ErrClientNotFound
+	}
+	log.Printf("[SOCKET] Client drain state changed | id=%s | draining=%t", id, draining)
+	return nil
+}
@@ -0,0 +1,172 @@
+package socketserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestEmitSurvivesPanickingConn(t *testing.T) {
+	m := newTestManager(t)
+	a := addClient(m, "a", "")
+	bad := addClient(m, "bad", "")
+	bad.panics = true
+	c := addClient(m, "c", "")
+
+	reached, err := m.Emit("otp", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reached != 2 {
+		t.Fatalf("reached = %d, want 2", reached)
+	}
+	for _, conn := range []*fakeConn{a, c} {
+		if got := conn.events(); len(got) != 1 || got[0].data != "hello" {
+			t.Errorf("client %s got %v", conn.id, got)
+		}
+	}
+	if m.clients.has("bad") {
+		t.Fatal("panicking client still connected")
+	}
+	if n := m.ClientCount(); n != 2 {
+		t.Fatalf("ClientCount = %d, want 2", n)
+	}
+}
+
+func TestEmitToPanickingConn(t *testing.T) {
+	m := newTestManager(t)
+	addClient(m, "bad", "").panics = true
+
+	if err := m.EmitTo("bad", "otp", "hello"); err == nil {
+		t.Fatal("EmitTo a panicking client succeeded")
+	}
+	if m.clients.has("bad") {
+		t.Fatal("panicking client still connected")
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	m := newTestManager(t)
+	a1 := addClient(m, "a1", "tenant-a")
+	a2 := addClient(m, "a2", "tenant-a")
+	b1 := addClient(m, "b1", "tenant-b")
+	for _, conn := range []*fakeConn{a1, a2, b1} {
+		conn.ack = "sent"
+	}
+
+	reached, err := m.EmitToTenant("tenant-a", "otp", "for a")
+	if err != nil || reached != 2 {
+		t.Fatalf("EmitToTenant = %d, %v; want 2", reached, err)
+	}
+	route, err := m.Dispatch("tenant-a", "otp", "dispatched to a")
+	if err != nil || (route.ClientID != "a1" && route.ClientID != "a2") {
+		t.Fatalf("Dispatch = %+v, %v; want a tenant-a client", route, err)
+	}
+	ack, err := m.EmitWithAck(context.Background(), "tenant-a", "otp", "acked by a")
+	if err != nil || (ack.ClientID != "a1" && ack.ClientID != "a2") {
+		t.Fatalf("EmitWithAck = %+v, %v; want a tenant-a client", ack, err)
+	}
+	if got := b1.events(); len(got) != 0 {
+		t.Fatalf("tenant-b client received tenant-a traffic: %v", got)
+	}
+
+	if reached, err := m.EmitToTenant("tenant-c", "otp", "nobody"); err != nil || reached != 0 {
+		t.Fatalf("EmitToTenant(unknown tenant) = %d, %v; want 0", reached, err)
+	}
+	if _, err := m.Dispatch("tenant-c", "otp", "nobody"); !errors.Is(err, ErrNoClients) {
+		t.Fatalf("Dispatch(unknown tenant) error = %v, want ErrNoClients", err)
+	}
+	if _, err := m.EmitWithAck(context.Background(), "tenant-c", "otp", "nobody"); !errors.Is(err, ErrNoClients) {
+		t.Fatalf("EmitWithAck(unknown tenant) error = %v, want ErrNoClients", err)
+	}
+	if n := len(a1.events()) + len(a2.events()) + len(b1.events()); n != 5 {
+		t.Fatalf("%d events delivered, want 5 (two broadcast, one dispatch, two acked sends)", n)
+	}
+}
+
+func TestTenantSnapshots(t *testing.T) {
+	m := newTestManager(t)
+	addClient(m, "a1", "tenant-a")
+	addClient(m, "a2", "tenant-a")
+	addClient(m, "b1", "tenant-b")
+	updateClient(m, "a2", func(c *client) { c.draining = true })
+
+	clients := m.TenantClients("tenant-a")
+	if len(clients) != 2 || clients[0].ID != "a1" || clients[1].ID != "a2" {
+		t.Fatalf("TenantClients(tenant-a) = %+v, want a1 and a2", clients)
+	}
+	st := m.TenantStats("tenant-a")
+	if st.Connected != 2 || st.Draining != 1 || st.Available != 1 {
+		t.Fatalf("TenantStats(tenant-a) = %+v, want 2 connected, 1 draining, 1 available", st)
+	}
+	if st := m.TenantStats("tenant-c"); st.Connected != 0 || len(m.TenantClients("tenant-c")) != 0 {
+		t.Fatalf("unknown tenant sees %d clients, want none", st.Connected)
+	}
+	if st := m.Stats(); st.Connected != 3 {
+		t.Fatalf("Stats().Connected = %d, want 3", st.Connected)
+	}
+}
+
+func TestEmitWhere(t *testing.T) {
+	tests := []struct {
+		name string
+		pred func(ClientInfo) bool
+		want []string
+	}{
+		{name: "all", pred: func(ClientInfo) bool { return true }, want: []string{"a1", "a2", "b1"}},
+		{name: "none", pred: func(ClientInfo) bool { return false }},
+		{name: "tenant", pred: func(c ClientInfo) bool { return c.Tenant == "a" }, want: []string{"a1", "a2"}},
+		{name: "room", pred: func(c ClientInfo) bool { return c.Room == "ashgabat" }, want: []string{"a2"}},
+		{name: "idle", pred: func(c ClientInfo) bool { return !c.Busy }, want: []string{"a1", "a2"}},
+		{name: "draining never matches", pred: func(c ClientInfo) bool { return c.ID == "drained" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			conns := map[string]*fakeConn{
+				"a1":      addClient(m, "a1", "a"),
+				"a2":      addClient(m, "a2", "a"),
+				"b1":      addClient(m, "b1", "b"),
+				"drained": addClient(m, "drained", "a"),
+			}
+			updateClient(m, "a2", func(c *client) { c.room = "ashgabat" })
+			updateClient(m, "b1", func(c *client) { c.busy = true })
+			updateClient(m, "drained", func(c *client) { c.draining = true })
+
+			reached, err := m.EmitWhere(tt.pred, "otp", "hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reached != len(tt.want) {
+				t.Fatalf("reached = %d, want %d", reached, len(tt.want))
+			}
+			want := make(map[string]bool)
+			for _, id := range tt.want {
+				want[id] = true
+			}
+			for id, conn := range conns {
+				if got := len(conn.events()) == 1; got != want[id] {
+					t.Errorf("client %s received = %t, want %t", id, got, want[id])
+				}
+			}
+		})
+	}
+}
+
+func TestEmitWhereFanoutCap(t *testing.T) {
+	t.Setenv("MAX_BROADCAST_FANOUT", "2")
+	m := newTestManager(t)
+	conns := []*fakeConn{addClient(m, "a", ""), addClient(m, "b", ""), addClient(m, "c", "")}
+
+	if _, err := m.EmitWhere(func(ClientInfo) bool { return true }, "otp", "hello"); !errors.Is(err, ErrFanoutExceeded) {
+		t.Fatalf("error = %v, want ErrFanoutExceeded", err)
+	}
+	for _, conn := range conns {
+		if got := conn.events(); len(got) != 0 {
+			t.Fatalf("refused broadcast reached %s", conn.id)
+		}
+	}
+	if reached, err := m.EmitWhere(func(c ClientInfo) bool { return c.ID != "c" }, "otp", "hello"); err != nil || reached != 2 {
+		t.Fatalf("broadcast at the cap = %d, %v; want 2", reached, err)
+	}
+}
@@ -0,0 +1,130 @@
+package socketserver
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+	"unsafe"
+
+	socketio "github.com/googollee/go-socket.io"
+	"github.com/googollee/go-socket.io/engineio"
+)
+
+// Defaults engine.io applies when PingInterval/PingTimeout are left zero.
+const (
+	defaultPingInterval = 20 * time.Second
+	defaultPingTimeout  = time.Minute
+)
+
+// SessionStats describes the engine.io session pool underneath Socket.IO.
+type SessionStats struct {
+	// EngineIO is the number of sessions engine.io itself holds.
+	EngineIO int `json:"engineio_sessions"`
+	// Tracked is the number of sessions created since startup that have not
+	// been reclaimed yet.
+	Tracked int `json:"tracked_sessions"`
+	// Clients is the number of Socket.IO clients in the Manager's map.
+	Clients int `json:"clients"`
+	// Reclaimed counts sessions removed after disconnect or by a sweep.
+	Reclaimed    int    `json:"reclaimed"`
+	PingInterval string `json:"ping_interval"`
+	PingTimeout  string `json:"ping_timeout"`
+}
+
+// go-socket.io v1.7.0 removes an engine.io session from its pool only when
+// the read/write loops of an established connection exit. A connection whose
+// Socket.IO connect fails (e.g. OnConnect rejects it) is closed but its
+// session stays in the pool forever. Nothing on socketio.Server exposes the
+// pool, so engineOf reaches the unexported engine to call its (exported,
+// "experimental") Remove.
+func engineOf(s *socketio.Server) *engineio.Server {
+	f := reflect.ValueOf(s).Elem().FieldByName("engine")
+	if !f.IsValid() || f.Kind() != reflect.Ptr || f.Type() != reflect.TypeOf((*engineio.Server)(nil)) {
+		return nil
+	}
+	return (*engineio.Server)(unsafe.Pointer(f.Pointer()))
+}
+
+// trackSession is the engine.io ConnInitor: it records every new session so
+// it can be reclaimed later.
+func (m *Manager) trackSession(_ *http.Request, c engineio.Conn) {
+	m.mu.Lock()
+	m.sessions[c.ID()] = trackedSession{conn: c, created: time.Now()}
+	m.mu.Unlock()
+}
+
+type trackedSession struct {
+	conn    engineio.Conn
+	created time.Time
+}
+
+// reclaimSession drops sid from engine.io's pool once its connection is gone.
+func (m *Manager) reclaimSession(sid string) {
+	m.mu.Lock()
+	_, tracked := m.sessions[sid]
+	delete(m.sessions, sid)
+	if tracked {
+		m.sessionsReclaimed++
+	}
+	m.mu.Unlock()
+	if m.engine != nil {
+		m.engine.Remove(sid)
+	}
+}
+
+// SweepSessions closes and reclaims engine.io sessions older than the ping
+// timeout that have no live Socket.IO connection — handshakes that never
+// completed, rejected connections, or disconnects whose callback was missed.
+// It returns how many were reclaimed.
+func (m *Manager) SweepSessions() int {
+	cutoff := time.Now().Add(-m.pingTimeout())
+
+	m.mu.Lock()
+	var stale []trackedSession
+	for sid, s := range m.sessions {
+		if s.created.Before(cutoff) && m.Server.RoomLen("/", sid) == 0 {
+			stale = append(stale, s)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, s := range stale {
+		_ = s.conn.Close()
+		m.reclaimSession(s.conn.ID())
+		log.Printf("[SOCKET] Swept stale engine.io session | id=%s | age=%s", s.conn.ID(), time.Since(s.created).Round(time.Second))
+	}
+	if len(stale) > 0 {
+		log.Printf("[SOCKET] Session sweep finished | reclaimed=%d | engineio_sessions=%d", len(stale), m.Server.Count())
+	}
+	return len(stale)
+}
+
+// SessionStats returns the current engine.io session counts.
+func (m *Manager) SessionStats() SessionStats {
+	m.mu.Lock()
+	st := SessionStats{
+		Tracked:      len(m.sessions),
+		Clients:      int(m.gauges.connected.Load()),
+		Reclaimed:    m.sessionsReclaimed,
+		PingInterval: m.pingInterval().String(),
+		PingTimeout:  m.pingTimeout().String(),
+	}
+	m.mu.Unlock()
+	st.EngineIO = m.Server.Count()
+	return st
+}
+
+func (m *Manager) pingInterval() time.Duration {
+	if m.cfg.Get().SocketPingInterval > 0 {
+		return m.cfg.Get().SocketPingInterval
+	}
+	return defaultPingInterval
+}
+
+func (m *Manager) pingTimeout() time.Duration {
+	if m.cfg.Get().SocketPingTimeout > 0 {
+		return m.cfg.Get().SocketPingTimeout
+	}
+	return defaultPingTimeout
+}
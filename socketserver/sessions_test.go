@@ -0,0 +1,145 @@
+package socketserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googollee/go-socket.io/engineio"
+)
+
+var sidPattern = regexp.MustCompile(`"sid":"([^"]+)"`)
+
+// serveTest starts m's Socket.IO server behind an httptest server, polling
+// transport only, so sessions can be driven with plain HTTP.
+func serveTest(t *testing.T, m *Manager) string {
+	t.Helper()
+	go m.Serve()
+	ts := httptest.NewServer(m.Server)
+	t.Cleanup(func() {
+		ts.Close()
+		m.Server.Close()
+	})
+	return ts.URL + "/socket.io/?EIO=3&transport=polling"
+}
+
+// pollRequest does one engine.io polling request and returns the body.
+func pollRequest(t *testing.T, method, url, body string) string {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// handshake opens an engine.io session and returns its ID.
+func handshake(t *testing.T, url string) string {
+	t.Helper()
+	m := sidPattern.FindStringSubmatch(pollRequest(t, http.MethodGet, url, ""))
+	if m == nil {
+		t.Fatal("no session id in handshake")
+	}
+	return m[1]
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionsReclaimedAfterDisconnect(t *testing.T) {
+	const conns = 10
+	t.Setenv("SOCKET_TRANSPORTS", "polling")
+	m := newTestManager(t)
+	url := serveTest(t, m)
+
+	sids := make([]string, conns)
+	for i := range sids {
+		sids[i] = handshake(t, url)
+		pollRequest(t, http.MethodGet, url+"&sid="+sids[i], "")
+	}
+	waitFor(t, "clients to connect", func() bool { return m.ClientCount() == conns })
+	if st := m.SessionStats(); st.EngineIO != conns || st.Tracked != conns {
+		t.Fatalf("before disconnect: %+v", st)
+	}
+
+	for _, sid := range sids {
+		// An engine.io close packet, as sent by a client that disconnects.
+		pollRequest(t, http.MethodPost, url+"&sid="+sid, "1:1")
+	}
+	waitFor(t, "sessions to be reclaimed", func() bool {
+		st := m.SessionStats()
+		return st.EngineIO == 0 && st.Tracked == 0 && st.Clients == 0
+	})
+	if st := m.SessionStats(); st.Reclaimed != conns {
+		t.Fatalf("reclaimed = %d, want %d", st.Reclaimed, conns)
+	}
+}
+
+// ghostSession is an engine.io session whose Socket.IO connection is gone
+// without the disconnect callback having run.
+type ghostSession struct {
+	engineio.Conn
+	id     string
+	closed bool
+}
+
+func (g *ghostSession) ID() string   { return g.id }
+func (g *ghostSession) Close() error { g.closed = true; return nil }
+
+func TestSweepReclaimsOnlyStaleOrphans(t *testing.T) {
+	t.Setenv("SOCKET_TRANSPORTS", "polling")
+	m := newTestManager(t)
+	url := serveTest(t, m)
+
+	live := handshake(t, url)
+	pollRequest(t, http.MethodGet, url+"&sid="+live, "")
+	waitFor(t, "the client to connect", func() bool { return m.ClientCount() == 1 })
+
+	old, young := &ghostSession{id: "old"}, &ghostSession{id: "young"}
+	m.trackSession(nil, old)
+	m.trackSession(nil, young)
+
+	// Age everything but the young orphan past the ping timeout instead of
+	// waiting it out.
+	m.mu.Lock()
+	for sid, s := range m.sessions {
+		if sid != young.id {
+			s.created = s.created.Add(-2 * m.pingTimeout())
+			m.sessions[sid] = s
+		}
+	}
+	m.mu.Unlock()
+
+	if n := m.SweepSessions(); n != 1 {
+		t.Fatalf("swept %d sessions, want 1", n)
+	}
+	if !old.closed || young.closed {
+		t.Fatalf("closed: old=%t young=%t, want only old", old.closed, young.closed)
+	}
+	if st := m.SessionStats(); st.Tracked != 2 || st.Reclaimed != 1 || st.Clients != 1 {
+		t.Fatalf("after sweep: %+v", st)
+	}
+}
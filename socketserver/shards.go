@@ -0,0 +1,68 @@
+package socketserver
+
+import "sync"
+
+// clientShardCount is the number of independently locked partitions of the
+// client map. Well above the core count, so connects, acks and dispatches
+// for different clients rarely wait on each other.
+const clientShardCount = 32
+
+// clientShard is one partition of the client map. Its mutex guards the map
+// and the mutable fields (busy, queue, inflight, draining) of every client
+// in it.
+type clientShard struct {
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+// clientMap is the set of connected clients, sharded by socket ID.
+//
+// Lock order: code holding a shard lock may take Manager.mu, never the other
+// way round, and no code holds two shard locks at once.
+type clientMap struct {
+	shards [clientShardCount]clientShard
+}
+
+func newClientMap() *clientMap {
+	cm := &clientMap{}
+	for i := range cm.shards {
+		cm.shards[i].clients = make(map[string]*client)
+	}
+	return cm
+}
+
+// shard returns the partition holding id (FNV-1a of the ID).
+func (cm *clientMap) shard(id string) *clientShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &cm.shards[h%clientShardCount]
+}
+
+// has reports whether id is connected.
+func (cm *clientMap) has(id string) bool {
+	sh := cm.shard(id)
+	sh.mu.Lock()
+	_, ok := sh.clients[id]
+	sh.mu.Unlock()
+	return ok
+}
+
+// each calls fn for every client, one shard at a time with that shard
+// locked, until fn returns false. The walk is not a consistent snapshot of
+// the whole map: clients may come and go in shards not yet visited.
+func (cm *clientMap) each(fn func(*client) bool) {
+	for i := range cm.shards {
+		sh := &cm.shards[i]
+		sh.mu.Lock()
+		for _, c := range sh.clients {
+			if !fn(c) {
+				sh.mu.Unlock()
+				return
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,107 @@
+package socketserver
+
+import (
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientMapSpreadsAndSums(t *testing.T) {
+	const n = 1000
+	m := newTestManager(t)
+	for i := 0; i < n; i++ {
+		addClient(m, strconv.Itoa(i), "")
+	}
+
+	used := 0
+	for i := range m.clients.shards {
+		if len(m.clients.shards[i].clients) > 0 {
+			used++
+		}
+	}
+	if used != clientShardCount {
+		t.Fatalf("%d of %d shards hold clients", used, clientShardCount)
+	}
+
+	seen := 0
+	m.clients.each(func(*client) bool { seen++; return true })
+	if seen != n || m.Stats().Connected != n || len(m.Clients()) != n {
+		t.Fatalf("each=%d stats=%d clients=%d, want %d", seen, m.Stats().Connected, len(m.Clients()), n)
+	}
+
+	for i := 0; i < n; i += 2 {
+		m.remove(strconv.Itoa(i))
+	}
+	if got := m.Stats().Connected; got != n/2 || m.ClientCount() != n/2 {
+		t.Fatalf("after removing half: stats=%d count=%d, want %d", got, m.ClientCount(), n/2)
+	}
+}
+
+// singleMutexMap is the client map as it was before sharding: one lock for
+// every client. It is kept here as the baseline for the benchmarks below.
+type singleMutexMap struct {
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+var benchID atomic.Int64
+
+// BenchmarkConnectDisconnect registers and removes clients from parallel
+// goroutines, the bookkeeping every connect and disconnect does, on the
+// sharded map and on the single-mutex baseline.
+func BenchmarkConnectDisconnect(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) {
+		cm := newClientMap()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				id := strconv.FormatInt(benchID.Add(1), 10)
+				sh := cm.shard(id)
+				sh.mu.Lock()
+				sh.clients[id] = &client{id: id}
+				sh.mu.Unlock()
+				sh.mu.Lock()
+				delete(sh.clients, id)
+				sh.mu.Unlock()
+			}
+		})
+	})
+	b.Run("single-mutex", func(b *testing.B) {
+		cm := &singleMutexMap{clients: make(map[string]*client)}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				id := strconv.FormatInt(benchID.Add(1), 10)
+				cm.mu.Lock()
+				cm.clients[id] = &client{id: id}
+				cm.mu.Unlock()
+				cm.mu.Lock()
+				delete(cm.clients, id)
+				cm.mu.Unlock()
+			}
+		})
+	})
+}
+
+// BenchmarkDispatchAcrossShards measures Dispatch plus "sended" from parallel
+// goroutines against 1000 connected clients.
+func BenchmarkDispatchAcrossShards(b *testing.B) {
+	m := newTestManager(b)
+	// Dispatch logs every event; keep that out of the measurement.
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	for i := 0; i < 1000; i++ {
+		addClient(m, strconv.Itoa(i), "")
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			route, err := m.Dispatch("", "otp", "x")
+			if err != nil {
+				continue
+			}
+			m.next(route.ClientID)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package socketserver
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ShutdownEvent is broadcast to every gateway when the server is about to
+// close, so it can finish its current message and reconnect elsewhere.
+const ShutdownEvent = "server_shutdown"
+
+// shutdownPoll is how often Shutdown checks for outstanding "sended".
+const shutdownPoll = 100 * time.Millisecond
+
+// Shutdown stops handing out work, tells every client with ShutdownEvent,
+// waits until no client is busy (every dispatched message has been
+// confirmed with "sended") or ctx is done, and then closes the Socket.IO
+// server. Events still queued behind a busy client are not sent.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.serving.Store(false)
+	targets := m.snapshot(func(c *client) bool {
+		c.draining = true
+		return true
+	})
+	reached := m.emitAll(targets, ShutdownEvent, map[string]interface{}{"reason": "shutdown"})
+	log.Printf("[SOCKET] Shutdown notice sent | clients=%d | reached=%d | busy=%d",
+		len(targets), reached, m.gauges.busy.Load())
+
+	tick := time.NewTicker(shutdownPoll)
+	defer tick.Stop()
+	for m.gauges.busy.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("[SOCKET] Shutdown wait ended with messages unconfirmed | busy=%d | queued=%d",
+				m.gauges.busy.Load(), m.gauges.queued.Load())
+			return m.Server.Close()
+		case <-tick.C:
+		}
+	}
+	log.Printf("[SOCKET] All in-flight messages confirmed, closing")
+	return m.Server.Close()
+}
@@ -0,0 +1,83 @@
+package socketserver
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is a dump of the Manager's clients and undelivered messages,
+// written for post-mortem analysis when the process crashes.
+type Snapshot struct {
+	Time    time.Time        `json:"time"`
+	Reason  string           `json:"reason"`
+	Stats   Stats            `json:"stats"`
+	Clients []ClientSnapshot `json:"clients"`
+	// Parked are queues held for devices that dropped transiently.
+	Parked []ParkedSnapshot `json:"parked,omitempty"`
+}
+
+// ClientSnapshot is a client with the message it is working on and those
+// queued behind it.
+type ClientSnapshot struct {
+	ClientInfo
+	Inflight string         `json:"inflight,omitempty"`
+	Pending  []PendingEvent `json:"pending,omitempty"`
+}
+
+// ParkedSnapshot is the queue held for one disconnected device.
+type ParkedSnapshot struct {
+	Tenant  string         `json:"tenant,omitempty"`
+	Device  string         `json:"device"`
+	Pending []PendingEvent `json:"pending"`
+}
+
+// PendingEvent is one event not yet sent to a gateway.
+type PendingEvent struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+func pendingEvents(q []queued) []PendingEvent {
+	out := make([]PendingEvent, len(q))
+	for i, e := range q {
+		out[i] = PendingEvent{Event: e.event, Data: e.data}
+	}
+	return out
+}
+
+// Snapshot captures the current clients, their queues and the parked
+// queues. reason is recorded as given.
+func (m *Manager) Snapshot(reason string) Snapshot {
+	snap := Snapshot{Time: time.Now().UTC(), Reason: reason, Stats: m.Stats()}
+
+	m.clients.each(func(c *client) bool {
+		snap.Clients = append(snap.Clients, ClientSnapshot{
+			ClientInfo: c.info(),
+			Inflight:   c.inflight,
+			Pending:    pendingEvents(c.queue),
+		})
+		return true
+	})
+	sort.Slice(snap.Clients, func(i, j int) bool { return snap.Clients[i].ID < snap.Clients[j].ID })
+
+	m.mu.Lock()
+	for key, p := range m.parked {
+		_, device, _ := strings.Cut(key, "\x00")
+		snap.Parked = append(snap.Parked, ParkedSnapshot{Tenant: p.tenant, Device: device, Pending: pendingEvents(p.pending)})
+	}
+	m.mu.Unlock()
+	return snap
+}
+
+// WriteSnapshot writes Snapshot(reason) as JSON to path, readable by the
+// owner only since it holds phone numbers and message bodies.
+func (m *Manager) WriteSnapshot(path, reason string) error {
+	b, err := json.MarshalIndent(m.Snapshot(reason), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
@@ -0,0 +1,189 @@
+// Package webhook delivers message lifecycle events to caller-supplied
+// callback URLs.
+//
+// Deliveries are asynchronous and never block the request that triggered
+// them. Each event is POSTed as JSON, signed with the tenant's signing key
+// (see package signing) when it has one, and retried with exponential
+// backoff on network errors, 429 and 5xx. Events of one message are sent
+// independently and may arrive out of order; receivers should order them by
+// "time". Redirects are not followed, and callback hosts must be on an
+// allowlist, so a caller cannot point the service at internal addresses.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sms_service/signing"
+)
+
+// Lifecycle statuses reported to callbacks.
+const (
+	// StatusDispatched: handed to a gateway.
+	StatusDispatched = "dispatched"
+	// StatusQueued: no gateway was connected; the message is held and a
+	// dispatched (or, if it expires first, failed) event follows.
+	StatusQueued = "queued"
+	// StatusAcked: a gateway acknowledged the message; a delivered or
+	// failed event follows with its verdict.
+	StatusAcked     = "acked"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	// StatusSent: a gateway reported the SMS sent with "sended". Only the
+	// DELIVERY_WEBHOOK_URL report uses it.
+	StatusSent = "sent"
+)
+
+// ErrHostNotAllowed is returned by CheckURL for hosts outside the allowlist.
+var ErrHostNotAllowed = errors.New("callback host not allowed")
+
+// Event is the JSON body POSTed to a callback URL.
+type Event struct {
+	MessageID string    `json:"message_id"`
+	Status    string    `json:"status"`
+	Phone     string    `json:"phone"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// CheckURL validates a caller-supplied callback URL: it must be absolute
+// http(s) and its host must match allowed. An allowlist entry is a host
+// name, or "*.example.com" for any subdomain (but not example.com itself).
+func CheckURL(raw string, allowed []string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("callback scheme %q not supported", u.Scheme)
+	}
+	if u.User != nil {
+		return errors.New("callback URL must not carry credentials")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("callback URL has no host")
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if suffix, ok := strings.CutPrefix(a, "*"); ok {
+			if strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) {
+				return nil
+			}
+			continue
+		}
+		if host == a {
+			return nil
+		}
+	}
+	return ErrHostNotAllowed
+}
+
+// Sender delivers events in the background.
+type Sender struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+}
+
+// NewSender returns a Sender making up to attempts tries per event, each
+// bounded by timeout, waiting backoff, 2×backoff, ... between them.
+func NewSender(timeout time.Duration, attempts int, backoff time.Duration) *Sender {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &Sender{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		attempts: attempts,
+		backoff:  backoff,
+	}
+}
+
+// Send delivers ev to target in the background, signed with secret unless
+// it is empty. Failures are logged, never returned.
+func (s *Sender) Send(target, secret string, ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[WEBHOOK] Failed to encode event | message_id=%s | error=%v", ev.MessageID, err)
+		return
+	}
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[WEBHOOK][PANIC] Delivery panicked | message_id=%s | panic=%v", ev.MessageID, r)
+			}
+		}()
+
+		wait := s.backoff
+		for attempt := 1; ; attempt++ {
+			err := s.post(target, secret, body)
+			if err == nil {
+				log.Printf("[WEBHOOK] Delivered | message_id=%s | status=%s | attempt=%d", ev.MessageID, ev.Status, attempt)
+				return
+			}
+			if attempt >= s.attempts || !retryable(err) {
+				log.Printf("[WEBHOOK] Delivery failed, giving up | message_id=%s | status=%s | attempts=%d | error=%v",
+					ev.MessageID, ev.Status, attempt, err)
+				return
+			}
+			log.Printf("[WEBHOOK] Delivery failed, retrying | message_id=%s | status=%s | attempt=%d | retry_in=%s | error=%v",
+				ev.MessageID, ev.Status, attempt, wait, err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}()
+}
+
+// statusError is a non-2xx callback response.
+type statusError struct{ code int }
+
+func (e statusError) Error() string { return fmt.Sprintf("callback answered %d", e.code) }
+
+// retryable reports whether a failed delivery is worth another try: network
+// errors, 429 and 5xx are; other 4xx mean the receiver rejected the event.
+func retryable(err error) bool {
+	var se statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	return true
+}
+
+func (s *Sender) post(target, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		now := time.Now()
+		req.Header.Set(signing.HeaderTimestamp, signing.Timestamp(now))
+		req.Header.Set(signing.HeaderSignature, signing.Sign(secret, now, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError{resp.StatusCode}
+	}
+	return nil
+}